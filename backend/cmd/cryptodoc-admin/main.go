@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"main/config"
+	"main/services"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const usage = `Usage: cryptodoc-admin [flags] <command> [args]
+
+Commands:
+  deploy               Deploy a new File contract (signer becomes first owner)
+  add-owner <address>  Authorize an address to register documents
+  owners               List contract owners (from OwnerAdded events)
+  is-owner [address]   Check ownership (defaults to the signing key's address)
+  stats                Print contract statistics
+
+Connection settings come from the selected network (ETH_NETWORKS /
+ETH_DEFAULT_NETWORK, or ETH_RPC_URL and ETH_CONTRACT_ADDR) and can be
+overridden with flags. They are read like the server's: the environment,
+the -env-file and the -config file. The signing key defaults to
+ETH_PRIVATE_KEY.
+`
+
+func main() {
+	configFile := flag.String("config", "", "YAML or TOML configuration file (default $CONFIG_FILE)")
+	envFile := flag.String("env-file", ".env", "dotenv file loaded into the environment")
+	network := flag.String("network", "", "network from ETH_NETWORKS to operate on (default ETH_DEFAULT_NETWORK)")
+	rpcURL := flag.String("rpc", "", "Ethereum RPC URL (overrides the network's)")
+	contractAddr := flag.String("contract", "", "File contract address (overrides the network's)")
+	fromBlock := flag.Uint64("from-block", 0, "first block to scan for events (overrides the network's)")
+	key := flag.String("key", "", "hex private key used to sign transactions (overrides ETH_PRIVATE_KEY)")
+	timeout := flag.Duration("timeout", 5*time.Minute, "maximum time to wait for RPC calls and mining")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg, err := config.LoadEth(*envFile, *configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *key != "" {
+		cfg.PrivateKey = *key
+	}
+	if *network == "" {
+		*network = cfg.DefaultNetwork
+	}
+	if n, ok := cfg.Network(*network); ok {
+		cfg.RPCURL, cfg.ContractAddr, cfg.DeployBlock = n.RPCURL, n.ContractAddr, n.DeployBlock
+	} else if *network != "" {
+		log.Fatalf("Unknown network %q", *network)
+	}
+	if *rpcURL != "" {
+		cfg.RPCURL = *rpcURL
+	}
+	if *contractAddr != "" {
+		cfg.ContractAddr = *contractAddr
+	}
+	if *fromBlock != 0 {
+		cfg.DeployBlock = *fromBlock
+	}
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if cfg.RPCURL == "" {
+		log.Fatal("RPC URL is required (-rpc or ETH_RPC_URL)")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	if cmd == "deploy" {
+		deploy(ctx, cfg)
+		return
+	}
+
+	if cfg.ContractAddr == "" {
+		log.Fatal("Contract address is required (-contract or ETH_CONTRACT_ADDR)")
+	}
+	eth, err := services.NewEthService(cfg.RPCURL, cfg.ContractAddr)
+	if err != nil {
+		log.Fatal("Failed to connect to contract:", err)
+	}
+	eth.Context = ctx
+	eth.DeployBlock = cfg.DeployBlock
+
+	switch cmd {
+	case "add-owner":
+		addOwner(ctx, eth, cfg, args)
+	case "owners":
+		listOwners(eth, cfg)
+	case "is-owner":
+		isOwner(eth, cfg, args)
+	case "stats":
+		stats(eth, cfg)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func requireKey(cfg config.EthConfig) {
+	if cfg.PrivateKey == "" {
+		log.Fatal("Private key is required (-key or ETH_PRIVATE_KEY)")
+	}
+}
+
+func parseAddress(s string) common.Address {
+	if !common.IsHexAddress(s) {
+		log.Fatalf("Invalid address: %s", s)
+	}
+	return common.HexToAddress(s)
+}
+
+func deploy(ctx context.Context, cfg config.EthConfig) {
+	requireKey(cfg)
+
+	fmt.Println("Deploying File contract...")
+	addr, txHash, block, err := services.DeployFileContract(ctx, cfg.RPCURL, cfg.PrivateKey)
+	if err != nil {
+		log.Fatalf("Deployment failed (tx %s): %v", txHash, err)
+	}
+
+	fmt.Printf("Contract deployed\n  address: %s\n  tx:      %s\n  block:   %d\n\n", addr.Hex(), txHash, block)
+	fmt.Printf("Add to .env:\n  ETH_CONTRACT_ADDR=%s\n  ETH_CONTRACT_DEPLOY_BLOCK=%d\n", addr.Hex(), block)
+}
+
+func addOwner(ctx context.Context, eth *services.EthService, cfg config.EthConfig, args []string) {
+	requireKey(cfg)
+	if len(args) != 1 {
+		log.Fatal("Usage: cryptodoc-admin add-owner <address>")
+	}
+	newOwner := parseAddress(args[0])
+
+	txHash, err := eth.AddOwner(cfg.PrivateKey, newOwner)
+	if err != nil {
+		log.Fatal("addOwner failed:", err)
+	}
+	fmt.Printf("addOwner(%s) sent: %s\nWaiting for confirmation...\n", newOwner.Hex(), txHash)
+
+	receipt, err := bind.WaitMinedHash(ctx, eth.Client, common.HexToHash(txHash))
+	if err != nil {
+		log.Fatal("Transaction not confirmed:", err)
+	}
+	if receipt.Status != 1 {
+		log.Fatal("Transaction reverted (is the signer an owner?)")
+	}
+	fmt.Printf("Confirmed in block %d\n", receipt.BlockNumber.Uint64())
+}
+
+func listOwners(eth *services.EthService, cfg config.EthConfig) {
+	var extra []common.Address
+	if cfg.PrivateKey != "" {
+		if signer, err := services.AddressFromPrivateKey(cfg.PrivateKey); err == nil {
+			extra = append(extra, signer)
+		}
+	}
+
+	owners, err := eth.ListOwners(extra...)
+	if err != nil {
+		log.Fatal("Failed to list owners:", err)
+	}
+	if len(owners) == 0 {
+		fmt.Println("No owners found (the deployer is an owner but emits no event; pass -key to check it)")
+		return
+	}
+	for _, o := range owners {
+		fmt.Println(o.Hex())
+	}
+}
+
+func isOwner(eth *services.EthService, cfg config.EthConfig, args []string) {
+	var account common.Address
+	switch {
+	case len(args) == 1:
+		account = parseAddress(args[0])
+	case cfg.PrivateKey != "":
+		signer, err := services.AddressFromPrivateKey(cfg.PrivateKey)
+		if err != nil {
+			log.Fatal("Invalid private key:", err)
+		}
+		account = signer
+	default:
+		log.Fatal("Usage: cryptodoc-admin is-owner <address> (or set -key)")
+	}
+
+	ok, err := eth.IsOwner(account)
+	if err != nil {
+		log.Fatal("isOwner call failed:", err)
+	}
+	fmt.Printf("%s owner: %t\n", account.Hex(), ok)
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func stats(eth *services.EthService, cfg config.EthConfig) {
+	s, err := eth.Stats(cfg.PrivateKey)
+	if err != nil {
+		log.Fatal("Failed to read stats:", err)
+	}
+
+	fmt.Printf("Contract:       %s\n", s.ContractAddress)
+	fmt.Printf("Chain ID:       %s\n", s.ChainID)
+	fmt.Printf("Latest block:   %d\n", s.BlockNumber)
+	fmt.Printf("Documents:      %s\n", s.DocumentCount)
+	if s.Signer != "" {
+		fmt.Printf("Signer:         %s\n", s.Signer)
+		fmt.Printf("Signer owner:   %t\n", s.SignerIsOwner)
+		fmt.Printf("Signer balance: %s ETH\n", s.SignerBalance)
+	}
+}
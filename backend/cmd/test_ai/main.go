@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -32,11 +33,12 @@ func main() {
 		maskedKey = apiKey[:5] + "..." + apiKey[len(apiKey)-3:]
 	}
 	fmt.Printf("Using API Key: %s (Length: %d)\n", maskedKey, len(apiKey))
+	services.InitAI(apiKey)
 
 	// 2. Test AnalyzeDocument
 	fmt.Println("\n--- Testing AnalyzeDocument ---")
 	dummyText := "Este es un contrato de arrendamiento válido desde el 1 de enero de 2024 hasta el 31 de diciembre de 2024. Las partes acuerdan el pago mensual de 1000 USD."
-	analysis, err := services.AnalyzeDocument(dummyText)
+	analysis, err := services.AnalyzeDocument(context.Background(), "", dummyText)
 	if err != nil {
 		fmt.Printf("AnalyzeDocument FAILED: %v\n", err)
 	} else {
@@ -47,7 +49,7 @@ func main() {
 	// 3. Test ChatWithDocument
 	fmt.Println("\n--- Testing ChatWithDocument ---")
 	question := "What is the monthly payment?"
-	answer, err := services.ChatWithDocument(dummyText, question)
+	answer, err := services.ChatWithDocument(context.Background(), "", dummyText, question)
 	if err != nil {
 		fmt.Printf("ChatWithDocument FAILED: %v\n", err)
 	} else {
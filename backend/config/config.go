@@ -1,25 +1,34 @@
 package config
 
 import (
+	"cmp"
 	"log"
+	"net"
 	"os"
-
-	"github.com/joho/godotenv"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type S3Config struct {
 	Endpoint  string
-	AccessKey string
-	SecretKey string
+	AccessKey string `secret:"true"`
+	SecretKey string `secret:"true"`
 	Bucket    string
 	Region    string
+	// Multipart upload tuning: memory used per upload is about
+	// PartSize * Concurrency, whatever the file size.
+	PartSize    int64
+	Concurrency int
+	// ObjectLockMode ("GOVERNANCE" or "COMPLIANCE") mirrors legal holds and
+	// retention onto S3 Object Lock when the bucket has it enabled.
+	ObjectLockMode string
 }
 
+// LoadS3Config reads the storage settings; Config.Validate reports missing
+// credentials.
 func LoadS3Config() S3Config {
-	if err := godotenv.Load(); err != nil {
-		log.Println("Warning: could not load .env:", err)
-	}
-
 	cfg := S3Config{
 		Endpoint:  os.Getenv("S3_ENDPOINT"),
 		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
@@ -27,28 +36,82 @@ func LoadS3Config() S3Config {
 		Bucket:    os.Getenv("S3_BUCKET"),
 		Region:    os.Getenv("AWS_REGION"),
 	}
-
-	if cfg.Endpoint == "" || cfg.AccessKey == "" || cfg.SecretKey == "" || cfg.Bucket == "" {
-		log.Fatal("Missing S3 environment variables")
+	cfg.PartSize = int64(envUint("S3_PART_SIZE_MB", 8)) << 20
+	cfg.Concurrency = int(envUint("S3_UPLOAD_CONCURRENCY", 4))
+	switch mode := strings.ToUpper(os.Getenv("S3_OBJECT_LOCK_MODE")); mode {
+	case "", "GOVERNANCE", "COMPLIANCE":
+		cfg.ObjectLockMode = mode
+	default:
+		invalid("S3_OBJECT_LOCK_MODE: unknown mode %q, Object Lock disabled", mode)
 	}
 
 	return cfg
 }
 
+// NetworkConfig describes one chain the File contract is deployed on.
+type NetworkConfig struct {
+	Name         string
+	ChainID      int64  // optional; checked against the RPC when set
+	RPCURL       string `secret:"url"`
+	ContractAddr string
+	// ExplorerTxURL is a template with a single %s for the tx hash. When empty
+	// the explorer is derived from the chain id.
+	ExplorerTxURL string
+	// Confirmations is the number of blocks a registration needs before it
+	// is reported as confirmed.
+	Confirmations uint64
+	// DeployBlock is the block where the contract was deployed; event scans
+	// (e.g. listing owners) start from here instead of genesis.
+	DeployBlock uint64
+}
+
 type EthConfig struct {
-	RPCURL       string
-	PrivateKey   string
+	RPCURL       string `secret:"url"`
+	PrivateKey   string `secret:"true"`
 	ContractAddr string
+	DeployBlock  uint64
+
+	// Networks lists every chain the backend talks to. New documents are
+	// anchored on DefaultNetwork; the others stay available to verify
+	// documents anchored there before a migration.
+	Networks       []NetworkConfig
+	DefaultNetwork string
 }
 
+// LoadEthConfig reads ETH_NETWORKS (comma separated names) and, for each name,
+// ETH_<NAME>_RPC_URL, ETH_<NAME>_CONTRACT_ADDR, ETH_<NAME>_CHAIN_ID,
+// ETH_<NAME>_EXPLORER_TX_URL, ETH_<NAME>_CONFIRMATIONS and
+// ETH_<NAME>_DEPLOY_BLOCK. Without ETH_NETWORKS a single "default" network is
+// built from ETH_RPC_URL / ETH_CONTRACT_ADDR as before.
 func LoadEthConfig() EthConfig {
-	// .env ya cargado por LoadMinIOConfig o main, pero por seguridad:
-	_ = godotenv.Load()
-
 	cfg := EthConfig{
-		RPCURL:       os.Getenv("ETH_RPC_URL"),
-		PrivateKey:   os.Getenv("ETH_PRIVATE_KEY"),
-		ContractAddr: os.Getenv("ETH_CONTRACT_ADDR"),
+		PrivateKey:     os.Getenv("ETH_PRIVATE_KEY"),
+		DefaultNetwork: os.Getenv("ETH_DEFAULT_NETWORK"),
+	}
+
+	if names := os.Getenv("ETH_NETWORKS"); names != "" {
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			cfg.Networks = append(cfg.Networks, loadNetworkConfig(name, "ETH_"+envName(name)+"_"))
+		}
+	} else if os.Getenv("ETH_RPC_URL") != "" {
+		cfg.Networks = append(cfg.Networks, loadNetworkConfig("default", "ETH_"))
+	}
+
+	if cfg.DefaultNetwork == "" && len(cfg.Networks) > 0 {
+		cfg.DefaultNetwork = cfg.Networks[0].Name
+	}
+
+	// Mirror the default network in the flat fields used by single-network callers.
+	if def, ok := cfg.Network(cfg.DefaultNetwork); ok {
+		cfg.RPCURL = def.RPCURL
+		cfg.ContractAddr = def.ContractAddr
+		cfg.DeployBlock = def.DeployBlock
+	} else if cfg.DefaultNetwork != "" {
+		invalid("ETH_DEFAULT_NETWORK: %q is not listed in ETH_NETWORKS", cfg.DefaultNetwork)
 	}
 
 	if cfg.RPCURL == "" || cfg.PrivateKey == "" || cfg.ContractAddr == "" {
@@ -57,3 +120,395 @@ func LoadEthConfig() EthConfig {
 
 	return cfg
 }
+
+// Network returns the configuration of the named network.
+func (c EthConfig) Network(name string) (NetworkConfig, bool) {
+	for _, n := range c.Networks {
+		if n.Name == name {
+			return n, true
+		}
+	}
+	return NetworkConfig{}, false
+}
+
+func loadNetworkConfig(name, prefix string) NetworkConfig {
+	n := NetworkConfig{
+		Name:          name,
+		RPCURL:        os.Getenv(prefix + "RPC_URL"),
+		ContractAddr:  os.Getenv(prefix + "CONTRACT_ADDR"),
+		ExplorerTxURL: os.Getenv(prefix + "EXPLORER_TX_URL"),
+	}
+	n.ChainID = int64(envUint(prefix+"CHAIN_ID", 0))
+	n.Confirmations = envUint(prefix+"CONFIRMATIONS", 1)
+	n.DeployBlock = envUint(prefix+"DEPLOY_BLOCK", 0)
+	if prefix == "ETH_" {
+		// Single-network setups keep the original variable name.
+		n.DeployBlock = envUint("ETH_CONTRACT_DEPLOY_BLOCK", n.DeployBlock)
+	}
+	return n
+}
+
+// envName turns a network name like "base-sepolia" into "BASE_SEPOLIA".
+func envName(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(name))
+}
+
+func envUint(key string, def uint64) uint64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		invalid("%s: %q is not a non-negative integer, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// Duplicate upload policies, applied when an uploaded file's SHA-256 matches
+// an existing document.
+const (
+	DuplicateReject  = "reject"  // answer 409 with the existing document
+	DuplicateLink    = "link"    // return the existing document, store nothing
+	DuplicateVersion = "version" // new record reusing the stored object and anchor
+)
+
+type UploadConfig struct {
+	// MaxUploadSize is the largest accepted file in bytes.
+	MaxUploadSize int64
+
+	DuplicatePolicy string
+	// DuplicateCheckChain also looks for the hash in DocumentRegistered
+	// events, catching documents anchored by other backends.
+	DuplicateCheckChain bool
+}
+
+func LoadUploadConfig() UploadConfig {
+	cfg := UploadConfig{
+		MaxUploadSize:       int64(envUint("MAX_UPLOAD_SIZE_MB", 100)) << 20,
+		DuplicatePolicy:     strings.ToLower(os.Getenv("DUPLICATE_POLICY")),
+		DuplicateCheckChain: os.Getenv("DUPLICATE_CHECK_CHAIN") == "true",
+	}
+
+	switch cfg.DuplicatePolicy {
+	case DuplicateReject, DuplicateLink, DuplicateVersion:
+	case "":
+		cfg.DuplicatePolicy = DuplicateLink
+	default:
+		invalid("DUPLICATE_POLICY: unknown policy %q, using %q", cfg.DuplicatePolicy, DuplicateLink)
+		cfg.DuplicatePolicy = DuplicateLink
+	}
+
+	return cfg
+}
+
+// EncryptionConfig selects the key manager that wraps per-document data keys.
+// Encryption is off when Provider is empty.
+type EncryptionConfig struct {
+	Provider string // "local"
+	// KeyFile holds the 32-byte master key (hex or base64) used for new documents.
+	KeyFile string
+	// PreviousKeyFiles are older master keys still needed to read documents
+	// encrypted before a rotation.
+	PreviousKeyFiles []string
+}
+
+func LoadEncryptionConfig() EncryptionConfig {
+	cfg := EncryptionConfig{
+		Provider: strings.ToLower(os.Getenv("KMS_PROVIDER")),
+		KeyFile:  os.Getenv("KMS_KEY_FILE"),
+	}
+	for _, f := range strings.Split(os.Getenv("KMS_PREVIOUS_KEY_FILES"), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			cfg.PreviousKeyFiles = append(cfg.PreviousKeyFiles, f)
+		}
+	}
+	if cfg.Provider == "" && cfg.KeyFile != "" {
+		cfg.Provider = "local"
+	}
+	return cfg
+}
+
+// PreviewConfig controls how document content is served.
+type PreviewConfig struct {
+	// SigningKey signs content URLs so they can be opened without an
+	// Authorization header (e.g. in an iframe). A random key is used when
+	// empty, which invalidates links on restart.
+	SigningKey string `secret:"true"`
+	URLTTL     time.Duration
+	// VerifyHash re-hashes full downloads and cuts the response short when
+	// the stored object no longer matches the anchored hash.
+	VerifyHash bool
+
+	// Share links expire after ShareDefaultTTL unless the creator asks for
+	// another expiry, which may not exceed ShareMaxTTL.
+	ShareDefaultTTL time.Duration
+	ShareMaxTTL     time.Duration
+}
+
+func LoadPreviewConfig() PreviewConfig {
+	return PreviewConfig{
+		SigningKey: os.Getenv("PREVIEW_SIGNING_KEY"),
+		URLTTL:     time.Duration(envUint("PREVIEW_URL_TTL_MINUTES", 60)) * time.Minute,
+		VerifyHash: os.Getenv("PREVIEW_VERIFY_HASH") == "true",
+
+		ShareDefaultTTL: time.Duration(envUint("SHARE_DEFAULT_TTL_HOURS", 72)) * time.Hour,
+		ShareMaxTTL:     time.Duration(envUint("SHARE_MAX_TTL_DAYS", 30)) * 24 * time.Hour,
+	}
+}
+
+// RetentionConfig controls how long deleted documents stay in the trash.
+type RetentionConfig struct {
+	// TrashRetention is how long a deleted document can be restored before
+	// the purger erases it. Zero disables automatic purging.
+	TrashRetention time.Duration
+	PurgeInterval  time.Duration
+	// CategoryRetention is the minimum time documents of a category are kept
+	// after upload, e.g. CATEGORY_RETENTION_DAYS="Contract=3650,Invoice=2555".
+	CategoryRetention map[string]time.Duration
+}
+
+func LoadRetentionConfig() RetentionConfig {
+	cfg := RetentionConfig{
+		TrashRetention: time.Duration(envUint("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour,
+		PurgeInterval:  time.Duration(max(envUint("TRASH_PURGE_INTERVAL_MINUTES", 60), 1)) * time.Minute,
+
+		CategoryRetention: make(map[string]time.Duration),
+	}
+	for _, entry := range strings.Split(os.Getenv("CATEGORY_RETENTION_DAYS"), ",") {
+		category, days, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(days), 10, 64)
+		if err != nil {
+			invalid("CATEGORY_RETENTION_DAYS: invalid days for category %q", category)
+			continue
+		}
+		cfg.CategoryRetention[strings.TrimSpace(category)] = time.Duration(n) * 24 * time.Hour
+	}
+	return cfg
+}
+
+// LifecycleConfig moves document objects that nobody opened for a while to
+// a cheaper archive tier. Tiering is off when Rules is empty.
+type LifecycleConfig struct {
+	// Rules maps a category (or "*" for any other) to how long its objects
+	// may go unopened before they are archived, e.g.
+	// LIFECYCLE_RULES="Scan=90,*=365" (days).
+	Rules    map[string]time.Duration
+	Interval time.Duration
+
+	// Archived objects are copied to ArchiveBucket (the main bucket when
+	// empty) under ArchivePrefix, with ArchiveStorageClass if set. At least
+	// one of them must differ from where objects live normally.
+	ArchiveBucket       string
+	ArchivePrefix       string
+	ArchiveStorageClass string
+	// RestoreDays is how long objects in GLACIER or DEEP_ARCHIVE stay
+	// readable after a restore was requested.
+	RestoreDays int64
+}
+
+func LoadLifecycleConfig() LifecycleConfig {
+	cfg := LifecycleConfig{
+		Rules:               make(map[string]time.Duration),
+		Interval:            time.Duration(max(envUint("LIFECYCLE_INTERVAL_HOURS", 24), 1)) * time.Hour,
+		ArchiveBucket:       os.Getenv("ARCHIVE_BUCKET"),
+		ArchivePrefix:       os.Getenv("ARCHIVE_PREFIX"),
+		ArchiveStorageClass: strings.ToUpper(os.Getenv("ARCHIVE_STORAGE_CLASS")),
+		RestoreDays:         int64(max(envUint("ARCHIVE_RESTORE_DAYS", 7), 1)),
+	}
+	for _, entry := range strings.Split(os.Getenv("LIFECYCLE_RULES"), ",") {
+		category, days, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(days), 10, 64)
+		if err != nil || n == 0 {
+			invalid("LIFECYCLE_RULES: invalid days for category %q, ignored", category)
+			continue
+		}
+		cfg.Rules[strings.TrimSpace(category)] = time.Duration(n) * 24 * time.Hour
+	}
+
+	if len(cfg.Rules) > 0 && cfg.ArchiveBucket == "" && cfg.ArchivePrefix == "" && cfg.ArchiveStorageClass == "" {
+		cfg.ArchivePrefix = "archive/"
+	}
+	return cfg
+}
+
+// ScrubConfig schedules re-hashing of stored objects against the metadata
+// and on-chain hashes. Scrubbing is off when Interval is zero.
+type ScrubConfig struct {
+	Interval time.Duration
+	// RateLimit caps download throughput in bytes per second so scrubbing
+	// does not compete with users.
+	RateLimit int64
+	// AlertWebhook receives a JSON POST for every failed check.
+	AlertWebhook string `secret:"url"`
+}
+
+func LoadScrubConfig() ScrubConfig {
+	return ScrubConfig{
+		Interval:     time.Duration(envUint("SCRUB_INTERVAL_HOURS", 24)) * time.Hour,
+		RateLimit:    int64(envUint("SCRUB_RATE_MB_S", 5)) << 20,
+		AlertWebhook: os.Getenv("SCRUB_ALERT_WEBHOOK"),
+	}
+}
+
+// defaultUICSP allows what public/index.html needs: its inline script and
+// styles, ethers.js from cdnjs and calls to the API on localhost:8080.
+const defaultUICSP = "default-src 'self'; script-src 'self' 'unsafe-inline' https://cdnjs.cloudflare.com; " +
+	"style-src 'self' 'unsafe-inline'; img-src 'self' data:; connect-src 'self' http://localhost:8080; " +
+	"object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// SecurityConfig controls CORS and the security headers of every response.
+type SecurityConfig struct {
+	// AllowedOrigins may list exact origins, wildcard subdomains like
+	// https://*.example.com, or "*" for any origin.
+	AllowedOrigins []string
+	// AllowCredentials lets browsers send cookies and auth headers
+	// cross-origin. It cannot be combined with "*".
+	AllowCredentials bool
+	// PreflightMaxAge is how long browsers may cache a preflight answer.
+	PreflightMaxAge time.Duration
+	// HSTSMaxAge is sent in Strict-Transport-Security on HTTPS requests
+	// (directly or behind a proxy setting X-Forwarded-Proto); zero disables it.
+	HSTSMaxAge time.Duration
+	// UIContentSecurityPolicy is sent with the bundled UI at /.
+	UIContentSecurityPolicy string
+	// TrustedProxies are the IPs or CIDR ranges of reverse proxies whose
+	// X-Forwarded-For is believed. Without any the client IP is the peer
+	// address, so clients cannot forge the IP of audit entries.
+	TrustedProxies []string
+}
+
+func LoadSecurityConfig() SecurityConfig {
+	cfg := SecurityConfig{
+		AllowCredentials:        os.Getenv("CORS_ALLOW_CREDENTIALS") == "true",
+		PreflightMaxAge:         time.Duration(envUint("CORS_MAX_AGE_SECONDS", 600)) * time.Second,
+		HSTSMaxAge:              time.Duration(envUint("HSTS_MAX_AGE_SECONDS", 31536000)) * time.Second,
+		UIContentSecurityPolicy: cmp.Or(os.Getenv("UI_CONTENT_SECURITY_POLICY"), defaultUICSP),
+	}
+	origins := os.Getenv("CORS_ALLOWED_ORIGINS")
+	if origins == "" {
+		origins = "http://localhost:3000"
+	}
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimSuffix(strings.TrimSpace(o), "/"); o != "" {
+			cfg.AllowedOrigins = append(cfg.AllowedOrigins, o)
+		}
+	}
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if net.ParseIP(p) == nil {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				invalid("TRUSTED_PROXIES: %q is not an IP or CIDR range", p)
+				continue
+			}
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, p)
+	}
+	if cfg.AllowCredentials && slices.Contains(cfg.AllowedOrigins, "*") {
+		invalid("CORS_ALLOW_CREDENTIALS: ignored with CORS_ALLOWED_ORIGINS=*; list the origins instead")
+		cfg.AllowCredentials = false
+	}
+	return cfg
+}
+
+// LimitsConfig keeps users from starving each other. Rates are requests per
+// minute, counted per API key or, for browser sessions, per user. Quotas of
+// zero are unlimited.
+type LimitsConfig struct {
+	UploadsPerMinute    int
+	ChatPerMinute       int
+	RegeneratePerMinute int
+	// SharePasswordPerMinute and SharePasswordPerIPMinute cap password
+	// attempts on a protected share link, per link and per client IP.
+	SharePasswordPerMinute   int
+	SharePasswordPerIPMinute int
+	// UserStorageQuota and OrgStorageQuota cap the bytes stored in a personal
+	// vault and in an organization, trash and old versions included.
+	UserStorageQuota int64
+	OrgStorageQuota  int64
+	// MonthlyTokenQuota caps the LLM tokens spent for each user per calendar
+	// month (UTC), uploads, chat and regeneration together.
+	MonthlyTokenQuota int64
+}
+
+func LoadLimitsConfig() LimitsConfig {
+	return LimitsConfig{
+		UploadsPerMinute:         int(envUint("RATE_LIMIT_UPLOADS_PER_MINUTE", 10)),
+		ChatPerMinute:            int(envUint("RATE_LIMIT_CHAT_PER_MINUTE", 20)),
+		RegeneratePerMinute:      int(envUint("RATE_LIMIT_REGENERATE_PER_MINUTE", 5)),
+		SharePasswordPerMinute:   int(envUint("RATE_LIMIT_SHARE_PASSWORD_PER_MINUTE", 5)),
+		SharePasswordPerIPMinute: int(envUint("RATE_LIMIT_SHARE_PASSWORD_PER_IP_MINUTE", 20)),
+		UserStorageQuota:         int64(envUint("STORAGE_QUOTA_MB_PER_USER", 0)) << 20,
+		OrgStorageQuota:          int64(envUint("STORAGE_QUOTA_MB_PER_ORG", 0)) << 20,
+		MonthlyTokenQuota:        int64(envUint("LLM_MONTHLY_TOKENS_PER_USER", 0)),
+	}
+}
+
+// AuditConfig schedules anchoring of the audit log's chain head on the
+// default network. Anchoring is off when AnchorInterval is zero.
+type AuditConfig struct {
+	AnchorInterval time.Duration
+}
+
+func LoadAuditConfig() AuditConfig {
+	return AuditConfig{
+		AnchorInterval: time.Duration(envUint("AUDIT_ANCHOR_INTERVAL_MINUTES", 60)) * time.Minute,
+	}
+}
+
+// AuthConfig controls which document routes need a token and how tokens are
+// verified. HS256 tokens are checked with JWTSecret; RS256 and ES256 tokens
+// with the keys of a JWKS endpoint or file.
+type AuthConfig struct {
+	// AnonymousVerify lets anyone fetch GET /documents/:id/proof, so third
+	// parties can verify a document's anchor without an account.
+	AnonymousVerify bool
+	// LegacyOwner is assigned the documents stored before ownership was
+	// recorded; without it they are visible to nobody.
+	LegacyOwner string
+
+	JWTSecret string `secret:"true"`
+	// JWKSURL is fetched again every JWKSRefresh, and right away when a token
+	// names an unknown key id. JWKSFile is read the same way.
+	JWKSURL     string
+	JWKSFile    string
+	JWKSRefresh time.Duration
+	// Issuer and Audience are checked when set.
+	Issuer   string
+	Audience string
+	// ClockSkew is the leeway allowed on exp, nbf and iat.
+	ClockSkew time.Duration
+	// RoleClaim names the claim copied to the "role" context value.
+	RoleClaim string
+	// ComplianceRole is the role claim value allowed to place and release
+	// legal holds and set retention.
+	ComplianceRole string
+	// APIKeyRateLimit is the default requests per minute of an API key.
+	APIKeyRateLimit int
+}
+
+func LoadAuthConfig() AuthConfig {
+	return AuthConfig{
+		AnonymousVerify: os.Getenv("AUTH_ANONYMOUS_VERIFY") == "true",
+		LegacyOwner:     os.Getenv("AUTH_LEGACY_OWNER"),
+		JWTSecret:       os.Getenv("SUPABASE_JWT_SECRET"),
+		JWKSURL:         os.Getenv("AUTH_JWKS_URL"),
+		JWKSFile:        os.Getenv("AUTH_JWKS_FILE"),
+		JWKSRefresh:     time.Duration(max(envUint("AUTH_JWKS_REFRESH_MINUTES", 60), 1)) * time.Minute,
+		Issuer:          os.Getenv("AUTH_ISSUER"),
+		Audience:        os.Getenv("AUTH_AUDIENCE"),
+		ClockSkew:       time.Duration(envUint("AUTH_CLOCK_SKEW_SECONDS", 60)) * time.Second,
+		RoleClaim:       cmp.Or(os.Getenv("AUTH_ROLE_CLAIM"), "role"),
+		ComplianceRole:  cmp.Or(os.Getenv("AUTH_COMPLIANCE_ROLE"), "compliance"),
+		APIKeyRateLimit: int(envUint("API_KEY_RATE_LIMIT_PER_MINUTE", 120)),
+	}
+}
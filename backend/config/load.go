@@ -0,0 +1,379 @@
+package config
+
+import (
+	"cmp"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the whole backend configuration. Load builds it once at startup
+// and main hands each part to the service that uses it.
+type Config struct {
+	Port string
+	// ShutdownTimeout bounds how long in-flight requests and background
+	// work may take to finish after SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration
+	// DataDir holds the JSON stores, the audit log and the text cache.
+	DataDir string
+	// AdminToken enables the /admin routes, sent in X-Admin-Token.
+	AdminToken string `secret:"true"`
+	OpenAIKey  string `secret:"true"`
+
+	S3         S3Config
+	Eth        EthConfig
+	Auth       AuthConfig
+	Upload     UploadConfig
+	Encryption EncryptionConfig
+	Preview    PreviewConfig
+	Retention  RetentionConfig
+	Lifecycle  LifecycleConfig
+	Scrub      ScrubConfig
+	Security   SecurityConfig
+	Limits     LimitsConfig
+	Audit      AuditConfig
+
+	// PrintOnly is set by -print-config: print the configuration and exit.
+	PrintOnly bool
+}
+
+// problems collects malformed values while Load runs, so they stop the
+// server instead of silently falling back to defaults. Other callers of the
+// LoadX functions get a warning as before.
+var problems *[]error
+
+func invalid(format string, args ...any) {
+	err := fmt.Errorf(format, args...)
+	if problems != nil {
+		*problems = append(*problems, err)
+		return
+	}
+	log.Println("Warning:", err)
+}
+
+// Load reads the configuration from, by precedence, command-line flags, the
+// environment (including .env) and a YAML or TOML file, then validates it.
+// The returned error lists every problem found.
+//
+// File keys are the environment variable names, either flat (S3_BUCKET) or
+// nested (s3: {bucket: ...}); lists become comma-separated values.
+func Load(args []string) (Config, error) {
+	fs := flag.NewFlagSet("cryptodoc", flag.ContinueOnError)
+	file := fs.String("config", "", "YAML or TOML configuration file (default $CONFIG_FILE)")
+	envFile := fs.String("env-file", ".env", "dotenv file loaded into the environment")
+	port := fs.String("port", "", "HTTP port (overrides PORT)")
+	dataDir := fs.String("data-dir", "", "directory of the JSON stores and text cache (overrides DATA_DIR)")
+	printOnly := fs.Bool("print-config", false, "print the configuration with secrets redacted and exit")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if err := loadSources(*envFile, *file); err != nil {
+		return Config{}, err
+	}
+	if *port != "" {
+		os.Setenv("PORT", *port)
+	}
+	if *dataDir != "" {
+		os.Setenv("DATA_DIR", *dataDir)
+	}
+
+	var errs []error
+	problems = &errs
+	defer func() { problems = nil }()
+
+	cfg := Config{
+		Port:            cmp.Or(os.Getenv("PORT"), "8080"),
+		ShutdownTimeout: time.Duration(envUint("SHUTDOWN_TIMEOUT_SECONDS", 30)) * time.Second,
+		DataDir:         cmp.Or(os.Getenv("DATA_DIR"), "."),
+		AdminToken:      os.Getenv("ADMIN_API_TOKEN"),
+		OpenAIKey:       os.Getenv("OPENAI_API_KEY"),
+
+		S3:         LoadS3Config(),
+		Eth:        LoadEthConfig(),
+		Auth:       LoadAuthConfig(),
+		Upload:     LoadUploadConfig(),
+		Encryption: LoadEncryptionConfig(),
+		Preview:    LoadPreviewConfig(),
+		Retention:  LoadRetentionConfig(),
+		Lifecycle:  LoadLifecycleConfig(),
+		Scrub:      LoadScrubConfig(),
+		Security:   LoadSecurityConfig(),
+		Limits:     LoadLimitsConfig(),
+		Audit:      LoadAuditConfig(),
+
+		PrintOnly: *printOnly,
+	}
+	errs = append(errs, cfg.Validate())
+	if err := errors.Join(errs...); err != nil {
+		return cfg, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// LoadEth is Load for tools that only talk to the chain, like
+// cryptodoc-admin: the same sources and precedence, but only the Ethereum
+// settings are read and checked. Contract addresses may be missing, for
+// deploying one.
+func LoadEth(envFile, file string) (EthConfig, error) {
+	if err := loadSources(envFile, file); err != nil {
+		return EthConfig{}, err
+	}
+
+	var errs []error
+	problems = &errs
+	defer func() { problems = nil }()
+
+	cfg := LoadEthConfig()
+	errs = append(errs, cfg.validate(false))
+	if err := errors.Join(errs...); err != nil {
+		return cfg, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// loadSources loads the dotenv file, which may be missing when it is the
+// default .env, and then the configuration file named by file or
+// CONFIG_FILE.
+func loadSources(envFile, file string) error {
+	if err := godotenv.Load(envFile); err != nil {
+		if envFile != ".env" || !os.IsNotExist(err) {
+			return fmt.Errorf("load %s: %w", envFile, err)
+		}
+		log.Println("No .env file found")
+	}
+	if path := cmp.Or(file, os.Getenv("CONFIG_FILE")); path != "" {
+		if err := loadFile(path); err != nil {
+			return fmt.Errorf("load %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// loadFile sets the variables of a YAML or TOML file that are not already
+// in the environment.
+func loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return errors.New("unknown format, use .yaml, .yml or .toml")
+	}
+	if err != nil {
+		return err
+	}
+
+	env := make(map[string]string)
+	flatten("", values, env)
+	for key, value := range env {
+		if _, set := os.LookupEnv(key); !set {
+			os.Setenv(key, value)
+		}
+	}
+	return nil
+}
+
+// flatten turns nested keys into variable names: {eth: {rpc_url: x}} sets
+// ETH_RPC_URL.
+func flatten(prefix string, v any, env map[string]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			name := envName(key)
+			if prefix != "" {
+				name = prefix + "_" + name
+			}
+			flatten(name, value, env)
+		}
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		env[prefix] = strings.Join(items, ",")
+	case nil:
+	default:
+		env[prefix] = fmt.Sprint(v)
+	}
+}
+
+// Path returns the location of a state file in DataDir.
+func (c Config) Path(name string) string {
+	return filepath.Join(c.DataDir, name)
+}
+
+// Validate checks settings that parse but cannot work together.
+func (c Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		add("PORT: %q is not a valid port", c.Port)
+	}
+
+	var missing []string
+	for _, v := range [][2]string{
+		{"S3_ENDPOINT", c.S3.Endpoint},
+		{"AWS_ACCESS_KEY_ID", c.S3.AccessKey},
+		{"AWS_SECRET_ACCESS_KEY", c.S3.SecretKey},
+		{"S3_BUCKET", c.S3.Bucket},
+	} {
+		if v[1] == "" {
+			missing = append(missing, v[0])
+		}
+	}
+	if len(missing) > 0 {
+		add("storage: %s not set", strings.Join(missing, ", "))
+	}
+	if c.S3.PartSize < 5<<20 {
+		add("S3_PART_SIZE_MB: parts must be at least 5 MB")
+	}
+	if c.S3.Concurrency < 1 {
+		add("S3_UPLOAD_CONCURRENCY: must be at least 1")
+	}
+
+	if err := c.Eth.validate(true); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Without a key every authenticated route would answer 500
+	if c.Auth.JWTSecret == "" && c.Auth.JWKSURL == "" && c.Auth.JWKSFile == "" {
+		add("auth: set SUPABASE_JWT_SECRET or AUTH_JWKS_URL/AUTH_JWKS_FILE")
+	}
+	if c.Auth.JWKSURL != "" {
+		if u, err := url.Parse(c.Auth.JWKSURL); err != nil || u.Host == "" {
+			add("AUTH_JWKS_URL: %q is not a URL", c.Auth.JWKSURL)
+		}
+	}
+
+	switch c.Encryption.Provider {
+	case "":
+	case "local":
+		if c.Encryption.KeyFile == "" {
+			add("KMS_KEY_FILE: required by KMS_PROVIDER=local")
+		}
+	default:
+		add("KMS_PROVIDER: unknown provider %q", c.Encryption.Provider)
+	}
+
+	if c.Preview.ShareDefaultTTL > c.Preview.ShareMaxTTL {
+		add("SHARE_DEFAULT_TTL_HOURS: longer than SHARE_MAX_TTL_DAYS")
+	}
+	if c.Scrub.AlertWebhook != "" {
+		if u, err := url.Parse(c.Scrub.AlertWebhook); err != nil || u.Host == "" {
+			add("SCRUB_ALERT_WEBHOOK: not a URL")
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks the signing key and every network. Without
+// requireContract a missing contract address is accepted.
+func (c EthConfig) validate(requireContract bool) error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if pk := strings.TrimPrefix(c.PrivateKey, "0x"); pk != "" {
+		if key, err := hex.DecodeString(pk); err != nil || len(key) != 32 {
+			add("ETH_PRIVATE_KEY: expected 32 bytes in hex")
+		}
+	}
+	for _, n := range c.Networks {
+		prefix := "ETH_" + envName(n.Name) + "_"
+		if n.Name == "default" && len(c.Networks) == 1 {
+			prefix = "ETH_"
+		}
+		if n.RPCURL == "" {
+			add("%sRPC_URL: not set for network %q", prefix, n.Name)
+		}
+		if (requireContract || n.ContractAddr != "") && !common.IsHexAddress(n.ContractAddr) {
+			add("%sCONTRACT_ADDR: %q is not an address", prefix, n.ContractAddr)
+		}
+		if n.ExplorerTxURL != "" && strings.Count(n.ExplorerTxURL, "%s") != 1 {
+			add("%sEXPLORER_TX_URL: needs exactly one %%s for the tx hash", prefix)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted prints the configuration one setting per line, with secrets
+// masked, for logs and -print-config. Fields tagged secret:"true" are hidden
+// whole; secret:"url" keeps the scheme and host of a URL and hides its
+// credentials, path and query, where providers put API keys.
+func (c Config) Redacted() string {
+	var b strings.Builder
+	writeFields(&b, "", reflect.ValueOf(c))
+	return b.String()
+}
+
+func writeFields(b *strings.Builder, prefix string, v reflect.Value) {
+	t := v.Type()
+	for i := range t.NumField() {
+		f, value := t.Field(i), v.Field(i)
+		if !f.IsExported() || f.Name == "PrintOnly" {
+			continue
+		}
+		name := prefix + f.Name
+		switch {
+		case value.IsZero() && value.Kind() != reflect.Struct:
+			fmt.Fprintf(b, "%s: \n", name)
+		case f.Tag.Get("secret") == "true":
+			fmt.Fprintf(b, "%s: [redacted]\n", name)
+		case f.Tag.Get("secret") == "url":
+			fmt.Fprintf(b, "%s: %s\n", name, redactURL(value.String()))
+		case value.Kind() == reflect.Struct:
+			writeFields(b, name+".", value)
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct:
+			for j := range value.Len() {
+				writeFields(b, fmt.Sprintf("%s[%d].", name, j), value.Index(j))
+			}
+		default:
+			fmt.Fprintf(b, "%s: %v\n", name, value.Interface())
+		}
+	}
+}
+
+// redactURL keeps what identifies the server of a URL and masks the rest.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "[redacted]"
+	}
+	s := u.Scheme + "://"
+	if u.User != nil {
+		s += "[redacted]@"
+	}
+	s += u.Host
+	if strings.Trim(u.EscapedPath(), "/") != "" {
+		s += "/[redacted]"
+	}
+	if u.RawQuery != "" {
+		s += "?[redacted]"
+	}
+	return s
+}
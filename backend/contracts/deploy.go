@@ -0,0 +1,35 @@
+package contracts
+
+import (
+	_ "embed"
+	"errors"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ContractsBin is the compiled bytecode of Files.sol, as produced by solc.
+//
+//go:embed contract.bin
+var ContractsBin string
+
+// DeployContracts deploys a new File contract, binding an instance of Contracts to it.
+// The account behind auth becomes the first owner of the contract.
+func DeployContracts(auth *bind.TransactOpts, backend bind.ContractBackend) (common.Address, *types.Transaction, *Contracts, error) {
+	parsed, err := ContractsMetaData.GetAbi()
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	if parsed == nil {
+		return common.Address{}, nil, nil, errors.New("GetABI returned nil")
+	}
+
+	bytecode := common.FromHex(strings.TrimSpace(ContractsBin))
+	address, tx, contract, err := bind.DeployContract(auth, *parsed, bytecode, backend)
+	if err != nil {
+		return common.Address{}, nil, nil, err
+	}
+	return address, tx, &Contracts{ContractsCaller: ContractsCaller{contract: contract}, ContractsTransactor: ContractsTransactor{contract: contract}, ContractsFilterer: ContractsFilterer{contract: contract}}, nil
+}
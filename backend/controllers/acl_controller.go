@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func aclErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrDocumentMissing), errors.Is(err, services.ErrGrantNotFound), errors.Is(err, services.ErrOrgNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrACLSubject), errors.Is(err, services.ErrUnknownRole):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GET /documents/:id/acl
+func (dc *DocumentController) ListACLHandler(c *gin.Context) {
+	meta, found := dc.userDocument(c, c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	if !dc.permitted(c, meta, services.PermAudit) {
+		return
+	}
+	acl := meta.ACL
+	if acl == nil {
+		acl = []services.ACLEntry{}
+	}
+	c.JSON(http.StatusOK, acl)
+}
+
+// POST /documents/:id/acl grants a user or group access to the document, or
+// changes the level of an existing grant. Only owners can share this way.
+func (dc *DocumentController) GrantHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !dc.requirePermission(c, id, services.PermManage) {
+		return
+	}
+	var req struct {
+		Subject   string `json:"subject"`
+		SubjectID string `json:"subjectId" binding:"required"`
+		Level     string `json:"level"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "subjectId is required"})
+		return
+	}
+	if req.Subject == "" {
+		req.Subject = services.SubjectUser
+	}
+	if req.Level == "" {
+		req.Level = services.RoleViewer
+	}
+
+	user := c.GetString("userID")
+	entry, err := dc.Store.Grant(id, services.ACLEntry{
+		Subject:   req.Subject,
+		SubjectID: req.SubjectID,
+		Level:     req.Level,
+		GrantedBy: user,
+	})
+	if err != nil {
+		c.JSON(aclErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Document %s: %s %s granted %s by %s\n", id, entry.Subject, entry.SubjectID, entry.Level, user)
+	c.JSON(http.StatusCreated, entry)
+}
+
+// DELETE /documents/:id/acl/:grantId revokes a grant. Users may also drop a
+// grant made to themselves.
+func (dc *DocumentController) RevokeGrantHandler(c *gin.Context) {
+	id := c.Param("id")
+	meta, found := dc.userDocument(c, id)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	user := c.GetString("userID")
+	own := false
+	for _, e := range meta.ACL {
+		own = own || (e.ID == c.Param("grantId") && e.Subject == services.SubjectUser && e.SubjectID == user)
+	}
+	if !own && !dc.permitted(c, meta, services.PermManage) {
+		return
+	}
+
+	entry, err := dc.Store.RevokeGrant(id, c.Param("grantId"))
+	if err != nil {
+		c.JSON(aclErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Document %s: grant to %s %s revoked by %s\n", id, entry.Subject, entry.SubjectID, user)
+	c.JSON(http.StatusOK, gin.H{"message": "Access revoked", "grant": entry})
+}
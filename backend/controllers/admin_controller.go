@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"main/services"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+type AdminController struct {
+	PrivateKey string
+}
+
+func NewAdminController(privateKey string) *AdminController {
+	return &AdminController{
+		PrivateKey: privateKey,
+	}
+}
+
+// requireEth returns the default network, answering 503 when it is not available.
+func (ac *AdminController) requireEth(c *gin.Context) (*services.EthService, bool) {
+	eth := services.DefaultEth()
+	if eth == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Blockchain service not available"})
+		return nil, false
+	}
+	return eth, true
+}
+
+// GET /admin/contract
+func (ac *AdminController) ContractStats(c *gin.Context) {
+	eth, ok := ac.requireEth(c)
+	if !ok {
+		return
+	}
+
+	stats, err := eth.Stats(ac.PrivateKey)
+	if err != nil {
+		log.Printf("Failed to read contract stats: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read contract stats"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// GET /admin/owners
+func (ac *AdminController) ListOwners(c *gin.Context) {
+	eth, ok := ac.requireEth(c)
+	if !ok {
+		return
+	}
+
+	var extra []common.Address
+	if ac.PrivateKey != "" {
+		if signer, err := services.AddressFromPrivateKey(ac.PrivateKey); err == nil {
+			extra = append(extra, signer)
+		}
+	}
+
+	owners, err := eth.ListOwners(extra...)
+	if err != nil {
+		log.Printf("Failed to list owners: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to list owners"})
+		return
+	}
+
+	list := make([]string, 0, len(owners))
+	for _, o := range owners {
+		list = append(list, o.Hex())
+	}
+	c.JSON(http.StatusOK, gin.H{"owners": list})
+}
+
+// GET /admin/owners/:address
+func (ac *AdminController) IsOwner(c *gin.Context) {
+	eth, ok := ac.requireEth(c)
+	if !ok {
+		return
+	}
+
+	address := c.Param("address")
+	if !common.IsHexAddress(address) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address"})
+		return
+	}
+
+	isOwner, err := eth.IsOwner(common.HexToAddress(address))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to query contract"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"address": common.HexToAddress(address).Hex(), "isOwner": isOwner})
+}
+
+// POST /admin/owners
+func (ac *AdminController) AddOwner(c *gin.Context) {
+	eth, ok := ac.requireEth(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Address string `json:"address"`
+	}
+	if err := c.BindJSON(&req); err != nil || !common.IsHexAddress(req.Address) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid address is required"})
+		return
+	}
+	if ac.PrivateKey == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Backend signing key not configured"})
+		return
+	}
+
+	txHash, err := eth.AddOwner(ac.PrivateKey, common.HexToAddress(req.Address))
+	if err != nil {
+		log.Printf("addOwner failed: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "addOwner transaction failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Owner added",
+		"address": common.HexToAddress(req.Address).Hex(),
+		"txHash":  txHash,
+	})
+}
+
+// GET /admin/networks
+func (ac *AdminController) ListNetworks(c *gin.Context) {
+	list := []gin.H{}
+	def := services.DefaultEth()
+	for _, eth := range services.AllNetworks() {
+		list = append(list, gin.H{
+			"name":          eth.Network,
+			"chainId":       eth.ChainID.Int64(),
+			"contract":      eth.Address.Hex(),
+			"confirmations": eth.Confirmations,
+			"explorer":      eth.TxURL("{tx}"),
+			"default":       eth == def,
+		})
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GET /admin/outbox
+func (ac *AdminController) ListOutbox(c *gin.Context) {
+	c.JSON(http.StatusOK, services.Outbox.List())
+}
+
+// GET /admin/integrity lists documents whose last scrub failed and the
+// report of the last full run.
+func (ac *AdminController) IntegrityReport(c *gin.Context) {
+	failed := []gin.H{}
+	for _, meta := range services.Store.GetAll() {
+		if meta.Integrity.Failed() {
+			failed = append(failed, gin.H{"id": meta.ID, "name": meta.Name, "hash": meta.Hash, "integrity": meta.Integrity})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"lastRun": services.Scrub.LastReport(), "failed": failed})
+}
+
+// POST /admin/integrity/run starts a full scrub in the background.
+func (ac *AdminController) RunScrub(c *gin.Context) {
+	if err := services.Scrub.RunInBackground(); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Integrity scrub started"})
+}
+
+// POST /admin/integrity/documents/:id checks one document right away.
+func (ac *AdminController) ScrubDocument(c *gin.Context) {
+	meta, err := services.Scrub.ScrubDocument(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, services.ErrDocumentMissing) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	} else if err != nil {
+		log.Printf("Integrity check of %s failed: %v\n", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save integrity check"})
+		return
+	}
+
+	versions := []gin.H{}
+	for _, v := range meta.Versions {
+		versions = append(versions, gin.H{"number": v.Number, "integrity": v.Integrity})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":                 meta.ID,
+		"verificationStatus": meta.VerificationStatus,
+		"integrity":          meta.Integrity,
+		"versions":           versions,
+	})
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// POST /api-keys issues a key acting for the caller. The key is only
+// returned in this response.
+func (dc *DocumentController) CreateAPIKeyHandler(c *gin.Context) {
+	var req struct {
+		Name      string     `json:"name" binding:"required"`
+		Scopes    []string   `json:"scopes" binding:"required"`
+		RateLimit int        `json:"rateLimit"` // requests per minute
+		ExpiresIn int64      `json:"expiresIn"` // seconds
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and scopes are required"})
+		return
+	}
+	if req.RateLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rateLimit cannot be negative"})
+		return
+	}
+
+	// A key can only hand out scopes it has itself
+	if scopes, ok := c.Get("scopes"); ok {
+		for _, scope := range req.Scopes {
+			if !slices.Contains(scopes.([]string), scope) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant the " + scope + " scope"})
+				return
+			}
+		}
+	}
+
+	key := services.APIKey{
+		UserID:    c.GetString("userID"),
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		RateLimit: req.RateLimit,
+	}
+	switch {
+	case req.ExpiresAt != nil:
+		key.ExpiresAt = req.ExpiresAt
+	case req.ExpiresIn > 0:
+		expires := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		key.ExpiresAt = &expires
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	key, secret, err := services.APIKeys.Create(key)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyScope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Println("Failed to save API key:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	log.Printf("API key %s (%s) created by %s with scopes %v\n", key.ID, key.Name, key.UserID, key.Scopes)
+	c.JSON(http.StatusCreated, gin.H{"key": secret, "apiKey": key})
+}
+
+// GET /api-keys
+func (dc *DocumentController) ListAPIKeysHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, services.APIKeys.ListForUser(c.GetString("userID")))
+}
+
+// DELETE /api-keys/:id
+func (dc *DocumentController) RevokeAPIKeyHandler(c *gin.Context) {
+	key, err := services.APIKeys.Revoke(c.GetString("userID"), c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("API key %s revoked by %s\n", key.ID, key.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked", "apiKey": key})
+}
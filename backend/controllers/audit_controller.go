@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditFilter reads the user, action, document, result, from and to query
+// parameters. Times are RFC 3339.
+func auditFilter(c *gin.Context) (services.AuditFilter, bool) {
+	f := services.AuditFilter{
+		UserID:     c.Query("user"),
+		Action:     c.Query("action"),
+		DocumentID: c.Query("document"),
+		Result:     c.Query("result"),
+	}
+	for param, t := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		if v := c.Query(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " time, use RFC 3339"})
+				return f, false
+			}
+			*t = parsed
+		}
+	}
+	return f, true
+}
+
+// writeAudit answers an audit query: the latest entries as JSON (limit,
+// default 100), or every match as an attachment with format=csv or
+// format=ndjson.
+func writeAudit(c *gin.Context, visible func(services.AuditEntry) bool) {
+	f, ok := auditFilter(c)
+	if !ok {
+		return
+	}
+	entries, err := services.Audit.Query(func(e services.AuditEntry) bool {
+		return f.Matches(e) && visible(e)
+	})
+	if err != nil {
+		log.Println("Failed to read audit log:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read audit log"})
+		return
+	}
+
+	switch c.Query("format") {
+	case "csv":
+		c.Header("Content-Disposition", `attachment; filename="audit.csv"`)
+		c.Header("Content-Type", "text/csv")
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"seq", "time", "userId", "apiKeyId", "via", "ip", "action", "documentId", "result", "status", "prevHash", "hash"})
+		for _, e := range entries {
+			w.Write([]string{
+				strconv.FormatInt(e.Seq, 10), e.Time.Format(time.RFC3339Nano), e.UserID, e.APIKeyID, e.Via, e.IP,
+				e.Action, e.DocumentID, e.Result, strconv.Itoa(e.Status), e.PrevHash, e.Hash,
+			})
+		}
+		w.Flush()
+	case "ndjson":
+		// The lines of the log file itself, so exports can be verified offline
+		c.Header("Content-Disposition", `attachment; filename="audit.ndjson"`)
+		c.Header("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(c.Writer)
+		for _, e := range entries {
+			enc.Encode(e)
+		}
+	default:
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		total := len(entries)
+		if total > limit {
+			entries = entries[total-limit:]
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": entries, "total": total})
+	}
+}
+
+// GET /audit?user=&action=&document=&result=&from=&to=&limit=&format=
+//
+// Lists the caller's own actions and all actions on documents they may
+// audit (see services.PermAudit).
+func (dc *DocumentController) AuditHandler(c *gin.Context) {
+	user := c.GetString("userID")
+	docs := map[string]bool{}
+	writeAudit(c, func(e services.AuditEntry) bool {
+		if e.UserID == user {
+			return true
+		}
+		if e.DocumentID == "" {
+			return false
+		}
+		allowed, seen := docs[e.DocumentID]
+		if !seen {
+			meta, found := dc.Store.Get(e.DocumentID)
+			allowed = found && services.Can(meta, user, services.PermAudit)
+			docs[e.DocumentID] = allowed
+		}
+		return allowed
+	})
+}
+
+// GET /admin/audit lists every entry, with the same filters as GET /audit.
+func (ac *AdminController) AuditLog(c *gin.Context) {
+	writeAudit(c, func(services.AuditEntry) bool { return true })
+}
+
+// GET /admin/audit/verify walks the hash chain and checks its anchors.
+func (ac *AdminController) VerifyAuditLog(c *gin.Context) {
+	v, err := services.Audit.Verify()
+	if err != nil {
+		log.Println("Failed to verify audit log:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+// POST /admin/audit/anchor anchors the chain head right away.
+func (ac *AdminController) AnchorAuditLog(c *gin.Context) {
+	anchor, err := services.Audit.Anchor(ac.PrivateKey)
+	switch {
+	case errors.Is(err, services.ErrNothingToAnchor):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		log.Println("Failed to anchor audit log:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to anchor audit log"})
+	default:
+		c.JSON(http.StatusOK, anchor)
+	}
+}
@@ -0,0 +1,375 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// directUploadTTL is how long presigned URLs and upload sessions stay valid.
+	directUploadTTL = time.Hour
+	// directPartSize is the part size offered to clients uploading in parts.
+	directPartSize = 16 << 20
+	maxUploadParts = 10000
+)
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// isPDF decides whether a stored object needs random access for text extraction.
+func isPDF(filename, contentType string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".pdf") || contentType == "application/pdf"
+}
+
+// POST /uploads/init
+func (dc *DocumentController) InitUploadHandler(c *gin.Context) {
+	var req struct {
+		Filename    string `json:"filename"`
+		ContentType string `json:"contentType"`
+		Size        int64  `json:"size"`
+		SHA256      string `json:"sha256"`
+		Tag         string `json:"tag"`
+		OnDuplicate string `json:"onDuplicate"`
+		OrgID       string `json:"orgId"`
+		Multipart   bool   `json:"multipart"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	req.SHA256 = strings.ToLower(req.SHA256)
+	switch {
+	case req.Filename == "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filename is required"})
+		return
+	case !sha256Pattern.MatchString(req.SHA256):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sha256 must be a hex encoded SHA-256 digest"})
+		return
+	case req.Size <= 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size is required"})
+		return
+	case dc.Upload.MaxUploadSize > 0 && req.Size > dc.Upload.MaxUploadSize:
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File exceeds the maximum upload size of %s", services.FormatBytes(dc.Upload.MaxUploadSize))})
+		return
+	}
+	if !uploadOrg(c, req.OrgID) || !withinStorageQuota(c, c.GetString("userID"), req.OrgID, req.Size) {
+		return
+	}
+	if req.Tag == "" {
+		req.Tag = "General"
+	}
+	if req.ContentType == "" {
+		req.ContentType = "application/octet-stream"
+	}
+
+	now := time.Now()
+	session := services.UploadSession{
+		ID:          services.NewRandomID(),
+		UserID:      c.GetString("userID"),
+		OrgID:       req.OrgID,
+		ObjectName:  services.NewObjectName(req.Filename),
+		Filename:    req.Filename,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+		SHA256:      req.SHA256,
+		Tag:         req.Tag,
+		OnDuplicate: req.OnDuplicate,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(directUploadTTL),
+	}
+
+	resp := gin.H{
+		"uploadId":  session.ID,
+		"object":    session.ObjectName,
+		"expiresAt": session.ExpiresAt,
+	}
+
+	if req.Multipart {
+		partCount := (req.Size + directPartSize - 1) / directPartSize
+		if partCount > maxUploadParts {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File has too many parts"})
+			return
+		}
+		multipartID, err := services.CreateMultipartUpload(session.ObjectName, session.ContentType)
+		if err != nil {
+			log.Printf("Failed to start multipart upload: %v\n", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start upload"})
+			return
+		}
+		session.MultipartID = multipartID
+		session.PartSize = directPartSize
+
+		parts, err := presignParts(session, nil)
+		if err != nil {
+			log.Printf("Failed to presign parts: %v\n", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start upload"})
+			return
+		}
+		resp["partSize"] = session.PartSize
+		resp["parts"] = parts
+	} else {
+		url, err := services.PresignPutURL(session.ObjectName, session.ContentType, directUploadTTL)
+		if err != nil {
+			log.Printf("Failed to presign upload: %v\n", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start upload"})
+			return
+		}
+		resp["url"] = url
+		resp["method"] = http.MethodPut
+		resp["headers"] = gin.H{"Content-Type": session.ContentType}
+	}
+
+	if err := services.UploadSessions.Put(session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save upload session"})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// presignParts returns upload URLs for every part not in done.
+func presignParts(session services.UploadSession, done map[int64]bool) ([]gin.H, error) {
+	partCount := (session.Size + session.PartSize - 1) / session.PartSize
+	parts := []gin.H{}
+	for n := int64(1); n <= partCount; n++ {
+		if done[n] {
+			continue
+		}
+		url, err := services.PresignUploadPartURL(session.ObjectName, session.MultipartID, n, directUploadTTL)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, gin.H{"partNumber": n, "url": url})
+	}
+	return parts, nil
+}
+
+// GET /uploads/:id
+func (dc *DocumentController) UploadStatusHandler(c *gin.Context) {
+	session, ok := services.UploadSessions.Get(c.Param("id"))
+	if !ok || session.Protocol != "" || session.UserID != c.GetString("userID") || session.Expired() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found or expired"})
+		return
+	}
+
+	resp := gin.H{
+		"uploadId":  session.ID,
+		"object":    session.ObjectName,
+		"size":      session.Size,
+		"expiresAt": session.ExpiresAt,
+	}
+	if session.MultipartID == "" {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	// Resume: report the parts storage already has and re-sign the rest
+	uploaded, err := services.ListUploadedParts(session.ObjectName, session.MultipartID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to list uploaded parts"})
+		return
+	}
+	done := make(map[int64]bool, len(uploaded))
+	for _, p := range uploaded {
+		done[p.PartNumber] = true
+	}
+	missing, err := presignParts(session, done)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to presign parts"})
+		return
+	}
+
+	resp["partSize"] = session.PartSize
+	resp["uploadedParts"] = uploaded
+	resp["parts"] = missing
+	c.JSON(http.StatusOK, resp)
+}
+
+// POST /uploads/:id/complete
+func (dc *DocumentController) CompleteUploadHandler(c *gin.Context) {
+	// A retried request must not create a second document, and the purger
+	// leaves sessions alone while they are locked
+	id := c.Param("id")
+	unlock, ok := services.UploadSessions.TryLock(id)
+	if !ok {
+		c.JSON(http.StatusLocked, gin.H{"error": "Upload is being completed by another request"})
+		return
+	}
+	defer unlock()
+
+	session, ok := services.UploadSessions.Get(id)
+	if !ok || session.Protocol != "" || session.UserID != c.GetString("userID") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	if session.Expired() {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload expired"})
+		return
+	}
+
+	// 1. Assemble multipart uploads
+	if session.MultipartID != "" {
+		var req struct {
+			Parts []services.UploadedPart `json:"parts"`
+		}
+		_ = c.ShouldBindJSON(&req)
+		parts := req.Parts
+		if len(parts) == 0 {
+			var err error
+			if parts, err = services.ListUploadedParts(session.ObjectName, session.MultipartID); err != nil {
+				c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to list uploaded parts"})
+				return
+			}
+		}
+		if err := services.CompleteMultipartUpload(session.ObjectName, session.MultipartID, parts); err != nil {
+			log.Printf("Failed to complete multipart upload %s: %v\n", id, err)
+			c.JSON(http.StatusConflict, gin.H{"error": "Upload incomplete", "details": err.Error()})
+			return
+		}
+	}
+
+	// 2. Fetch the object and hash it; PDFs are spooled for text extraction
+	body, size, err := services.GetObjectStream(session.ObjectName)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Uploaded object not found"})
+		return
+	}
+	defer body.Close()
+
+	reject := func(status int, msg string) {
+		if err := services.DeleteObject(session.ObjectName); err != nil {
+			log.Printf("Failed to delete rejected upload %s: %v\n", session.ObjectName, err)
+		}
+		services.UploadSessions.Remove(id)
+		c.JSON(status, gin.H{"error": msg})
+	}
+
+	if dc.Upload.MaxUploadSize > 0 && size > dc.Upload.MaxUploadSize {
+		reject(http.StatusRequestEntityTooLarge, "File exceeds the maximum upload size")
+		return
+	}
+	// The storage quota was checked against the declared size
+	if size != session.Size {
+		reject(http.StatusUnprocessableEntity, "Uploaded size does not match the declared size")
+		return
+	}
+
+	// With encryption at rest the plaintext object is replaced by an encrypted
+	// copy, so it is spooled whatever its type
+	var content io.ReaderAt = bytes.NewReader(nil)
+	var spooled *os.File
+	var fileHash string
+	if services.KMS != nil || isPDF(session.Filename, session.ContentType) {
+		f, spooledSize, hash, cleanup, err := services.SpoolToTemp(body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Cannot read uploaded object"})
+			return
+		}
+		defer cleanup()
+		spooled = f
+		content, size, fileHash = f, spooledSize, hash
+	} else {
+		if fileHash, size, err = services.Sha256Reader(body); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Cannot read uploaded object"})
+			return
+		}
+	}
+
+	// 3. Verify against the hash declared at init
+	if fileHash != session.SHA256 {
+		reject(http.StatusUnprocessableEntity, "Uploaded content does not match the declared sha256")
+		return
+	}
+
+	// 4. Duplicate detection; the freshly uploaded object is redundant then
+	policy := session.OnDuplicate
+	if policy == "" {
+		policy = dc.Upload.DuplicatePolicy
+	}
+	chainDup, handled := dc.checkDuplicate(c, session.OrgID, policy, fileHash, session.Filename, size, session.Tag)
+	if handled {
+		if err := services.DeleteObject(session.ObjectName); err != nil {
+			log.Printf("Failed to delete duplicate upload %s: %v\n", session.ObjectName, err)
+		}
+		services.UploadSessions.Remove(id)
+		return
+	}
+
+	// 5. Encrypt at rest by overwriting the object the client uploaded
+	var enc *services.EncryptionInfo
+	if services.KMS != nil {
+		if enc, err = storeStream(spooled, session.ObjectName, session.ContentType, fileHash); err != nil {
+			log.Printf("Failed to encrypt uploaded object: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt uploaded object"})
+			return
+		}
+	}
+
+	// 6. Anchor, analyze and save metadata
+	meta, err := dc.saveNewDocument(c.Request.Context(), newDocument{
+		UserID:     session.UserID,
+		OrgID:      session.OrgID,
+		Filename:   session.Filename,
+		ObjectName: session.ObjectName,
+		Hash:       fileHash,
+		Tag:        session.Tag,
+		Size:       size,
+		Content:    content,
+		Encryption: enc,
+		ChainDup:   chainDup,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save metadata"})
+		return
+	}
+	if err := services.UploadSessions.Remove(id); err != nil {
+		log.Println("Warning: failed to remove upload session:", err)
+	}
+
+	c.Set("documentID", meta.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Upload successful",
+		"id":      meta.ID,
+		"txHash":  meta.TxHash,
+		"meta":    meta,
+	})
+}
+
+// DELETE /uploads/:id
+func (dc *DocumentController) AbortUploadHandler(c *gin.Context) {
+	id := c.Param("id")
+	unlock, ok := services.UploadSessions.TryLock(id)
+	if !ok {
+		c.JSON(http.StatusLocked, gin.H{"error": "Upload is being completed by another request"})
+		return
+	}
+	defer unlock()
+
+	session, ok := services.UploadSessions.Get(id)
+	if !ok || session.Protocol != "" || session.UserID != c.GetString("userID") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+
+	if session.MultipartID != "" {
+		if err := services.AbortMultipartUpload(session.ObjectName, session.MultipartID); err != nil {
+			log.Printf("Failed to abort upload %s: %v\n", id, err)
+		}
+	} else if err := services.DeleteObject(session.ObjectName); err != nil {
+		log.Printf("Failed to delete upload %s: %v\n", id, err)
+	}
+	services.UploadSessions.Remove(id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Upload aborted"})
+}
@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"main/config"
+	"main/middleware"
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeStorage answers the S3 object calls uploads make, keeping objects in
+// memory. Presigned PUTs from tests land here too.
+type fakeStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/docs/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		s.objects[key] = body
+	case http.MethodGet:
+		body, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (s *fakeStorage) has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[key]
+	return ok
+}
+
+func (s *fakeStorage) count(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// newUploadTest routes the direct and tus upload handlers over a fresh
+// metadata store, session store and in-memory storage. Requests act as the
+// user named in X-User.
+func newUploadTest(t *testing.T) (*gin.Engine, *DocumentController, *fakeStorage) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	storage := &fakeStorage{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(storage)
+	t.Cleanup(srv.Close)
+	services.InitStorage(config.S3Config{Endpoint: srv.URL, Region: "us-east-1", AccessKey: "test", SecretKey: "test", Bucket: "docs"})
+
+	dir := t.TempDir()
+	if err := services.InitUploadSessions(filepath.Join(dir, "uploads.json")); err != nil {
+		t.Fatal(err)
+	}
+	store := &services.MetadataStore{FilePath: filepath.Join(dir, "metadata.json"), Data: make(map[string]services.DocumentMetadata)}
+	dc := NewDocumentController("", store, config.UploadConfig{DuplicatePolicy: config.DuplicateLink}, config.PreviewConfig{}, config.RetentionConfig{})
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("userID", c.GetHeader("X-User")) })
+	r.POST("/uploads/init", dc.InitUploadHandler)
+	r.GET("/uploads/:id", dc.UploadStatusHandler)
+	r.POST("/uploads/:id/complete", dc.CompleteUploadHandler)
+	r.DELETE("/uploads/:id", dc.AbortUploadHandler)
+	tus := r.Group("/files", middleware.TusMiddleware())
+	tus.POST("", dc.TusCreateHandler)
+	tus.HEAD("/:id", dc.TusHeadHandler)
+	tus.PATCH("/:id", dc.TusPatchHandler)
+	tus.GET("/:id", dc.TusResultHandler)
+	tus.DELETE("/:id", dc.TusDeleteHandler)
+	return r, dc, storage
+}
+
+func serve(r http.Handler, method, path, user string, body io.Reader, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("X-User", user)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var out map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("response %d is not JSON: %s", w.Code, w.Body)
+	}
+	return out
+}
+
+// directUpload starts a single-PUT upload of content declared as size bytes
+// with hash sum, and stores the object through the presigned URL.
+func directUpload(t *testing.T, r http.Handler, user string, content []byte, size int, sum, onDuplicate string) string {
+	t.Helper()
+	req, _ := json.Marshal(map[string]any{"filename": "notes.txt", "contentType": "text/plain", "size": size, "sha256": sum, "onDuplicate": onDuplicate})
+	w := serve(r, http.MethodPost, "/uploads/init", user, bytes.NewReader(req), map[string]string{"Content-Type": "application/json"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("init = %d %s", w.Code, w.Body)
+	}
+	resp := decode(t, w)
+
+	put, _ := http.NewRequest(http.MethodPut, resp["url"].(string), bytes.NewReader(content))
+	put.Header.Set("Content-Type", "text/plain")
+	res, err := http.DefaultClient.Do(put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return resp["uploadId"].(string)
+}
+
+func TestDirectUploadComplete(t *testing.T) {
+	r, dc, storage := newUploadTest(t)
+	content := []byte("quarterly figures")
+	id := directUpload(t, r, "alice", content, len(content), services.Sha256Hex(content), "")
+	session, _ := services.UploadSessions.Get(id)
+
+	// Sessions answer their owner only
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/uploads/" + id},
+		{http.MethodPost, "/uploads/" + id + "/complete"},
+		{http.MethodDelete, "/uploads/" + id},
+	} {
+		if w := serve(r, req.method, req.path, "bob", nil, nil); w.Code != http.StatusNotFound {
+			t.Errorf("bob %s %s = %d", req.method, req.path, w.Code)
+		}
+	}
+	if !storage.has(session.ObjectName) {
+		t.Fatal("another user's request removed the object")
+	}
+
+	// A concurrent complete is refused while one is running, and the purger
+	// keeps the session even once it expires
+	unlock, _ := services.UploadSessions.TryLock(id)
+	if w := serve(r, http.MethodPost, "/uploads/"+id+"/complete", "alice", nil, nil); w.Code != http.StatusLocked {
+		t.Errorf("complete while locked = %d", w.Code)
+	}
+	expired := session
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	services.UploadSessions.Put(expired)
+	services.UploadSessions.PurgeExpired()
+	if _, ok := services.UploadSessions.Get(id); !ok || !storage.has(session.ObjectName) {
+		t.Fatal("purger removed a locked upload")
+	}
+	services.UploadSessions.Put(session)
+	unlock()
+
+	w := serve(r, http.MethodPost, "/uploads/"+id+"/complete", "alice", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("complete = %d %s", w.Code, w.Body)
+	}
+	meta, ok := dc.Store.Get(decode(t, w)["id"].(string))
+	if !ok || meta.UserID != "alice" || meta.MinioID != session.ObjectName || meta.Hash != services.Sha256Hex(content) {
+		t.Errorf("stored %+v", meta)
+	}
+
+	// A retry finds the session gone instead of creating a second document
+	if w := serve(r, http.MethodPost, "/uploads/"+id+"/complete", "alice", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("retried complete = %d", w.Code)
+	}
+	if n := len(dc.Store.Data); n != 1 {
+		t.Errorf("%d documents after a retry", n)
+	}
+}
+
+func TestDirectUploadRejectsMismatches(t *testing.T) {
+	r, dc, storage := newUploadTest(t)
+	content := []byte("signed contract")
+
+	for _, c := range []struct {
+		name string
+		size int
+		sum  string
+	}{
+		{"hash", len(content), services.Sha256Hex([]byte("another contract"))},
+		// Declaring 1 byte would pass any storage quota
+		{"size", 1, services.Sha256Hex(content)},
+	} {
+		id := directUpload(t, r, "alice", content, c.size, c.sum, "")
+		session, _ := services.UploadSessions.Get(id)
+		if w := serve(r, http.MethodPost, "/uploads/"+id+"/complete", "alice", nil, nil); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s mismatch: complete = %d %s", c.name, w.Code, w.Body)
+		}
+		if storage.has(session.ObjectName) {
+			t.Errorf("%s mismatch: object kept", c.name)
+		}
+		if _, ok := services.UploadSessions.Get(id); ok {
+			t.Errorf("%s mismatch: session kept", c.name)
+		}
+	}
+	if len(dc.Store.Data) != 0 {
+		t.Errorf("rejected uploads stored: %+v", dc.Store.Data)
+	}
+}
+
+func TestDirectUploadDuplicatePolicies(t *testing.T) {
+	r, dc, storage := newUploadTest(t)
+	content := []byte("board minutes")
+	sum := services.Sha256Hex(content)
+
+	id := directUpload(t, r, "alice", content, len(content), sum, "")
+	first := decode(t, serve(r, http.MethodPost, "/uploads/"+id+"/complete", "alice", nil, nil))
+	originalID := first["id"].(string)
+	if _, err := dc.Store.Grant(originalID, services.ACLEntry{Subject: services.SubjectUser, SubjectID: "carol", Level: services.RoleViewer}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dc.Store.PlaceHold(originalID, "audit", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		policy string
+		status int
+		check  func(resp map[string]any)
+	}{
+		{config.DuplicateReject, http.StatusConflict, func(resp map[string]any) {
+			if resp["existingId"] != originalID {
+				t.Errorf("reject: %v", resp)
+			}
+		}},
+		{config.DuplicateLink, http.StatusOK, func(resp map[string]any) {
+			if resp["id"] != originalID || resp["duplicate"] != true {
+				t.Errorf("link: %v", resp)
+			}
+		}},
+		{config.DuplicateVersion, http.StatusOK, func(resp map[string]any) {
+			meta, _ := dc.Store.Get(resp["id"].(string))
+			original, _ := dc.Store.Get(originalID)
+			if meta.ID == originalID || meta.DuplicateOf != originalID || meta.UserID != "alice" || meta.MinioID != original.MinioID {
+				t.Errorf("version: %+v", meta)
+			}
+			if len(meta.ACL) != 0 || len(meta.LegalHolds) != 0 {
+				t.Errorf("version inherited grants or holds: %+v", meta)
+			}
+		}},
+	} {
+		id := directUpload(t, r, "alice", content, len(content), sum, c.policy)
+		session, _ := services.UploadSessions.Get(id)
+		w := serve(r, http.MethodPost, "/uploads/"+id+"/complete", "alice", nil, nil)
+		if w.Code != c.status {
+			t.Errorf("%s: complete = %d %s", c.policy, w.Code, w.Body)
+			continue
+		}
+		c.check(decode(t, w))
+		if storage.has(session.ObjectName) {
+			t.Errorf("%s: redundant object kept", c.policy)
+		}
+	}
+
+	// Another user's identical file is not a duplicate
+	id = directUpload(t, r, "bob", content, len(content), sum, config.DuplicateReject)
+	if w := serve(r, http.MethodPost, "/uploads/"+id+"/complete", "bob", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("bob's upload = %d %s", w.Code, w.Body)
+	}
+	if n := storage.count("docs/"); n != 2 {
+		t.Errorf("%d objects stored, want 2", n)
+	}
+}
@@ -1,172 +1,242 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
-	"time"
 
+	"main/config"
 	"main/services"
 
 	"github.com/gin-gonic/gin"
 )
 
+// DocumentController anchors documents on services.DefaultEth(), which may
+// connect or reconnect after the controller is created.
 type DocumentController struct {
-	Eth        *services.EthService
 	PrivateKey string
 	Store      *services.MetadataStore
+	Upload     config.UploadConfig
+	Preview    config.PreviewConfig
+	Retention  config.RetentionConfig
 }
 
-func NewDocumentController(eth *services.EthService, privateKey string, store *services.MetadataStore) *DocumentController {
+func NewDocumentController(privateKey string, store *services.MetadataStore, upload config.UploadConfig, preview config.PreviewConfig, retention config.RetentionConfig) *DocumentController {
 	return &DocumentController{
-		Eth:        eth,
 		PrivateKey: privateKey,
 		Store:      store,
+		Upload:     upload,
+		Preview:    preview,
+		Retention:  retention,
 	}
 }
 
+// userDocument looks a document up among those the authenticated caller may
+// read, as SupabaseStore scopes every query by user.
+func (dc *DocumentController) userDocument(c *gin.Context, id string) (services.DocumentMetadata, bool) {
+	return dc.Store.GetForUser(id, c.GetString("userID"))
+}
+
+// permitted writes 403 unless the caller has perm on a document they can read.
+func (dc *DocumentController) permitted(c *gin.Context, meta services.DocumentMetadata, perm services.Permission) bool {
+	if !services.Can(meta, c.GetString("userID"), perm) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Your role does not allow %s on this document", perm)})
+		return false
+	}
+	return true
+}
+
+// requirePermission writes 404 unless the caller can read the document, so
+// documents of others cannot be told apart from missing ones, and 403 unless
+// they also have perm.
+func (dc *DocumentController) requirePermission(c *gin.Context, id string, perm services.Permission) bool {
+	meta, found := dc.userDocument(c, id)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return false
+	}
+	return dc.permitted(c, meta, perm)
+}
+
 func (dc *DocumentController) UploadHandler(c *gin.Context) {
-	fileHeader, err := c.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+	fileHeader, file, ok := dc.openUpload(c)
+	if !ok {
 		return
 	}
+	defer file.Close()
 
 	tag := c.PostForm("tag")
 	if tag == "" {
 		tag = "General"
 	}
-
-	// Read file content
-	file, err := fileHeader.Open()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cannot open file"})
+	orgID := c.PostForm("orgId")
+	if !uploadOrg(c, orgID) || !withinStorageQuota(c, c.GetString("userID"), orgID, fileHeader.Size) {
 		return
 	}
-	defer file.Close()
 
-	content, err := io.ReadAll(file)
+	// 1. Calculate hash (streamed from the spooled upload)
+	fileHash, _, err := services.Sha256Reader(file)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cannot read file"})
 		return
 	}
 
-	// 1. Upload to storage
-	timestamp := time.Now().Unix()
-	objectName := fmt.Sprintf("docs/%d-%s", timestamp, fileHeader.Filename)
-	err = services.UploadObject(objectName, content, fileHeader.Header.Get("Content-Type"))
+	// 2. Duplicate detection
+	policy := c.DefaultPostForm("onDuplicate", dc.Upload.DuplicatePolicy)
+	chainDup, handled := dc.checkDuplicate(c, orgID, policy, fileHash, fileHeader.Filename, fileHeader.Size, tag)
+	if handled {
+		return
+	}
+
+	// 3. Upload to storage
+	objectName := services.NewObjectName(fileHeader.Filename)
+	enc, err := storeStream(file, objectName, fileHeader.Header.Get("Content-Type"), fileHash)
 	if err != nil {
 		log.Printf("Storage upload failed: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Storage upload failed: %v", err)})
 		return
 	}
 
-	// 2. Calculate hash
-	fileHash := services.Sha256Hex(content)
+	// 4-7. Anchor, analyze and save metadata
+	meta, err := dc.saveNewDocument(c.Request.Context(), newDocument{
+		UserID:     c.GetString("userID"),
+		OrgID:      orgID,
+		Filename:   fileHeader.Filename,
+		ObjectName: objectName,
+		Hash:       fileHash,
+		Tag:        tag,
+		Size:       fileHeader.Size,
+		Content:    file,
+		Encryption: enc,
+		ChainDup:   chainDup,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save metadata"})
+		return
+	}
+
+	c.Set("documentID", meta.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Upload successful",
+		"id":      meta.ID,
+		"txHash":  meta.TxHash,
+		"meta":    meta,
+	})
+}
 
-	// 3. Register on blockchain
-	txHash := ""
-	if dc.Eth != nil {
-		txHash, err = dc.Eth.RegisterDocument(dc.PrivateKey, fileHeader.Filename, fileHash, objectName, tag)
-		if err != nil {
-			log.Printf("Blockchain registration failed: %v\n", err)
+// handleDuplicate answers an upload by userID (into orgID when set) whose hash
+// matches an existing document, according to the reject / link / version policy.
+func (dc *DocumentController) handleDuplicate(userID, orgID, policy string, existing services.DocumentMetadata, filename string, size int64, tag string) (int, gin.H) {
+	switch policy {
+	case config.DuplicateReject:
+		return http.StatusConflict, gin.H{
+			"error":      "Document already exists",
+			"existingId": existing.ID,
 		}
-	}
 
-	// 4. AI Analysis
-	summary := "Pending analysis..."
-	category := tag
-	validity := "N/A"
-	aiStatus := "Queued"
-
-	text, err := services.ExtractTextFromPDFBytes(content)
-	if err == nil && text != "" {
-		analysis, err := services.AnalyzeDocument(text)
-		if err == nil && analysis != nil {
-			summary = analysis.Summary
-			category = analysis.Category
-			validity = analysis.Validity
-			aiStatus = "Processed"
-		} else {
-			generatedSummary, err := services.GenerateCompletion(text)
-			if err == nil {
-				summary = generatedSummary
-				aiStatus = "Processed"
-			} else {
-				aiStatus = "Failed"
+	case config.DuplicateVersion:
+		// New record pointing at the stored object, anchor and analysis of the
+		// original: no storage upload, no transaction and no LLM call. Grants,
+		// holds, versions and integrity results stay with the original.
+		meta := services.DocumentMetadata{
+			ID:                 services.NewRandomID(),
+			UserID:             userID,
+			OrgID:              orgID,
+			MinioID:            existing.MinioID,
+			Name:               filename,
+			Size:               services.FormatBytes(size),
+			SizeBytes:          size,
+			Date:               services.CurrentDate(),
+			Hash:               existing.Hash,
+			AIStatus:           existing.AIStatus,
+			VerificationStatus: existing.VerificationStatus,
+			Type:               existing.Type,
+			Category:           existing.Category,
+			Summary:            existing.Summary,
+			Validity:           existing.Validity,
+			TxHash:             existing.TxHash,
+			Network:            existing.Network,
+			ChainID:            existing.ChainID,
+			ChainDocID:         existing.ChainDocID,
+			DuplicateOf:        existing.ID,
+			Encryption:         existing.Encryption,
+		}
+		if tag != "" && tag != "General" {
+			meta.Category = tag
+		}
+		if err := dc.Store.AddOrUpdate(meta); err != nil {
+			return http.StatusInternalServerError, gin.H{"error": "Failed to save metadata"}
+		}
+		if text, err := services.GetTextCache(existing.ID); err == nil {
+			if cacheErr := services.SaveTextCache(meta.ID, text); cacheErr != nil {
+				log.Println("Warning: failed to cache text:", cacheErr)
 			}
 		}
-	} else {
-		aiStatus = "Failed"
-	}
+		return http.StatusOK, gin.H{
+			"message":     "Duplicate stored as new record",
+			"id":          meta.ID,
+			"duplicateOf": existing.ID,
+			"txHash":      meta.TxHash,
+			"meta":        meta,
+		}
 
-	// 5. Generate document ID
-	docID := fmt.Sprintf("%d", timestamp)
-	if dc.Eth != nil {
-		count, _ := dc.Eth.GetDocumentCount()
-		if count != nil {
-			docID = count.String()
+	default:
+		return http.StatusOK, gin.H{
+			"message":   "Document already exists",
+			"id":        existing.ID,
+			"duplicate": true,
+			"txHash":    existing.TxHash,
+			"meta":      existing,
 		}
 	}
+}
 
-	// 6. Save metadata
-	meta := services.DocumentMetadata{
-		ID:                 docID,
-		MinioID:            objectName,
-		Name:               fileHeader.Filename,
-		Size:               services.FormatBytes(fileHeader.Size),
-		Date:               services.CurrentDate(),
-		Hash:               fileHash,
-		AIStatus:           aiStatus,
-		VerificationStatus: "Verified",
-		Type:               "pdf",
-		Category:           category,
-		Summary:            summary,
-		Validity:           validity,
-		URL:                "",
-		Deleted:            false,
-	}
-
-	err = dc.Store.AddOrUpdate(meta)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save metadata"})
-		return
+// orgDocuments returns the documents the caller may read, narrowed by the
+// org query parameter: an organization id, "personal" for their own
+// documents or "shared" for those shared with them through ACLs.
+func (dc *DocumentController) orgDocuments(c *gin.Context) []services.DocumentMetadata {
+	user := c.GetString("userID")
+	docs := dc.Store.GetAllForUser(user)
+	org, ok := c.GetQuery("org")
+	if !ok {
+		return docs
 	}
-
-	// Cache text for future use
-	if aiStatus == "Processed" && text != "" {
-		if cacheErr := services.SaveTextCache(docID, text); cacheErr != nil {
-			log.Println("Warning: failed to cache text:", cacheErr)
+	return slices.DeleteFunc(docs, func(d services.DocumentMetadata) bool {
+		switch org {
+		case "personal":
+			return d.OrgID != "" || d.UserID != user
+		case "shared":
+			return !d.SharedWith(user)
 		}
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Upload successful",
-		"id":      docID,
-		"txHash":  txHash,
-		"meta":    meta,
+		return d.OrgID != org
 	})
 }
 
+// GET /documents?org=<id>|personal|shared
 func (dc *DocumentController) ListDocuments(c *gin.Context) {
 
-	docs := dc.Store.GetAll()
+	docs := dc.orgDocuments(c)
 
-	// Enrich with Presigned URLs
+	// Enrich with signed content URLs; grants are only shown to those who
+	// may audit the document
 	for i := range docs {
-		url, err := services.GetPresignedURL(docs[i].MinioID)
-		if err == nil {
-			docs[i].URL = url
+		if !services.Can(docs[i], c.GetString("userID"), services.PermAudit) {
+			docs[i].ACL = nil
 		}
+		docs[i].URL = dc.documentURL(c, docs[i])
+		docs[i].ExplorerURL = services.ExplorerTxURL(services.DocumentChainID(docs[i]), docs[i].TxHash)
+		docs[i].StorageTier = services.StorageTier(docs[i])
 	}
 
 	c.JSON(http.StatusOK, docs)
 }
 
+// GET /stats?org=<id>|personal|shared
 func (dc *DocumentController) GetStats(c *gin.Context) {
-	docs := dc.Store.GetAll()
+	docs := dc.orgDocuments(c)
 
 	total := len(docs)
 	verified := 0
@@ -189,44 +259,63 @@ func (dc *DocumentController) GetStats(c *gin.Context) {
 	c.JSON(http.StatusOK, stats)
 }
 
+// DELETE /documents/:id moves a document to the trash, from where it can be
+// restored until the retention period ends.
 func (dc *DocumentController) DeleteHandler(c *gin.Context) {
 	id := c.Param("id")
 
+	meta, found := dc.userDocument(c, id)
+	if !found || meta.Deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	if !dc.permitted(c, meta, services.PermDelete) {
+		return
+	}
+
 	if err := dc.Store.Delete(id); err != nil {
+		if errors.Is(err, services.ErrLegalHold) || errors.Is(err, services.ErrUnderRetention) {
+			lockedResponse(c, meta, err)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Document deleted"})
+	resp := gin.H{"message": "Document deleted"}
+	if meta, found = dc.Store.Get(id); found && dc.Retention.TrashRetention > 0 {
+		resp["purgeAt"] = meta.PurgeAt(dc.Retention.TrashRetention)
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 func (dc *DocumentController) GetPreviewURL(c *gin.Context) {
 	id := c.Param("id")
 
-	meta, found := dc.Store.Get(id)
-	if !found {
+	meta, found := dc.userDocument(c, id)
+	if !found || meta.Deleted {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
 		return
 	}
 
-	url, err := services.GetPresignedURL(meta.MinioID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate preview URL"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"url": url})
+	c.JSON(http.StatusOK, gin.H{
+		"url":       dc.documentURL(c, meta),
+		"expiresIn": int(dc.Preview.URLTTL.Seconds()),
+	})
 }
 
 // POST /documents/:id/regenerate-summary
 func (dc *DocumentController) RegenerateSummaryHandler(c *gin.Context) {
 	id := c.Param("id")
 
-	meta, found := dc.Store.Get(id)
-	if !found {
+	meta, found := dc.userDocument(c, id)
+	if !found || meta.Deleted {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
 		return
 	}
+	if !dc.permitted(c, meta, services.PermWrite) {
+		return
+	}
 
 	// Try to get cached text first
 	documentText, err := services.GetTextCache(id)
@@ -237,18 +326,34 @@ func (dc *DocumentController) RegenerateSummaryHandler(c *gin.Context) {
 	}
 
 	// Perform comprehensive AI analysis
-	analysis, err := services.AnalyzeDocument(documentText)
-	if err != nil {
+	analysis, err := services.AnalyzeDocument(c.Request.Context(), c.GetString("userID"), documentText)
+	if errors.Is(err, services.ErrTokenQuota) {
+		tokenQuotaExceeded(c)
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "AI processing failed"})
 		return
 	}
 
-	// Update metadata with new analysis
-	meta.Summary = analysis.Summary
-	meta.Category = analysis.Category
-	meta.Validity = analysis.Validity
-	meta.AIStatus = "Processed"
-	dc.Store.AddOrUpdate(meta)
+	// Update metadata with new analysis, unless the document was deleted
+	// while the model ran
+	_, err = dc.Store.Update(id, func(m *services.DocumentMetadata) error {
+		if m.Deleted {
+			return services.ErrDocumentMissing
+		}
+		m.Summary = analysis.Summary
+		m.Category = analysis.Category
+		m.Validity = analysis.Validity
+		m.AIStatus = "Processed"
+		return nil
+	})
+	if errors.Is(err, services.ErrDocumentMissing) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save summary"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"summary":  analysis.Summary,
@@ -268,8 +373,8 @@ func (dc *DocumentController) ChatHandler(c *gin.Context) {
 		return
 	}
 
-	meta, found := dc.Store.Get(id)
-	if !found {
+	meta, found := dc.userDocument(c, id)
+	if !found || meta.Deleted {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
 		return
 	}
@@ -283,11 +388,97 @@ func (dc *DocumentController) ChatHandler(c *gin.Context) {
 	}
 
 	// Use AI to answer question
-	answer, err := services.ChatWithDocument(documentText, req.Question)
-	if err != nil {
+	answer, err := services.ChatWithDocument(c.Request.Context(), c.GetString("userID"), documentText, req.Question)
+	if errors.Is(err, services.ErrTokenQuota) {
+		tokenQuotaExceeded(c)
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "AI processing failed"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"answer": answer})
 }
+
+// GET /documents/:id/proof
+func (dc *DocumentController) ProofHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	// Purged documents keep answering with their on-chain record. Without a
+	// user the route is public (AUTH_ANONYMOUS_VERIFY) and proves any document.
+	meta, found := dc.Store.Get(id)
+	if c.GetString("userID") != "" {
+		meta, found = dc.userDocument(c, id)
+	}
+	if !found || meta.InTrash() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	chainID := services.DocumentChainID(meta)
+	proof := gin.H{
+		"id":          meta.ID,
+		"hash":        meta.Hash,
+		"txHash":      meta.TxHash,
+		"network":     meta.Network,
+		"chainId":     chainID,
+		"explorerUrl": services.ExplorerTxURL(chainID, meta.TxHash),
+	}
+	if meta.PurgedAt != nil {
+		proof["purgedAt"] = meta.PurgedAt
+	}
+	if meta.TxHash == "" {
+		proof["status"] = "NotAnchored"
+		c.JSON(http.StatusOK, proof)
+		return
+	}
+
+	eth := services.NetworkByChainID(chainID)
+	if eth == nil {
+		proof["status"] = "NetworkUnavailable"
+		c.JSON(http.StatusOK, proof)
+		return
+	}
+	proof["network"] = eth.Network
+
+	// A mined transaction only proves the document if it registered this hash
+	var anchored string
+	confirmations, mined, err := eth.TxConfirmations(meta.TxHash)
+	if err == nil && mined {
+		anchored, err = eth.RegisteredHash(meta.TxHash)
+		proof["anchoredHash"] = anchored
+	}
+	switch {
+	case err != nil:
+		log.Printf("Proof lookup for %s failed: %v\n", id, err)
+		proof["status"] = "Failed"
+	case !mined:
+		proof["status"] = "Pending"
+	case anchored != meta.Hash:
+		proof["status"] = "HashMismatch"
+	case confirmations < eth.Confirmations:
+		proof["status"] = "Confirming"
+	default:
+		proof["status"] = "Confirmed"
+	}
+	proof["confirmations"] = confirmations
+	proof["requiredConfirmations"] = eth.Confirmations
+
+	// Record the contract's id of the document once the registration is mined
+	if mined && meta.ChainDocID == "" {
+		if chainDocID, err := eth.RegisteredID(meta.TxHash); err != nil {
+			log.Printf("On-chain id lookup for %s failed: %v\n", id, err)
+		} else {
+			meta.ChainDocID = chainDocID
+			if _, err := dc.Store.Update(id, func(m *services.DocumentMetadata) error {
+				services.SetChainDocID(m, meta.TxHash, chainDocID)
+				return nil
+			}); err != nil {
+				log.Println("Warning: could not save on-chain id:", err)
+			}
+		}
+	}
+	proof["chainDocId"] = meta.ChainDocID
+
+	c.JSON(http.StatusOK, proof)
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// holdActor is recorded with every hold and retention change. Only the
+// compliance role (AUTH_COMPLIANCE_ROLE) or the admin token may make one:
+// document owners, personal or in an organization, cannot lift the hold
+// that keeps them from deleting.
+func holdActor(c *gin.Context) (string, bool) {
+	if c.GetBool("admin") {
+		return "admin", true
+	}
+	user := requestUser(c)
+	switch {
+	case user == "":
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required to change holds or retention"})
+		return "", false
+	case !c.GetBool("compliance"):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Changing legal holds or retention requires the compliance role"})
+		return "", false
+	}
+	return user, true
+}
+
+func holdErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrDocumentMissing), errors.Is(err, services.ErrHoldNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrRetentionShorten), errors.Is(err, services.ErrPurging):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// lockedResponse answers a blocked delete or purge.
+func lockedResponse(c *gin.Context, meta services.DocumentMetadata, err error) {
+	c.JSON(http.StatusLocked, gin.H{
+		"error":       err.Error(),
+		"legalHolds":  meta.ActiveHolds(),
+		"retainUntil": meta.RetainUntil,
+	})
+}
+
+// GET /documents/:id/holds lists all holds, released ones included.
+func (dc *DocumentController) ListHoldsHandler(c *gin.Context) {
+	meta, found := dc.userDocument(c, c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	if !dc.permitted(c, meta, services.PermAudit) {
+		return
+	}
+	holds := meta.LegalHolds
+	if holds == nil {
+		holds = []services.LegalHold{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"legalHolds":  holds,
+		"retainUntil": meta.RetainUntil,
+		"deletable":   meta.CheckDeletable(time.Now()) == nil,
+	})
+}
+
+// POST /documents/:id/holds, also under /admin
+func (dc *DocumentController) PlaceHoldHandler(c *gin.Context) {
+	user, ok := holdActor(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+		return
+	}
+
+	id := c.Param("id")
+	hold, err := dc.Store.PlaceHold(id, req.Reason, user)
+	if err != nil && !errors.Is(err, services.ErrObjectLock) {
+		c.JSON(holdErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Legal hold %s placed on document %s by %s: %s\n", hold.ID, id, user, req.Reason)
+	c.JSON(http.StatusCreated, withObjectLockError(gin.H{"hold": hold}, err))
+}
+
+// DELETE /documents/:id/holds/:holdId, also under /admin
+func (dc *DocumentController) ReleaseHoldHandler(c *gin.Context) {
+	user, ok := holdActor(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	hold, err := dc.Store.ReleaseHold(id, c.Param("holdId"), user)
+	if err != nil && !errors.Is(err, services.ErrObjectLock) {
+		c.JSON(holdErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Legal hold %s on document %s released by %s\n", hold.ID, id, user)
+	c.JSON(http.StatusOK, withObjectLockError(gin.H{"hold": hold}, err))
+}
+
+// PUT /documents/:id/retention keeps a document until retainUntil (or for
+// days from now). Retention can be extended but never shortened. Also under
+// /admin.
+func (dc *DocumentController) SetRetentionHandler(c *gin.Context) {
+	user, ok := holdActor(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		RetainUntil *time.Time `json:"retainUntil"`
+		Days        int        `json:"days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	var until time.Time
+	switch {
+	case req.RetainUntil != nil:
+		until = *req.RetainUntil
+	case req.Days > 0:
+		until = time.Now().AddDate(0, 0, req.Days)
+	}
+	if !until.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "retainUntil must be in the future"})
+		return
+	}
+
+	id := c.Param("id")
+	meta, err := dc.Store.SetRetention(id, until)
+	if err != nil && !errors.Is(err, services.ErrObjectLock) {
+		c.JSON(holdErrorStatus(err), gin.H{"error": err.Error(), "retainUntil": meta.RetainUntil})
+		return
+	}
+	log.Printf("Retention of document %s set to %s by %s\n", id, until.Format(time.RFC3339), user)
+	c.JSON(http.StatusOK, withObjectLockError(gin.H{"retainUntil": meta.RetainUntil}, err))
+}
+
+// withObjectLockError reports a change that was saved but not mirrored onto
+// S3 Object Lock; it is applied again with the next change to the document.
+func withObjectLockError(resp gin.H, err error) gin.H {
+	if err != nil {
+		resp["objectLockError"] = err.Error()
+	}
+	return resp
+}
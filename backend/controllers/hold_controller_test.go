@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"main/config"
+	"main/middleware"
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newHoldTest routes the hold and delete handlers like RegisterRoutes and
+// RegisterAdminRoutes do. Requests act as the user in X-User, with the
+// compliance role when X-Compliance is set.
+func newHoldTest(t *testing.T) (*gin.Engine, *DocumentController) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	store := &services.MetadataStore{FilePath: filepath.Join(dir, "metadata.json"), Data: make(map[string]services.DocumentMetadata)}
+	dc := NewDocumentController("", store, config.UploadConfig{}, config.PreviewConfig{}, config.RetentionConfig{})
+
+	orgs := services.Orgs
+	t.Cleanup(func() { services.Orgs = orgs })
+	if err := services.InitOrgs(filepath.Join(dir, "orgs.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	user := r.Group("", func(c *gin.Context) {
+		c.Set("userID", c.GetHeader("X-User"))
+		if c.GetHeader("X-Compliance") != "" {
+			c.Set("compliance", true)
+		}
+	})
+	user.POST("/documents/:id/holds", dc.PlaceHoldHandler)
+	user.DELETE("/documents/:id/holds/:holdId", dc.ReleaseHoldHandler)
+	user.PUT("/documents/:id/retention", dc.SetRetentionHandler)
+	user.DELETE("/documents/:id", dc.DeleteHandler)
+	admin := r.Group("/admin", middleware.AdminMiddleware("secret"))
+	admin.DELETE("/documents/:id/holds/:holdId", dc.ReleaseHoldHandler)
+	return r, dc
+}
+
+func TestOwnerCannotReleaseHold(t *testing.T) {
+	r, dc := newHoldTest(t)
+	org, _ := services.Orgs.Create("Legal", "alice")
+	dc.Store.AddOrUpdate(services.DocumentMetadata{ID: "personal", UserID: "alice"})
+	dc.Store.AddOrUpdate(services.DocumentMetadata{ID: "shared", UserID: "alice", OrgID: org.ID})
+
+	for _, id := range []string{"personal", "shared"} {
+		hold, err := dc.Store.PlaceHold(id, "litigation", "compliance-officer")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The owner can change neither holds nor retention, so the
+		// document stays undeletable
+		if w := serve(r, http.MethodDelete, "/documents/"+id+"/holds/"+hold.ID, "alice", nil, nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s: owner release = %d %s", id, w.Code, w.Body)
+		}
+		if w := serve(r, http.MethodPost, "/documents/"+id+"/holds", "alice", strings.NewReader(`{"reason": "mine"}`), nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s: owner place = %d", id, w.Code)
+		}
+		if w := serve(r, http.MethodPut, "/documents/"+id+"/retention", "alice", strings.NewReader(`{"days": 30}`), nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s: owner retention = %d", id, w.Code)
+		}
+		if w := serve(r, http.MethodDelete, "/documents/"+id, "alice", nil, nil); w.Code != http.StatusLocked {
+			t.Fatalf("%s: delete under hold = %d %s", id, w.Code, w.Body)
+		}
+
+		// Compliance releases it, after which the owner may delete
+		w := serve(r, http.MethodDelete, "/documents/"+id+"/holds/"+hold.ID, "carol", nil, map[string]string{"X-Compliance": "1"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: compliance release = %d %s", id, w.Code, w.Body)
+		}
+		meta, _ := dc.Store.Get(id)
+		if released := meta.LegalHolds[0]; released.ReleasedBy != "carol" {
+			t.Errorf("%s: released by %q", id, released.ReleasedBy)
+		}
+		if w := serve(r, http.MethodDelete, "/documents/"+id, "alice", nil, nil); w.Code != http.StatusOK {
+			t.Errorf("%s: delete after release = %d %s", id, w.Code, w.Body)
+		}
+	}
+}
+
+func TestAdminTokenReleasesHold(t *testing.T) {
+	r, dc := newHoldTest(t)
+	dc.Store.AddOrUpdate(services.DocumentMetadata{ID: "1", UserID: "alice"})
+	hold, _ := dc.Store.PlaceHold("1", "audit", "compliance-officer")
+
+	if w := serve(r, http.MethodDelete, "/admin/documents/1/holds/"+hold.ID, "", nil, map[string]string{"X-Admin-Token": "wrong"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token = %d", w.Code)
+	}
+	w := serve(r, http.MethodDelete, "/admin/documents/1/holds/"+hold.ID, "", nil, map[string]string{"X-Admin-Token": "secret"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin release = %d %s", w.Code, w.Body)
+	}
+	if meta, _ := dc.Store.Get("1"); len(meta.ActiveHolds()) != 0 || meta.LegalHolds[0].ReleasedBy != "admin" {
+		t.Errorf("holds %+v", meta.LegalHolds)
+	}
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func orgErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrOrgNotFound), errors.Is(err, services.ErrMemberNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrUnknownRole):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrLastOwner):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// memberOrg loads an organization the caller belongs to and returns their
+// role. Non-members get 404. On failure the response has been written.
+func memberOrg(c *gin.Context) (services.Organization, string, bool) {
+	org, ok := services.Orgs.Get(c.Param("orgId"))
+	role := services.Orgs.Role(org.ID, c.GetString("userID"))
+	if !ok || role == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrOrgNotFound.Error()})
+		return org, "", false
+	}
+	return org, role, true
+}
+
+// POST /orgs creates an organization owned by the caller.
+func (dc *DocumentController) CreateOrgHandler(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	org, err := services.Orgs.Create(req.Name, c.GetString("userID"))
+	if err != nil {
+		log.Println("Failed to save organization:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
+		return
+	}
+	c.JSON(http.StatusCreated, org)
+}
+
+// GET /orgs lists the caller's organizations with their role in each.
+func (dc *DocumentController) ListOrgsHandler(c *gin.Context) {
+	user := c.GetString("userID")
+	orgs := services.Orgs.ForUser(user)
+	list := make([]gin.H, 0, len(orgs))
+	for _, org := range orgs {
+		list = append(list, gin.H{
+			"id":        org.ID,
+			"name":      org.Name,
+			"role":      services.Orgs.Role(org.ID, user),
+			"members":   len(org.Members),
+			"createdAt": org.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GET /orgs/:orgId
+func (dc *DocumentController) GetOrgHandler(c *gin.Context) {
+	org, role, ok := memberOrg(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"organization": org, "role": role})
+}
+
+// PUT /orgs/:orgId/members/:userId adds a member or changes their role.
+func (dc *DocumentController) SetMemberHandler(c *gin.Context) {
+	org, role, ok := memberOrg(c)
+	if !ok {
+		return
+	}
+	if !services.RoleAllows(role, services.PermManage) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can manage members"})
+		return
+	}
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role is required"})
+		return
+	}
+
+	user := c.GetString("userID")
+	org, err := services.Orgs.SetMember(org.ID, c.Param("userId"), req.Role, user)
+	if err != nil {
+		c.JSON(orgErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Organization %s: %s set to %s by %s\n", org.ID, c.Param("userId"), req.Role, user)
+	c.JSON(http.StatusOK, org)
+}
+
+// DELETE /orgs/:orgId/members/:userId removes a member. Members may remove
+// themselves; removing others needs the owner role.
+func (dc *DocumentController) RemoveMemberHandler(c *gin.Context) {
+	org, role, ok := memberOrg(c)
+	if !ok {
+		return
+	}
+	user := c.GetString("userID")
+	if c.Param("userId") != user && !services.RoleAllows(role, services.PermManage) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can manage members"})
+		return
+	}
+
+	org, err := services.Orgs.RemoveMember(org.ID, c.Param("userId"))
+	if err != nil {
+		c.JSON(orgErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Organization %s: %s removed by %s\n", org.ID, c.Param("userId"), user)
+	c.JSON(http.StatusOK, org)
+}
@@ -1,36 +1,208 @@
 package controllers
 
 import (
+	"io"
 	"log"
+	"main/middleware"
 	"main/services"
+	"mime"
 	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func PreviewFile(c *gin.Context) {
+// archiveRetryAfter is suggested to clients waiting for a GLACIER restore,
+// which usually takes a few hours.
+const archiveRetryAfter = time.Hour
+
+// inlineTypes may be displayed by the browser; any other file is always
+// served as an attachment. The routes add a sandbox CSP (see
+// middleware.UserContentHeaders).
+var inlineTypes = map[string]bool{
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/bmp":       true,
+	"text/plain":      true,
+}
+
+// GET /preview/*filename
+//
+// Kept for old links: the object key must belong to a known document and the
+// same access rules as GET /documents/:id/content apply. Nothing is
+// redirected to storage any more.
+func (dc *DocumentController) PreviewFile(c *gin.Context) {
 	filename := c.Param("filename")
-	log.Println("Preview requested for raw param:", filename)
 
 	// Wildcard params often start with /, remove it
 	if len(filename) > 0 && filename[0] == '/' {
 		filename = filename[1:]
 	}
-	log.Println("Preview looking for Storage object:", filename)
 
 	if filename == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "filename required"})
 		return
 	}
 
-	// Generate presigned URL
-	url, err := services.GetPresignedURL(filename)
+	meta, version, found := dc.Store.FindByObject(filename)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "archivo no encontrado"})
+		return
+	}
+	if !dc.authorizeContent(c, meta, version.Number) {
+		return
+	}
+	dc.serveVersion(c, version)
+}
+
+// GET /documents/:id/content?version=N
+//
+// Streams a document (decrypted if needed) with Range, ETag and conditional
+// request support. Callers need a signed URL from GET /documents/:id/preview
+// or the owner's bearer token. download=1 asks for an attachment.
+func (dc *DocumentController) ContentHandler(c *gin.Context) {
+	meta, found := dc.Store.Get(c.Param("id"))
+	if !found || meta.Deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	number := 0
+	version := currentVersion(meta)
+	if v := c.Query("version"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+			return
+		}
+		if version, found = services.FindVersion(meta, n); !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+		number = n
+	}
+
+	if !dc.authorizeContent(c, meta, number) {
+		return
+	}
+	dc.serveVersion(c, version)
+}
+
+// currentVersion describes the latest content of a document, with or
+// without version history.
+func currentVersion(meta services.DocumentMetadata) services.DocumentVersion {
+	if len(meta.Versions) > 0 {
+		return meta.Versions[len(meta.Versions)-1]
+	}
+	return services.DocumentVersion{Name: meta.Name, Hash: meta.Hash, MinioID: meta.MinioID, Encryption: meta.Encryption}
+}
+
+// authorizeContent accepts a URL signed for this document version, or the
+// bearer token or API key (with the read scope) of a user who may read the
+// document. On failure the response has already been written.
+func (dc *DocumentController) authorizeContent(c *gin.Context, meta services.DocumentMetadata, version int) bool {
+	c.Set("documentID", meta.ID)
+	if sig := c.Query("sig"); sig != "" {
+		if services.VerifyContentSignature(meta.ID, version, c.Query("expires"), sig) {
+			return true
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired link"})
+		return false
+	}
+	id, err := middleware.IdentityFromRequest(c)
 	if err != nil {
-		log.Println("Error generating preview URL:", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return false
+	}
+	// Recorded by the audit log like a caller of an authenticated route
+	c.Set("userID", id.UserID)
+	c.Set("apiKeyID", id.APIKeyID)
+	if !id.HasScope(services.ScopeRead) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks the \"read\" scope"})
+		return false
+	}
+	if !services.Can(meta, id.UserID, services.PermRead) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return false
+	}
+	return true
+}
+
+// baseURL is the backend's own origin. Links handed to clients are absolute
+// because the frontend is served from another origin.
+func baseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// documentURL is the signed content URL handed to clients for previews.
+func (dc *DocumentController) documentURL(c *gin.Context, meta services.DocumentMetadata) string {
+	return baseURL(c) + services.SignedContentURL(meta.ID, 0, dc.Preview.URLTTL)
+}
+
+// serveVersion streams a stored object through http.ServeContent, which
+// answers Range, If-Range and If-None-Match from the ETag (the SHA-256 that
+// is anchored on chain).
+//
+// Archived objects are served from the archive and moved back to the standard
+// tier afterwards; objects in GLACIER-class storage answer 202 until restored.
+func (dc *DocumentController) serveVersion(c *gin.Context, v services.DocumentVersion) {
+	ready, err := services.PrepareObject(v.MinioID)
+	if err != nil {
+		log.Printf("Error restoring %s: %v\n", v.MinioID, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Archived document could not be restored"})
+		return
+	}
+	if !ready {
+		c.Header("Retry-After", strconv.Itoa(int(archiveRetryAfter.Seconds())))
+		c.JSON(http.StatusAccepted, gin.H{
+			"status":  services.TierRestoring,
+			"message": "Document is being restored from the archive, try again later",
+		})
+		return
+	}
+
+	reader, err := services.OpenObjectReader(v.MinioID, v.Encryption)
+	if err != nil {
+		log.Printf("Error opening %s: %v\n", v.MinioID, err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "archivo no encontrado"})
 		return
 	}
+	defer reader.Close()
 
-	// Redirect to the signed URL
-	c.Redirect(http.StatusTemporaryRedirect, url)
+	contentType := mime.TypeByExtension(filepath.Ext(v.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := "inline"
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if c.Query("download") == "1" || !inlineTypes[mediaType] {
+		disposition = "attachment"
+	}
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": v.Name}))
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Cache-Control", "private")
+	if v.Hash != "" {
+		h.Set("ETag", `"`+v.Hash+`"`)
+	}
+
+	var content io.ReadSeeker = reader
+	if v.Hash != "" && (dc.Preview.VerifyHash || c.Query("verify") == "1") {
+		content = services.NewVerifyingReader(reader, v.Hash)
+	}
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, content)
+	if c.Request.Method == http.MethodGet {
+		services.ObjectAccessed(v.MinioID)
+	}
 }
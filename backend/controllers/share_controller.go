@@ -0,0 +1,274 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"main/middleware"
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestUser returns the caller's user id, or "" for anonymous requests.
+func requestUser(c *gin.Context) string {
+	if id := c.GetString("userID"); id != "" {
+		return id
+	}
+	id, _ := middleware.IdentityFromRequest(c)
+	return id.UserID
+}
+
+func (dc *DocumentController) shareURL(c *gin.Context, share services.Share) string {
+	return baseURL(c) + "/s/" + share.Token()
+}
+
+func shareErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrShareNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrSharePassword):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrShareRevoked), errors.Is(err, services.ErrShareExpired), errors.Is(err, services.ErrShareExhausted):
+		return http.StatusGone
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// POST /documents/:id/shares
+func (dc *DocumentController) CreateShareHandler(c *gin.Context) {
+	id := c.Param("id")
+	meta, found := dc.userDocument(c, id)
+	if !found || meta.Deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	if !dc.permitted(c, meta, services.PermWrite) {
+		return
+	}
+
+	var req struct {
+		ExpiresIn    int64      `json:"expiresIn"` // seconds
+		ExpiresAt    *time.Time `json:"expiresAt"`
+		MaxDownloads int        `json:"maxDownloads"`
+		Password     string     `json:"password"`
+		Version      int        `json:"version"`
+		Label        string     `json:"label"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ttl := dc.Preview.ShareDefaultTTL
+	switch {
+	case req.ExpiresAt != nil:
+		ttl = time.Until(*req.ExpiresAt)
+	case req.ExpiresIn != 0:
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+	if ttl > dc.Preview.ShareMaxTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Share links can last at most %s", dc.Preview.ShareMaxTTL)})
+		return
+	}
+	if req.MaxDownloads < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "maxDownloads cannot be negative"})
+		return
+	}
+	if req.Version != 0 {
+		if _, ok := services.FindVersion(meta, req.Version); !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+	}
+
+	share, err := services.Shares.Create(services.Share{
+		DocID:        id,
+		Version:      req.Version,
+		Label:        req.Label,
+		CreatedBy:    requestUser(c),
+		ExpiresAt:    time.Now().Add(ttl),
+		MaxDownloads: req.MaxDownloads,
+	}, req.Password)
+	if err != nil {
+		log.Println("Failed to save share:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+		return
+	}
+
+	share.URL = dc.shareURL(c, share)
+	c.JSON(http.StatusCreated, share)
+}
+
+// GET /documents/:id/shares?all=1
+func (dc *DocumentController) ListSharesHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !dc.requirePermission(c, id, services.PermAudit) {
+		return
+	}
+
+	shares := services.Shares.ListForDocument(id, c.Query("all") == "1")
+	for i := range shares {
+		shares[i].URL = dc.shareURL(c, shares[i])
+	}
+	c.JSON(http.StatusOK, shares)
+}
+
+// DELETE /documents/:id/shares/:shareId
+func (dc *DocumentController) RevokeShareHandler(c *gin.Context) {
+	if !dc.requirePermission(c, c.Param("id"), services.PermWrite) {
+		return
+	}
+	share, err := services.Shares.Revoke(c.Param("id"), c.Param("shareId"))
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Share revoked", "share": share})
+}
+
+// shareFromToken resolves a public share token to a usable share and the
+// document version it points at. On failure the response has been written.
+func (dc *DocumentController) shareFromToken(c *gin.Context) (services.Share, services.DocumentVersion, bool) {
+	shareID, ok := services.ShareIDFromToken(c.Param("token"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrShareNotFound.Error()})
+		return services.Share{}, services.DocumentVersion{}, false
+	}
+	share, ok := services.Shares.Get(shareID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrShareNotFound.Error()})
+		return share, services.DocumentVersion{}, false
+	}
+	if err := share.Status(time.Now()); err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return share, services.DocumentVersion{}, false
+	}
+
+	c.Set("documentID", share.DocID)
+	meta, found := dc.Store.Get(share.DocID)
+	if !found || meta.Deleted {
+		c.JSON(http.StatusGone, gin.H{"error": "Document no longer available"})
+		return share, services.DocumentVersion{}, false
+	}
+	version := currentVersion(meta)
+	if share.Version != 0 {
+		if version, found = services.FindVersion(meta, share.Version); !found {
+			c.JSON(http.StatusGone, gin.H{"error": "Document version no longer available"})
+			return share, services.DocumentVersion{}, false
+		}
+	}
+	return share, version, true
+}
+
+// GET /s/:token/info describes a share for a landing page.
+func (dc *DocumentController) ShareInfoHandler(c *gin.Context) {
+	share, version, ok := dc.shareFromToken(c)
+	if !ok {
+		return
+	}
+	resp := gin.H{
+		"name":              version.Name,
+		"hash":              version.Hash,
+		"expiresAt":         share.ExpiresAt,
+		"passwordProtected": share.HasPassword(),
+	}
+	if share.MaxDownloads > 0 {
+		resp["downloadsLeft"] = share.MaxDownloads - share.Downloads
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// shareSessionCookie holds the session a password exchange starts, scoped
+// to the path of one share link.
+const shareSessionCookie = "share_session"
+
+// GET /s/:token streams the shared document. Password protected shares need
+// the session cookie set by POST /s/:token/access.
+func (dc *DocumentController) ShareDownloadHandler(c *gin.Context) {
+	share, version, ok := dc.shareFromToken(c)
+	if !ok {
+		return
+	}
+	if share.HasPassword() {
+		session, _ := c.Cookie(shareSessionCookie)
+		if !services.VerifyShareSession(share.ID, session) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Password required", "passwordRequired": true})
+			return
+		}
+	}
+
+	// Every GET counts, partial ones included: a client choosing its own
+	// ranges could otherwise fetch the whole file without being counted
+	if _, err := services.Shares.Use(share.ID, c.Request.Method == http.MethodGet); err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	dc.serveVersion(c, version)
+}
+
+// allowPasswordAttempt applies the password attempt limits of a share and of
+// the client IP, answering 429 once either is reached.
+func allowPasswordAttempt(c *gin.Context, share services.Share) bool {
+	if services.Limits == nil {
+		return true
+	}
+	now := time.Now()
+	for _, st := range []services.RateStatus{
+		services.Limits.Allow(services.LimitSharePassword, share.ID, now),
+		services.Limits.Allow(services.LimitSharePasswordIP, c.ClientIP(), now),
+	} {
+		if !st.Allowed {
+			reset := strconv.Itoa(int(math.Ceil(st.Reset.Seconds())))
+			c.Header("Retry-After", reset)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many password attempts, try again in " + reset + "s"})
+			return false
+		}
+	}
+	return true
+}
+
+// POST /s/:token/access checks the password of a protected share and sets a
+// short-lived session cookie for GET /s/:token. Downloads are counted there.
+func (dc *DocumentController) ShareAccessHandler(c *gin.Context) {
+	share, _, ok := dc.shareFromToken(c)
+	if !ok {
+		return
+	}
+	if !allowPasswordAttempt(c, share) {
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if _, err := services.Shares.Authorize(share.ID, req.Password, false); err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ttl := min(dc.Preview.URLTTL, time.Until(share.ExpiresAt))
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(shareSessionCookie, services.ShareSession(share.ID, ttl), int(ttl.Seconds()),
+		"/s/"+c.Param("token"), "", c.Request.TLS != nil, true)
+	c.JSON(http.StatusOK, gin.H{
+		"url":       dc.shareURL(c, share),
+		"expiresIn": int(ttl.Seconds()),
+	})
+}
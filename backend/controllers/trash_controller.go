@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func trashErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrDocumentMissing):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrAlreadyPurged):
+		return http.StatusGone
+	case errors.Is(err, services.ErrNotInTrash), errors.Is(err, services.ErrPurging):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GET /trash lists deleted documents that can still be restored.
+func (dc *DocumentController) TrashHandler(c *gin.Context) {
+	docs := dc.Store.Trash(c.GetString("userID"))
+	list := make([]gin.H, 0, len(docs))
+	for _, doc := range docs {
+		item := gin.H{
+			"id":        doc.ID,
+			"name":      doc.Name,
+			"size":      doc.Size,
+			"hash":      doc.Hash,
+			"deletedAt": doc.DeletedAt,
+		}
+		if dc.Retention.TrashRetention > 0 && doc.DeletedAt != nil {
+			item["purgeAt"] = doc.PurgeAt(dc.Retention.TrashRetention)
+		}
+		list = append(list, item)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// POST /documents/:id/restore
+func (dc *DocumentController) RestoreHandler(c *gin.Context) {
+	if !dc.requirePermission(c, c.Param("id"), services.PermDelete) {
+		return
+	}
+	meta, err := dc.Store.Restore(c.Param("id"))
+	if err != nil {
+		c.JSON(trashErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	meta.URL = dc.documentURL(c, meta)
+	c.JSON(http.StatusOK, gin.H{"message": "Document restored", "document": meta})
+}
+
+// DELETE /trash/:id erases a trashed document right away instead of waiting
+// for the retention period, e.g. for an erasure request.
+func (dc *DocumentController) PurgeHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !dc.requirePermission(c, id, services.PermDelete) {
+		return
+	}
+	if err := dc.Store.PurgeDocument(id); err != nil {
+		if errors.Is(err, services.ErrLegalHold) || errors.Is(err, services.ErrUnderRetention) {
+			meta, _ := dc.Store.Get(id)
+			lockedResponse(c, meta, err)
+			return
+		}
+		status := trashErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			log.Printf("Failed to purge document %s: %v\n", id, err)
+			c.JSON(status, gin.H{"error": "Failed to purge document"})
+			return
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Document %s purged on request\n", id)
+	c.JSON(http.StatusOK, gin.H{"message": "Document purged"})
+}
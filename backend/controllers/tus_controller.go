@@ -0,0 +1,379 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"main/middleware"
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// tusUploadTTL is how long an unfinished resumable upload can be resumed.
+	tusUploadTTL = 24 * time.Hour
+	// tusChunkSize is the largest object a PATCH body is stored in. The
+	// offset advances after each one, so a dropped connection only loses the
+	// chunk in flight.
+	tusChunkSize = 5 << 20
+	// statusChecksumMismatch is the tus checksum extension's status code.
+	statusChecksumMismatch = 460
+)
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated
+// "key base64value" pairs, where the value may be omitted.
+func parseTusMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		value := ""
+		if len(fields) > 1 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata value for %q", fields[0])
+			}
+			value = string(decoded)
+		}
+		meta[fields[0]] = value
+	}
+	return meta, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// tusSession loads a tus upload of the caller, writing 404 or 410 when it
+// cannot be used.
+func tusSession(c *gin.Context) (services.UploadSession, bool) {
+	session, ok := services.UploadSessions.Get(c.Param("id"))
+	if !ok || session.Protocol != "tus" || session.UserID != c.GetString("userID") {
+		c.AbortWithStatus(http.StatusNotFound)
+		return session, false
+	}
+	if session.Expired() {
+		c.AbortWithStatus(http.StatusGone)
+		return session, false
+	}
+	return session, true
+}
+
+func setTusOffsetHeaders(c *gin.Context, session services.UploadSession) {
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Size, 10))
+	c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "no-store")
+}
+
+// OPTIONS /files
+func (dc *DocumentController) TusOptionsHandler(c *gin.Context) {
+	c.Header("Tus-Version", middleware.TusVersion)
+	c.Header("Tus-Extension", "creation,expiration,termination")
+	if dc.Upload.MaxUploadSize > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(dc.Upload.MaxUploadSize, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /files
+//
+// Upload-Metadata keys: filename (or name), filetype (or type), tag, sha256,
+// onDuplicate and orgId.
+func (dc *DocumentController) TusCreateHandler(c *gin.Context) {
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Defer-Length is not supported"})
+		return
+	}
+	size, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length is required"})
+		return
+	}
+	if dc.Upload.MaxUploadSize > 0 && size > dc.Upload.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File exceeds the maximum upload size of %s", services.FormatBytes(dc.Upload.MaxUploadSize))})
+		return
+	}
+
+	meta, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filename := firstNonEmpty(meta["filename"], meta["name"])
+	if filename == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filename metadata is required"})
+		return
+	}
+	if !uploadOrg(c, meta["orgId"]) || !withinStorageQuota(c, c.GetString("userID"), meta["orgId"], size) {
+		return
+	}
+	declared := strings.ToLower(meta["sha256"])
+	if declared != "" && !sha256Pattern.MatchString(declared) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sha256 must be a hex encoded SHA-256 digest"})
+		return
+	}
+
+	// Chunks wait in storage for up to a day, so they are encrypted too
+	enc, err := services.NewEncryption()
+	if err != nil {
+		log.Printf("Failed to create data key: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start upload"})
+		return
+	}
+
+	now := time.Now()
+	session := services.UploadSession{
+		ID:          services.NewRandomID(),
+		UserID:      c.GetString("userID"),
+		OrgID:       meta["orgId"],
+		ObjectName:  services.NewObjectName(filename),
+		Filename:    filename,
+		ContentType: firstNonEmpty(meta["filetype"], meta["type"], "application/octet-stream"),
+		Size:        size,
+		SHA256:      declared,
+		Tag:         firstNonEmpty(meta["tag"], "General"),
+		OnDuplicate: meta["onDuplicate"],
+		Protocol:    "tus",
+		Encryption:  enc,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(tusUploadTTL),
+	}
+	if err := services.UploadSessions.Put(session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save upload session"})
+		return
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+session.ID)
+	c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// HEAD /files/:id
+func (dc *DocumentController) TusHeadHandler(c *gin.Context) {
+	session, ok := tusSession(c)
+	if !ok {
+		return
+	}
+	setTusOffsetHeaders(c, session)
+	c.Status(http.StatusOK)
+}
+
+// PATCH /files/:id
+//
+// The request body is stored in objects of up to tusChunkSize under
+// tus/<id>/, recording the offset after each, so HEAD reports what arrived
+// before a dropped connection. When the last byte arrives the chunks are
+// joined and go through the same pipeline as POST /upload.
+func (dc *DocumentController) TusPatchHandler(c *gin.Context) {
+	id := c.Param("id")
+	unlock, ok := services.UploadSessions.TryLock(id)
+	if !ok {
+		c.JSON(http.StatusLocked, gin.H{"error": "Upload is being written by another request"})
+		return
+	}
+	defer unlock()
+
+	session, ok := tusSession(c)
+	if !ok {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset is required"})
+		return
+	}
+	if offset != session.Offset {
+		setTusOffsetHeaders(c, session)
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	remaining := session.Size - session.Offset
+	if c.Request.ContentLength > remaining {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Chunk exceeds Upload-Length"})
+		return
+	}
+
+	body := io.LimitReader(c.Request.Body, remaining)
+	buf := make([]byte, min(remaining, tusChunkSize))
+	for session.Offset < session.Size {
+		// An interrupted body ends the loop; what was read is kept and the
+		// client resumes from the offset reported by HEAD
+		n, readErr := io.ReadFull(body, buf)
+		if n > 0 {
+			chunk := fmt.Sprintf("tus/%s/%020d", id, session.Offset)
+			if _, _, err := services.UploadDocumentObject(chunk, bytes.NewReader(buf[:n]), "application/octet-stream", session.Encryption); err != nil {
+				log.Printf("tus: failed to store chunk of %s at %d: %v\n", id, session.Offset, err)
+				setTusOffsetHeaders(c, session)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store chunk"})
+				return
+			}
+			session.Offset += int64(n)
+			session.Chunks = append(session.Chunks, chunk)
+			if err := services.UploadSessions.Put(session); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save upload session"})
+				return
+			}
+		}
+		if readErr != nil {
+			if readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+				log.Printf("tus: body of %s ended at %d: %v\n", id, session.Offset, readErr)
+			}
+			break
+		}
+	}
+
+	if session.Offset == session.Size && session.ResultStatus == 0 {
+		status, body := dc.finishTus(c.Request.Context(), session)
+		c.Set("auditAction", "upload")
+		if docID, ok := body["id"].(string); ok {
+			c.Set("documentID", docID)
+		}
+		if status == http.StatusInternalServerError {
+			// Keep the chunks so a retried PATCH finishes again
+			setTusOffsetHeaders(c, session)
+			c.JSON(status, body)
+			return
+		}
+		session.DeleteChunks()
+		session.Chunks = nil
+		session.ResultStatus = status
+		session.Result = body
+		if err := services.UploadSessions.Put(session); err != nil {
+			log.Println("Warning: failed to save upload session:", err)
+		}
+		// Later PATCHes only read the result
+		services.UploadSessions.ForgetLock(id)
+	}
+
+	setTusOffsetHeaders(c, session)
+	if session.ResultStatus >= http.StatusBadRequest {
+		c.JSON(session.ResultStatus, session.Result)
+		return
+	}
+	if docID, ok := session.Result["id"].(string); ok {
+		c.Header("Upload-Document-Id", docID)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// finishTus joins the chunks of a complete upload, verifies the declared hash
+// and stores, anchors and analyzes the document.
+func (dc *DocumentController) finishTus(ctx context.Context, session services.UploadSession) (int, gin.H) {
+	chunks := services.OpenObjects(session.Chunks, session.Encryption)
+	defer chunks.Close()
+
+	spooled, size, fileHash, cleanup, err := services.SpoolToTemp(chunks)
+	if err != nil {
+		log.Printf("tus: failed to read chunks of %s: %v\n", session.ID, err)
+		return http.StatusInternalServerError, gin.H{"error": "Cannot read uploaded chunks"}
+	}
+	defer cleanup()
+	if size != session.Size {
+		return http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Uploaded chunks hold %d bytes, expected %d", size, session.Size)}
+	}
+
+	if session.SHA256 != "" && fileHash != session.SHA256 {
+		return statusChecksumMismatch, gin.H{"error": "Uploaded content does not match the declared sha256", "sha256": fileHash}
+	}
+
+	policy := session.OnDuplicate
+	if policy == "" {
+		policy = dc.Upload.DuplicatePolicy
+	}
+	chainDup, status, body := dc.resolveDuplicate(session.UserID, session.OrgID, policy, fileHash, session.Filename, size, session.Tag)
+	if status != 0 {
+		return status, body
+	}
+
+	enc, err := storeStream(spooled, session.ObjectName, session.ContentType, fileHash)
+	if err != nil {
+		log.Printf("Storage upload failed: %v\n", err)
+		return http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Storage upload failed: %v", err)}
+	}
+
+	meta, err := dc.saveNewDocument(ctx, newDocument{
+		UserID:     session.UserID,
+		OrgID:      session.OrgID,
+		Filename:   session.Filename,
+		ObjectName: session.ObjectName,
+		Hash:       fileHash,
+		Tag:        session.Tag,
+		Size:       size,
+		Content:    spooled,
+		Encryption: enc,
+		ChainDup:   chainDup,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"error": "Failed to save metadata"}
+	}
+
+	return http.StatusOK, gin.H{
+		"message": "Upload successful",
+		"id":      meta.ID,
+		"txHash":  meta.TxHash,
+		"meta":    meta,
+	}
+}
+
+// GET /files/:id returns the outcome of a finished upload, in the same shape
+// as POST /upload, or its progress while chunks are still arriving.
+func (dc *DocumentController) TusResultHandler(c *gin.Context) {
+	session, ok := services.UploadSessions.Get(c.Param("id"))
+	if !ok || session.Protocol != "tus" || session.UserID != c.GetString("userID") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	if session.ResultStatus != 0 {
+		c.JSON(session.ResultStatus, session.Result)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"uploadId":  session.ID,
+		"offset":    session.Offset,
+		"length":    session.Size,
+		"expiresAt": session.ExpiresAt,
+	})
+}
+
+// DELETE /files/:id (tus termination)
+func (dc *DocumentController) TusDeleteHandler(c *gin.Context) {
+	id := c.Param("id")
+	unlock, ok := services.UploadSessions.TryLock(id)
+	if !ok {
+		c.JSON(http.StatusLocked, gin.H{"error": "Upload is being written by another request"})
+		return
+	}
+	defer unlock()
+
+	session, ok := tusSession(c)
+	if !ok {
+		return
+	}
+	session.DeleteChunks()
+	if err := services.UploadSessions.Remove(id); err != nil {
+		log.Println("Warning: failed to remove upload session:", err)
+	}
+	c.Status(http.StatusNoContent)
+}
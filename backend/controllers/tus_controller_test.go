@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"main/config"
+	"main/services"
+)
+
+// droppedBody delivers data and then fails, like a connection lost mid-request.
+type droppedBody struct{ r io.Reader }
+
+func (d droppedBody) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+// tusCreate starts a tus upload of size bytes and returns its id.
+func tusCreate(t *testing.T, r http.Handler, user string, size int, meta map[string]string) string {
+	t.Helper()
+	var pairs []string
+	for k, v := range meta {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	w := serve(r, http.MethodPost, "/files", user, nil, map[string]string{
+		"Tus-Resumable":   "1.0.0",
+		"Upload-Length":   strconv.Itoa(size),
+		"Upload-Metadata": strings.Join(pairs, ","),
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+	return strings.TrimPrefix(w.Header().Get("Location"), "/files/")
+}
+
+func tusPatch(r http.Handler, id, user string, offset int, body io.Reader) *httptest.ResponseRecorder {
+	return serve(r, http.MethodPatch, "/files/"+id, user, body, map[string]string{
+		"Tus-Resumable": "1.0.0",
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	})
+}
+
+func TestTusResume(t *testing.T) {
+	r, dc, storage := newUploadTest(t)
+	content := []byte("minutes of the annual general meeting")
+	id := tusCreate(t, r, "alice", len(content), map[string]string{"filename": "minutes.txt", "sha256": services.Sha256Hex(content)})
+
+	// Uploads answer their owner only
+	for _, method := range []string{http.MethodHead, http.MethodPatch, http.MethodGet, http.MethodDelete} {
+		w := serve(r, method, "/files/"+id, "bob", bytes.NewReader(content), map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("bob %s = %d", method, w.Code)
+		}
+	}
+
+	if w := tusPatch(r, id, "alice", 5, bytes.NewReader(content[5:])); w.Code != http.StatusConflict || w.Header().Get("Upload-Offset") != "0" {
+		t.Errorf("PATCH at the wrong offset = %d, Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	// The connection drops after 10 bytes; they are kept and HEAD reports them
+	if w := tusPatch(r, id, "alice", 0, droppedBody{bytes.NewReader(content[:10])}); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "10" {
+		t.Fatalf("interrupted PATCH = %d, Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if w := serve(r, http.MethodHead, "/files/"+id, "alice", nil, map[string]string{"Tus-Resumable": "1.0.0"}); w.Header().Get("Upload-Offset") != "10" {
+		t.Errorf("HEAD after the drop: Upload-Offset %q", w.Header().Get("Upload-Offset"))
+	}
+	if w := tusPatch(r, id, "alice", 0, bytes.NewReader(content)); w.Code != http.StatusConflict || w.Header().Get("Upload-Offset") != "10" {
+		t.Errorf("PATCH from the start again = %d, Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	unlock, _ := services.UploadSessions.TryLock(id)
+	if w := tusPatch(r, id, "alice", 10, bytes.NewReader(content[10:])); w.Code != http.StatusLocked {
+		t.Errorf("PATCH while another is writing = %d", w.Code)
+	}
+	unlock()
+
+	w := tusPatch(r, id, "alice", 10, bytes.NewReader(content[10:]))
+	docID := w.Header().Get("Upload-Document-Id")
+	if w.Code != http.StatusNoContent || docID == "" {
+		t.Fatalf("final PATCH = %d %s", w.Code, w.Body)
+	}
+	meta, ok := dc.Store.Get(docID)
+	if !ok || meta.UserID != "alice" || meta.Hash != services.Sha256Hex(content) || !storage.has(meta.MinioID) {
+		t.Errorf("stored %+v", meta)
+	}
+	if n := storage.count("tus/"); n != 0 {
+		t.Errorf("%d chunks left after completion", n)
+	}
+
+	w = serve(r, http.MethodGet, "/files/"+id, "alice", nil, nil)
+	if w.Code != http.StatusOK || decode(t, w)["id"] != docID {
+		t.Errorf("result = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTusChecksumMismatch(t *testing.T) {
+	r, dc, storage := newUploadTest(t)
+	content := []byte("invoice 2041")
+	id := tusCreate(t, r, "alice", len(content), map[string]string{"filename": "invoice.txt", "sha256": services.Sha256Hex([]byte("invoice 2042"))})
+
+	w := tusPatch(r, id, "alice", 0, bytes.NewReader(content))
+	if w.Code != statusChecksumMismatch || decode(t, w)["sha256"] != services.Sha256Hex(content) {
+		t.Fatalf("PATCH = %d %s", w.Code, w.Body)
+	}
+	// The outcome is kept for clients that lost the response
+	if w := serve(r, http.MethodGet, "/files/"+id, "alice", nil, nil); w.Code != statusChecksumMismatch {
+		t.Errorf("result = %d", w.Code)
+	}
+	if len(dc.Store.Data) != 0 || storage.count("") != 0 {
+		t.Errorf("mismatched upload kept: %d documents, %d objects", len(dc.Store.Data), storage.count(""))
+	}
+}
+
+func TestTusDuplicatePolicies(t *testing.T) {
+	r, dc, _ := newUploadTest(t)
+	content := []byte("articles of association")
+	first := tusCreate(t, r, "alice", len(content), map[string]string{"filename": "articles.txt"})
+	originalID := tusPatch(r, first, "alice", 0, bytes.NewReader(content)).Header().Get("Upload-Document-Id")
+	if originalID == "" {
+		t.Fatal("first upload not stored")
+	}
+
+	for _, c := range []struct {
+		policy string
+		status int
+		check  func(w *httptest.ResponseRecorder)
+	}{
+		{config.DuplicateReject, http.StatusConflict, func(w *httptest.ResponseRecorder) {
+			if decode(t, w)["existingId"] != originalID {
+				t.Errorf("reject: %s", w.Body)
+			}
+		}},
+		{config.DuplicateLink, http.StatusNoContent, func(w *httptest.ResponseRecorder) {
+			if w.Header().Get("Upload-Document-Id") != originalID {
+				t.Errorf("link: Upload-Document-Id %q", w.Header().Get("Upload-Document-Id"))
+			}
+		}},
+		{config.DuplicateVersion, http.StatusNoContent, func(w *httptest.ResponseRecorder) {
+			meta, _ := dc.Store.Get(w.Header().Get("Upload-Document-Id"))
+			if meta.DuplicateOf != originalID || meta.UserID != "alice" {
+				t.Errorf("version: %+v", meta)
+			}
+		}},
+	} {
+		id := tusCreate(t, r, "alice", len(content), map[string]string{"filename": "articles.txt", "onDuplicate": c.policy})
+		w := tusPatch(r, id, "alice", 0, bytes.NewReader(content))
+		if w.Code != c.status {
+			t.Errorf("%s: PATCH = %d %s", c.policy, w.Code, w.Body)
+			continue
+		}
+		c.check(w)
+	}
+	if n := len(dc.Store.Data); n != 2 {
+		t.Errorf("%d documents, want the original and one version", n)
+	}
+}
+
+// Requests without the protocol header are refused before reaching the handlers
+func TestTusRequiresVersion(t *testing.T) {
+	r, _, _ := newUploadTest(t)
+	w := serve(r, http.MethodPost, "/files", "alice", nil, map[string]string{"Upload-Length": "10"})
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get("Tus-Version") != "1.0.0" {
+		t.Errorf("POST without Tus-Resumable = %d", w.Code)
+	}
+}
@@ -0,0 +1,329 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+
+	"main/config"
+	"main/contracts"
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead leaves room for form fields and boundaries on top of the
+// maximum file size when limiting the request body.
+const multipartOverhead = 1 << 20
+
+// openUpload limits the request body to the configured maximum and opens the
+// "file" form field. net/http spools parts larger than the router's
+// MaxMultipartMemory to a temp file, so the returned file supports random
+// access without holding the upload in memory. On failure the response has
+// already been written.
+func (dc *DocumentController) openUpload(c *gin.Context) (*multipart.FileHeader, multipart.File, bool) {
+	if dc.Upload.MaxUploadSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, dc.Upload.MaxUploadSize+multipartOverhead)
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File exceeds the maximum upload size of %s", services.FormatBytes(dc.Upload.MaxUploadSize))})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		return nil, nil, false
+	}
+	if dc.Upload.MaxUploadSize > 0 && fileHeader.Size > dc.Upload.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File exceeds the maximum upload size of %s", services.FormatBytes(dc.Upload.MaxUploadSize))})
+		return nil, nil, false
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cannot open file"})
+		return nil, nil, false
+	}
+	return fileHeader, file, true
+}
+
+// storeStream uploads file to storage from the start, checking that the bytes
+// sent still match the hash computed before the duplicate lookup. With
+// encryption at rest on, the object is encrypted under a new data key, which
+// is returned so it can be saved with the document.
+func storeStream(file multipart.File, objectName, contentType, expectedHash string) (*services.EncryptionInfo, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	enc, err := services.NewEncryption()
+	if err != nil {
+		return nil, err
+	}
+	sentHash, _, err := services.UploadDocumentObject(objectName, file, contentType, enc)
+	if err != nil {
+		return nil, err
+	}
+	if sentHash != expectedHash {
+		return nil, fmt.Errorf("content changed during upload: hash %s, expected %s", sentHash, expectedHash)
+	}
+	return enc, nil
+}
+
+// anchorResult is the outcome of registering a hash on the default network.
+type anchorResult struct {
+	TxHash  string
+	Network string
+	ChainID int64
+	// PendingReason is set when the registration has to be retried by the outbox.
+	PendingReason string
+}
+
+// anchor registers a document hash on the default network. Failures are
+// reported through PendingReason so the caller can queue a retry.
+func (dc *DocumentController) anchor(filename, fileHash, objectName, tag string) anchorResult {
+	eth := services.DefaultEth()
+	switch {
+	case dc.PrivateKey == "":
+		// Anchoring not configured
+		return anchorResult{}
+	case eth == nil:
+		return anchorResult{PendingReason: "blockchain network unavailable"}
+	}
+
+	txHash, err := eth.RegisterDocument(dc.PrivateKey, filename, fileHash, objectName, tag)
+	if err != nil {
+		log.Printf("Blockchain registration failed: %v\n", err)
+		return anchorResult{PendingReason: err.Error()}
+	}
+	return anchorResult{TxHash: txHash, Network: eth.Network, ChainID: eth.ChainID.Int64()}
+}
+
+func (a anchorResult) verificationStatus() string {
+	switch {
+	case a.TxHash != "":
+		return "Verified"
+	case a.PendingReason != "":
+		return "Pending"
+	default:
+		return "Unverified"
+	}
+}
+
+// enqueue hands a failed registration to the outbox.
+func (a anchorResult) enqueue(entry services.OutboxEntry) {
+	if a.PendingReason == "" {
+		return
+	}
+	if err := services.Outbox.Enqueue(entry, a.PendingReason); err != nil {
+		log.Println("Warning: failed to queue blockchain registration:", err)
+	}
+}
+
+// analysisResult holds the extracted text and AI fields of a document.
+type analysisResult struct {
+	Text     string
+	Summary  string
+	Category string
+	Validity string
+	AIStatus string // 'Processed' | 'Queued' | 'Failed'
+}
+
+// analyzeContent extracts the PDF text and runs the AI analysis for userID,
+// falling back to a plain summary when the structured analysis fails. Nothing
+// is generated once the user's monthly token quota is used up or the client
+// has gone.
+func analyzeContent(ctx context.Context, userID string, r io.ReaderAt, size int64, tag string) analysisResult {
+	res := analysisResult{
+		Summary:  "Pending analysis...",
+		Category: tag,
+		Validity: "N/A",
+		AIStatus: "Queued",
+	}
+
+	text, err := services.ExtractTextFromPDF(r, size)
+	if err != nil || text == "" {
+		res.AIStatus = "Failed"
+		return res
+	}
+	res.Text = text
+
+	analysis, err := services.AnalyzeDocument(ctx, userID, text)
+	if err == nil && analysis != nil {
+		res.Summary = analysis.Summary
+		res.Category = analysis.Category
+		res.Validity = analysis.Validity
+		res.AIStatus = "Processed"
+		return res
+	}
+	if errors.Is(err, services.ErrTokenQuota) {
+		res.AIStatus = "Failed"
+		res.Summary = "AI analysis skipped: monthly token quota exceeded."
+		return res
+	}
+	if ctx.Err() != nil {
+		res.AIStatus = "Failed"
+		return res
+	}
+
+	generatedSummary, err := services.GenerateCompletion(ctx, userID, text)
+	if err != nil {
+		res.AIStatus = "Failed"
+		return res
+	}
+	res.Summary = generatedSummary
+	res.AIStatus = "Processed"
+	return res
+}
+
+// uploadOrg checks that the caller may add documents to orgID; "" stands for
+// their personal documents. On failure the response has been written.
+func uploadOrg(c *gin.Context, orgID string) bool {
+	if orgID == "" {
+		return true
+	}
+	role := ""
+	if services.Orgs != nil {
+		role = services.Orgs.Role(orgID, c.GetString("userID"))
+	}
+	switch {
+	case role == "":
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return false
+	case !services.RoleAllows(role, services.PermWrite):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your role does not allow uploads to this organization"})
+		return false
+	}
+	return true
+}
+
+// checkDuplicate looks the hash up in the metadata store and, when enabled, on
+// chain. handled is true when the response has already been written. A
+// chain-only match is returned so the caller can skip re-anchoring.
+func (dc *DocumentController) checkDuplicate(c *gin.Context, orgID, policy, fileHash, filename string, size int64, tag string) (chainDup *contracts.ContractsDocumentRegistered, handled bool) {
+	chainDup, status, body := dc.resolveDuplicate(c.GetString("userID"), orgID, policy, fileHash, filename, size, tag)
+	if status != 0 {
+		c.JSON(status, body)
+		return nil, true
+	}
+	return chainDup, false
+}
+
+// resolveDuplicate applies the duplicate policy without writing a response,
+// for uploads that report their result later (tus). Only personal documents
+// of userID, or documents of orgID when set, count as duplicates. status is
+// 0 when the upload should go ahead.
+func (dc *DocumentController) resolveDuplicate(userID, orgID, policy, fileHash, filename string, size int64, tag string) (chainDup *contracts.ContractsDocumentRegistered, status int, body gin.H) {
+	if existing, found := dc.Store.FindByHash(fileHash, userID, orgID); found {
+		status, body = dc.handleDuplicate(userID, orgID, policy, existing, filename, size, tag)
+		return nil, status, body
+	}
+
+	eth := services.DefaultEth()
+	if !dc.Upload.DuplicateCheckChain || eth == nil {
+		return nil, 0, nil
+	}
+	chainDup, err := eth.FindDocumentByHash(fileHash)
+	if err != nil {
+		log.Printf("On-chain duplicate lookup failed: %v\n", err)
+	}
+	if chainDup != nil && policy == config.DuplicateReject {
+		return nil, http.StatusConflict, gin.H{
+			"error":   "Document already registered on chain",
+			"chainId": chainDup.Id.String(),
+			"txHash":  chainDup.Raw.TxHash.Hex(),
+		}
+	}
+	return chainDup, 0, nil
+}
+
+// newDocument is a file already stored under ObjectName and waiting to be
+// anchored, analyzed and recorded.
+type newDocument struct {
+	UserID     string
+	OrgID      string
+	Filename   string
+	ObjectName string
+	Hash       string
+	Tag        string
+	Size       int64
+	Content    io.ReaderAt
+	Encryption *services.EncryptionInfo
+	ChainDup   *contracts.ContractsDocumentRegistered
+}
+
+// saveNewDocument registers the hash on chain (unless already anchored), runs
+// the AI analysis and saves the metadata and text cache.
+func (dc *DocumentController) saveNewDocument(ctx context.Context, doc newDocument) (services.DocumentMetadata, error) {
+	// Register on blockchain (unless the hash is already anchored)
+	var anchored anchorResult
+	chainDocID := ""
+	if eth := services.DefaultEth(); doc.ChainDup != nil && eth != nil {
+		anchored = anchorResult{TxHash: doc.ChainDup.Raw.TxHash.Hex(), Network: eth.Network, ChainID: eth.ChainID.Int64()}
+		chainDocID = doc.ChainDup.Id.String()
+		log.Printf("Hash already anchored on chain (id %s), skipping registration\n", doc.ChainDup.Id)
+	} else {
+		anchored = dc.anchor(doc.Filename, doc.Hash, doc.ObjectName, doc.Tag)
+	}
+
+	// AI Analysis
+	analysis := analyzeContent(ctx, doc.UserID, doc.Content, doc.Size, doc.Tag)
+
+	// The on-chain id is only known once the registration is mined, and
+	// restarts on every contract, so documents get a random local ID
+	docID := services.NewRandomID()
+
+	// Save metadata
+	meta := services.DocumentMetadata{
+		ID:                 docID,
+		UserID:             doc.UserID,
+		OrgID:              doc.OrgID,
+		MinioID:            doc.ObjectName,
+		Name:               doc.Filename,
+		Size:               services.FormatBytes(doc.Size),
+		SizeBytes:          doc.Size,
+		Date:               services.CurrentDate(),
+		Hash:               doc.Hash,
+		AIStatus:           analysis.AIStatus,
+		VerificationStatus: anchored.verificationStatus(),
+		Type:               "pdf",
+		Category:           analysis.Category,
+		Summary:            analysis.Summary,
+		Validity:           analysis.Validity,
+		URL:                "",
+		Deleted:            false,
+		TxHash:             anchored.TxHash,
+		Network:            anchored.Network,
+		ChainID:            anchored.ChainID,
+		ChainDocID:         chainDocID,
+		Encryption:         doc.Encryption,
+	}
+
+	if err := dc.Store.AddOrUpdate(meta); err != nil {
+		return meta, err
+	}
+
+	// Queue the registration so it is retried once the network is back
+	anchored.enqueue(services.OutboxEntry{
+		DocID:    docID,
+		Filename: doc.Filename,
+		Hash:     doc.Hash,
+		MinioID:  doc.ObjectName,
+		Tag:      doc.Tag,
+	})
+
+	// Cache text for future use
+	if analysis.AIStatus == "Processed" && analysis.Text != "" {
+		if cacheErr := services.SaveTextCache(docID, analysis.Text); cacheErr != nil {
+			log.Println("Warning: failed to cache text:", cacheErr)
+		}
+	}
+
+	meta.ExplorerURL = services.ExplorerTxURL(meta.ChainID, meta.TxHash)
+	return meta, nil
+}
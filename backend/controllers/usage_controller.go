@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenQuotaExceeded answers 429 until the monthly token quota starts over.
+func tokenQuotaExceeded(c *gin.Context) {
+	c.Header("Retry-After", strconv.Itoa(int(time.Until(services.NextMonth(time.Now())).Seconds())+1))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": services.ErrTokenQuota.Error()})
+}
+
+// withinStorageQuota writes 413 if storing size more bytes in the personal
+// vault of userID, or in orgID, would exceed its quota.
+func withinStorageQuota(c *gin.Context, userID, orgID string, size int64) bool {
+	if services.Limits == nil {
+		return true
+	}
+	if err := services.Limits.CheckStorage(userID, orgID, size); err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// GET /usage?org=<id> shows the caller's storage and this month's AI tokens
+// against their quotas; 0 means unlimited.
+func (dc *DocumentController) UsageHandler(c *gin.Context) {
+	user := c.GetString("userID")
+	orgID := c.Query("org")
+	if orgID != "" && services.Orgs.Role(orgID, user) == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrOrgNotFound.Error()})
+		return
+	}
+
+	now := time.Now()
+	c.JSON(http.StatusOK, gin.H{
+		"storage": gin.H{
+			"used":  dc.Store.StorageUsed(user, orgID),
+			"quota": services.Limits.StorageQuota(orgID),
+		},
+		"tokens": gin.H{
+			"used":    services.Usage.Tokens(user, now),
+			"quota":   services.Limits.TokenQuota(),
+			"resetAt": services.NextMonth(now),
+		},
+	})
+}
@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseDate accepts either a plain date (2006-01-02) or an RFC 3339 timestamp.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// POST /documents/:id/versions
+func (dc *DocumentController) UploadVersionHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	meta, found := dc.userDocument(c, id)
+	if !found || meta.Deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	if !dc.permitted(c, meta, services.PermWrite) {
+		return
+	}
+
+	fileHeader, file, ok := dc.openUpload(c)
+	if !ok {
+		return
+	}
+	defer file.Close()
+	if !withinStorageQuota(c, meta.UserID, meta.OrgID, fileHeader.Size) {
+		return
+	}
+
+	tag := c.PostForm("tag")
+	if tag == "" {
+		tag = meta.Category
+	}
+
+	var effectiveFrom time.Time
+	if v := c.PostForm("effectiveFrom"); v != "" {
+		var err error
+		if effectiveFrom, err = parseDate(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "effectiveFrom must be YYYY-MM-DD or RFC 3339"})
+			return
+		}
+	}
+
+	fileHash, _, err := services.Sha256Reader(file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cannot read file"})
+		return
+	}
+	if fileHash == meta.Hash {
+		c.JSON(http.StatusConflict, gin.H{"error": "File is identical to the current version"})
+		return
+	}
+
+	objectName := services.NewObjectName(fileHeader.Filename)
+	enc, err := storeStream(file, objectName, fileHeader.Header.Get("Content-Type"), fileHash)
+	if err != nil {
+		log.Printf("Storage upload failed: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Storage upload failed: %v", err)})
+		return
+	}
+
+	anchored := dc.anchor(fileHeader.Filename, fileHash, objectName, tag)
+	analysis := analyzeContent(c.Request.Context(), c.GetString("userID"), file, fileHeader.Size, tag)
+
+	// Keep the text of the first upload addressable as version 1
+	if len(meta.Versions) == 0 {
+		if text, err := services.GetTextCache(id); err == nil {
+			if cacheErr := services.SaveTextCache(services.VersionTextCacheID(id, 1), text); cacheErr != nil {
+				log.Println("Warning: failed to cache text:", cacheErr)
+			}
+		}
+	}
+
+	meta, version, err := dc.Store.AppendVersion(id, services.DocumentVersion{
+		Name:          fileHeader.Filename,
+		Size:          services.FormatBytes(fileHeader.Size),
+		SizeBytes:     fileHeader.Size,
+		Hash:          fileHash,
+		MinioID:       objectName,
+		Encryption:    enc,
+		TxHash:        anchored.TxHash,
+		Network:       anchored.Network,
+		ChainID:       anchored.ChainID,
+		EffectiveFrom: effectiveFrom,
+		Note:          c.PostForm("note"),
+	}, func(m *services.DocumentMetadata) {
+		m.Date = services.CurrentDate()
+		m.VerificationStatus = anchored.verificationStatus()
+		m.AIStatus = analysis.AIStatus
+		m.Summary = analysis.Summary
+		m.Category = analysis.Category
+		m.Validity = analysis.Validity
+	})
+	if errors.Is(err, services.ErrDocumentMissing) {
+		// Deleted while uploading; keep it deleted and drop the new object
+		if delErr := services.DeleteObject(objectName); delErr != nil {
+			log.Printf("Warning: could not delete %s: %v\n", objectName, delErr)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save metadata"})
+		return
+	}
+
+	anchored.enqueue(services.OutboxEntry{
+		DocID:    id,
+		Filename: fileHeader.Filename,
+		Hash:     fileHash,
+		MinioID:  objectName,
+		Tag:      tag,
+		Version:  version.Number,
+	})
+
+	if analysis.Text != "" {
+		if cacheErr := services.SaveTextCache(services.VersionTextCacheID(id, version.Number), analysis.Text); cacheErr != nil {
+			log.Println("Warning: failed to cache text:", cacheErr)
+		}
+		// Chat and summaries work on the latest version
+		if cacheErr := services.SaveTextCache(id, analysis.Text); cacheErr != nil {
+			log.Println("Warning: failed to cache text:", cacheErr)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Version uploaded",
+		"id":      id,
+		"version": version,
+		"meta":    meta,
+	})
+}
+
+// GET /documents/:id/versions
+func (dc *DocumentController) ListVersionsHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	meta, found := dc.userDocument(c, id)
+	if !found || meta.Deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	services.EnsureInitialVersion(&meta)
+
+	brokenAt := services.VerifyVersionChain(meta.Versions)
+	resp := gin.H{
+		"id":         id,
+		"current":    meta.Versions[len(meta.Versions)-1].Number,
+		"chainValid": brokenAt == 0,
+		"versions":   meta.Versions,
+	}
+	if brokenAt != 0 {
+		resp["brokenAt"] = brokenAt
+	}
+
+	// ?at=2024-06-30 answers which version was in force on that date
+	if at := c.Query("at"); at != "" {
+		t, err := parseDate(at)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at must be YYYY-MM-DD or RFC 3339"})
+			return
+		}
+		if v, ok := services.VersionAt(meta, t); ok {
+			resp["inForce"] = v
+		} else {
+			resp["inForce"] = nil
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GET /documents/:id/versions/diff?from=1&to=2
+func (dc *DocumentController) DiffVersionsHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	meta, found := dc.userDocument(c, id)
+	if !found || meta.Deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	services.EnsureInitialVersion(&meta)
+	latest := meta.Versions[len(meta.Versions)-1].Number
+
+	to, err := strconv.Atoi(c.DefaultQuery("to", strconv.Itoa(latest)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'to' version"})
+		return
+	}
+	from, err := strconv.Atoi(c.DefaultQuery("from", strconv.Itoa(to-1)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'from' version"})
+		return
+	}
+	if _, ok := services.FindVersion(meta, from); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found", "version": from})
+		return
+	}
+	if _, ok := services.FindVersion(meta, to); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found", "version": to})
+		return
+	}
+
+	oldText, err := versionText(id, from, latest)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Text not available for version", "version": from})
+		return
+	}
+	newText, err := versionText(id, to, latest)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Text not available for version", "version": to})
+		return
+	}
+
+	diff, err := services.DiffLines(oldText, newText)
+	if errors.Is(err, services.ErrDiffTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Documents too large to diff"})
+		return
+	}
+
+	added, removed := 0, 0
+	for _, d := range diff {
+		switch d.Op {
+		case "+":
+			added++
+		case "-":
+			removed++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"from":    from,
+		"to":      to,
+		"added":   added,
+		"removed": removed,
+		"diff":    diff,
+	})
+}
+
+// versionText reads the cached text of a version. The latest version is also
+// cached under the document id, which covers documents cached before versioning.
+func versionText(id string, number, latest int) (string, error) {
+	text, err := services.GetTextCache(services.VersionTextCacheID(id, number))
+	if err != nil && number == latest {
+		return services.GetTextCache(id)
+	}
+	return text, err
+}
@@ -1,65 +1,193 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"main/config"
 	"main/controllers"
+	"main/middleware"
 	"main/routes"
 	"main/services"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Load .env
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found")
+	// Load and validate the configuration (flags, environment, .env, file)
+	cfg, err := config.Load(os.Args[1:])
+	if cfg.PrintOnly {
+		fmt.Print(cfg.Redacted())
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.PrintOnly {
+		return
+	}
+
+	// ctx is cancelled on SIGINT or SIGTERM and stops the background workers
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log.Print("Configuration:\n", cfg.Redacted())
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		log.Fatal("Failed to create data directory:", err)
 	}
 
 	// Initialize JSON Metadata Store
-	if err := services.InitMetadataStore("metadata.json"); err != nil {
+	if err := services.InitMetadataStore(cfg.Path("metadata.json")); err != nil {
 		log.Fatal("Failed to init metadata store:", err)
 	}
+	authCfg := cfg.Auth
+	if err := middleware.InitAuth(authCfg); err != nil {
+		log.Fatal("Failed to init authentication:", err)
+	}
+	if authCfg.LegacyOwner != "" {
+		if n, err := services.Store.ClaimUnowned(authCfg.LegacyOwner); err != nil {
+			log.Fatal("Failed to assign documents without owner:", err)
+		} else if n > 0 {
+			log.Printf("Assigned %d documents without owner to %s\n", n, authCfg.LegacyOwner)
+		}
+	}
 
 	// Initialize Storage (MinIO/S3)
-	s3Cfg := config.LoadS3Config()
-	services.InitStorage(s3Cfg)
+	services.InitStorage(cfg.S3)
+
+	// Initialize encryption at rest (envelope keys wrapped by the KMS)
+	if err := services.InitEncryption(cfg.Encryption); err != nil {
+		log.Fatal("Failed to init encryption:", err)
+	}
+	if services.KMS != nil {
+		log.Println("Encryption at rest enabled with master key", services.KMS.KeyID())
+	}
 
 	// Initialize Ethereum
-	ethCfg := config.LoadEthConfig()
+	ethCfg := cfg.Eth
 	services.InitEth(ethCfg)
+	services.StartEthReconnect(ctx, ethCfg)
+
+	// Initialize registration outbox (retries failed/skipped anchoring)
+	if err := services.InitOutbox(cfg.Path("outbox.json")); err != nil {
+		log.Fatal("Failed to init registration outbox:", err)
+	}
+	if err := services.InitUploadSessions(cfg.Path("uploads.json")); err != nil {
+		log.Fatal("Failed to init upload sessions:", err)
+	}
+	services.StartUploadPurger(ctx)
+	services.StartOutboxWorker(ctx, ethCfg.PrivateKey)
+	if ethCfg.PrivateKey != "" {
+		for _, eth := range services.AllNetworks() {
+			signer, isOwner, err := eth.CheckSignerOwnership(ethCfg.PrivateKey)
+			if err != nil {
+				log.Printf("Warning: could not verify contract ownership of signing key on %s: %v\n", eth.Network, err)
+			} else if !isOwner {
+				log.Printf("Warning: signer %s is not an owner of contract %s on %s; registrations will fail. Run `cryptodoc-admin -network %s add-owner %s` with an owner key.\n", signer.Hex(), eth.Address.Hex(), eth.Network, eth.Network, signer.Hex())
+			}
+		}
+	}
+	if services.DefaultEth() == nil {
+		log.Println("Warning: default network unavailable; uploads will be queued for anchoring until it reconnects.")
+	}
 
 	// Initialize text cache
-	if err := services.InitTextCache(); err != nil {
+	if err := services.InitTextCache(cfg.Path("text_cache")); err != nil {
 		log.Println("Warning: could not initialize text cache:", err)
 	}
 
+	// Initialize AI analysis
+	services.InitAI(cfg.OpenAIKey)
+
 	// Initialize Controller
-	docController := controllers.NewDocumentController(services.Eth, ethCfg.PrivateKey, services.Store)
+	services.InitContentSigning(cfg.Preview.SigningKey)
+	if err := services.InitOrgs(cfg.Path("orgs.json")); err != nil {
+		log.Fatal("Failed to init organizations:", err)
+	}
+	if err := services.InitAPIKeys(cfg.Path("api_keys.json"), authCfg.APIKeyRateLimit); err != nil {
+		log.Fatal("Failed to init API keys:", err)
+	}
+	if err := services.InitShares(cfg.Path("shares.json")); err != nil {
+		log.Fatal("Failed to init share links:", err)
+	}
+	if err := services.InitLimits(cfg.Path("usage.json"), cfg.Limits); err != nil {
+		log.Fatal("Failed to init usage quotas:", err)
+	}
+	if err := services.InitAudit(cfg.Path("audit.jsonl"), cfg.Path("audit_anchors.json")); err != nil {
+		log.Fatal("Failed to init audit log:", err)
+	}
+	services.StartAuditAnchoring(ctx, ethCfg.PrivateKey, cfg.Audit.AnchorInterval)
+	if err := services.InitLifecycle(cfg.Path("tiers.json"), cfg.Lifecycle); err != nil {
+		log.Fatal("Failed to init storage tiers:", err)
+	}
+	services.StartLifecycle(ctx)
+	services.InitScrubber(cfg.Scrub)
+	services.Scrub.Start(ctx)
+	retentionCfg := cfg.Retention
+	services.InitRetention(retentionCfg.CategoryRetention)
+	if err := services.Store.ApplyRetentionPolicies(); err != nil {
+		log.Println("Warning: could not apply retention policies:", err)
+	}
+	services.StartPurger(ctx, retentionCfg.TrashRetention, retentionCfg.PurgeInterval)
+	docController := controllers.NewDocumentController(ethCfg.PrivateKey, services.Store, cfg.Upload, cfg.Preview, retentionCfg)
+	adminController := controllers.NewAdminController(ethCfg.PrivateKey)
 
 	// Setup Router
 	r := gin.Default()
+	if err := r.SetTrustedProxies(cfg.Security.TrustedProxies); err != nil {
+		log.Fatal("TRUSTED_PROXIES: ", err)
+	}
+	// Keep at most 8 MB of each multipart upload in memory; larger files are
+	// spooled to disk by net/http and streamed from there.
+	r.MaxMultipartMemory = 8 << 20
 
-	// CORS
-	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		c.Next()
-	})
+	// CORS and security headers
+	securityCfg := cfg.Security
+	r.Use(middleware.SecurityHeaders(securityCfg))
+	r.Use(middleware.CORS(securityCfg))
+
+	// Record document actions in the audit log
+	r.Use(middleware.AuditMiddleware())
+
+	// Document routes require a Supabase token; see routes.RegisterRoutes
+	routes.RegisterRoutes(r, docController, authCfg, securityCfg)
+	routes.RegisterAdminRoutes(r, adminController, docController, cfg.AdminToken)
+	routes.RegisterUI(r, securityCfg.UIContentSecurityPolicy)
 
-	// Register routes without authentication middleware
-	routes.RegisterRoutes(r, docController)
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	// A listener error (e.g. the port is taken) goes through the same
+	// shutdown as a signal
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Let in-flight uploads and registrations finish, then stop the workers
+	// and close connections
+	failed := false
+	select {
+	case <-ctx.Done():
+	case err := <-serveErr:
+		log.Println("Server failed:", err)
+		failed = true
+	}
+	stop()
+	log.Printf("Shutting down, waiting up to %s for requests to finish\n", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("Warning: requests still running at shutdown:", err)
+	}
+	if err := services.Shutdown(shutdownCtx); err != nil {
+		log.Println("Warning: unclean shutdown:", err)
+	}
+	log.Println("Server stopped")
+	if failed {
+		os.Exit(1)
 	}
-	r.Run(":" + port)
 }
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminMiddleware protects contract administration endpoints with a shared
+// token sent in the X-Admin-Token header. Admin routes are disabled when
+// the token (ADMIN_API_TOKEN) is empty. Accepted requests have "admin" set
+// in the Gin context.
+func AdminMiddleware(expected string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if expected == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin API disabled"})
+			c.Abort()
+			return
+		}
+
+		given := c.GetHeader("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(expected)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+			c.Abort()
+			return
+		}
+
+		c.Set("admin", true)
+		c.Next()
+	}
+}
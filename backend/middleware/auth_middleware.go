@@ -1,67 +1,238 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
+	"slices"
 	"strings"
 
+	"main/config"
+	"main/services"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
-			return
+// authError carries the status AuthMiddleware answers with.
+type authError struct {
+	status int
+	msg    string
+}
+
+func (e *authError) Error() string { return e.msg }
+
+// Identity is the caller described by a verified token or API key.
+type Identity struct {
+	UserID string
+	Email  string
+	Role   string
+	// Compliance is set for tokens whose role is AUTH_COMPLIANCE_ROLE.
+	Compliance bool
+	// APIKeyID and Scopes are set for API keys, which act for their owner
+	// within their scopes. JWT sessions have every scope.
+	APIKeyID string
+	Scopes   []string
+}
+
+func (id Identity) HasScope(scope string) bool {
+	return id.APIKeyID == "" || slices.Contains(id.Scopes, scope)
+}
+
+// tokenVerifier checks HS256 tokens against the Supabase JWT secret and
+// RS256/ES256 tokens against a JWKS.
+type tokenVerifier struct {
+	secret         []byte
+	keys           *keySet
+	parser         *jwt.Parser
+	roleClaim      string
+	complianceRole string
+}
+
+var verifier *tokenVerifier
+
+// InitAuth configures token verification. The JWKS is loaded right away; an
+// unreachable endpoint is only a warning since keys are fetched again on
+// demand, but an unreadable JWKS file is an error.
+func InitAuth(cfg config.AuthConfig) error {
+	v := &tokenVerifier{roleClaim: cfg.RoleClaim, complianceRole: cfg.ComplianceRole}
+	var methods []string
+	if cfg.JWTSecret != "" {
+		v.secret = []byte(cfg.JWTSecret)
+		methods = append(methods, "HS256")
+	}
+	if cfg.JWKSURL != "" || cfg.JWKSFile != "" {
+		v.keys = newKeySet(cfg.JWKSURL, cfg.JWKSFile, cfg.JWKSRefresh)
+		methods = append(methods, "RS256", "RS384", "RS512", "ES256", "ES384", "ES512")
+		if err := v.keys.load(); err != nil {
+			if cfg.JWKSFile != "" {
+				return fmt.Errorf("load JWKS file: %w", err)
+			}
+			log.Println("Warning: could not load JWKS, retrying on first request:", err)
 		}
+	}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
-			c.Abort()
-			return
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithLeeway(cfg.ClockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	verifier = v
+	return nil
+}
+
+func (v *tokenVerifier) keyFor(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if v.secret == nil {
+			return nil, errors.New("HMAC tokens are not accepted")
+		}
+		return v.secret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		if v.keys == nil {
+			return nil, errors.New("no JWKS configured")
 		}
+		kid, _ := token.Header["kid"].(string)
+		return v.keys.key(kid)
+	}
+	return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+}
 
-		secret := os.Getenv("SUPABASE_JWT_SECRET")
-		if secret == "" {
-			// Fallback or error if secret not configured
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server configuration error"})
-			c.Abort()
-			return
+// IdentityFromAuthHeader validates a "Bearer <jwt>" Authorization header and
+// returns the caller it names: the sub claim, the email claim and the role
+// claim (AUTH_ROLE_CLAIM, which may be a dotted path like app_metadata.role).
+func IdentityFromAuthHeader(authHeader string) (Identity, error) {
+	if authHeader == "" {
+		return Identity{}, &authError{http.StatusUnauthorized, "Authorization header required"}
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if tokenString == authHeader {
+		return Identity{}, &authError{http.StatusUnauthorized, "Invalid token format"}
+	}
+
+	v := verifier
+	if v == nil || (v.secret == nil && v.keys == nil) {
+		// Neither SUPABASE_JWT_SECRET nor a JWKS is configured
+		return Identity{}, &authError{http.StatusInternalServerError, "Server configuration error"}
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := v.parser.ParseWithClaims(tokenString, claims, v.keyFor)
+	if err != nil || !token.Valid {
+		return Identity{}, &authError{http.StatusUnauthorized, "Invalid token"}
+	}
+
+	userID, ok := claims["sub"].(string)
+	if !ok || userID == "" {
+		return Identity{}, &authError{http.StatusUnauthorized, "User ID not found in token"}
+	}
+	email, _ := claims["email"].(string)
+	role := claimString(claims, v.roleClaim)
+	return Identity{UserID: userID, Email: email, Role: role, Compliance: role != "" && role == v.complianceRole}, nil
+}
+
+// IdentityFromRequest authenticates a request with an API key, sent as
+// X-API-Key or as a bearer token, or with a JWT.
+func IdentityFromRequest(c *gin.Context) (Identity, error) {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return identityFromAPIKey(key)
+	}
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && services.IsAPIKey(token) {
+		return identityFromAPIKey(token)
+	}
+	return IdentityFromAuthHeader(c.GetHeader("Authorization"))
+}
+
+func identityFromAPIKey(secret string) (Identity, error) {
+	if services.APIKeys == nil {
+		return Identity{}, &authError{http.StatusUnauthorized, "API keys are not enabled"}
+	}
+	key, err := services.APIKeys.Authenticate(secret)
+	switch {
+	case errors.Is(err, services.ErrAPIKeyRateLimited):
+		return Identity{}, &authError{http.StatusTooManyRequests, err.Error()}
+	case err != nil:
+		return Identity{}, &authError{http.StatusUnauthorized, err.Error()}
+	}
+	return Identity{UserID: key.UserID, APIKeyID: key.ID, Scopes: key.Scopes}, nil
+}
+
+// claimString follows a dotted claim path. For a list, such as a roles claim,
+// the first entry is used.
+func claimString(claims jwt.MapClaims, path string) string {
+	var value any = map[string]any(claims)
+	for _, part := range strings.Split(path, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = m[part]
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case []any:
+		if len(v) > 0 {
+			s, _ := v[0].(string)
+			return s
 		}
+	}
+	return ""
+}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// AuthMiddleware rejects requests without a valid token or API key and puts
+// the caller's userID, email and role in the Gin context, and compliance for
+// the compliance role. API keys also set apiKeyID and scopes.
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := IdentityFromRequest(c)
+		if err != nil {
+			status := http.StatusUnauthorized
+			var ae *authError
+			if errors.As(err, &ae) {
+				status = ae.status
 			}
-			return []byte(secret), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			if status == http.StatusTooManyRequests {
+				c.Header("Retry-After", "60")
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
-			return
+		c.Set("userID", id.UserID)
+		c.Set("email", id.Email)
+		c.Set("role", id.Role)
+		if id.Compliance {
+			c.Set("compliance", true)
+		}
+		if id.APIKeyID != "" {
+			c.Set("apiKeyID", id.APIKeyID)
+			c.Set("scopes", id.Scopes)
 		}
+		c.Next()
+	}
+}
 
-		userID, ok := claims["sub"].(string)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in token"})
+// RequireScope rejects API keys without the given scope. It runs after
+// AuthMiddleware; JWT sessions always pass.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if scopes, ok := c.Get("scopes"); ok && !slices.Contains(scopes.([]string), scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("API key lacks the %q scope", scope)})
 			c.Abort()
 			return
 		}
-
-		c.Set("userID", userID)
 		c.Next()
 	}
 }
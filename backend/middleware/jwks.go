@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// jwksMinRefetch limits how often an unknown key id or a failing endpoint
+// triggers another fetch.
+const jwksMinRefetch = time.Minute
+
+// jwk is one key of a JSON Web Key Set (RFC 7517).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// keySet caches the signing keys published at a JWKS endpoint or stored in a
+// local JWKS file. Keys are reloaded once they are older than refresh, and
+// early when a token names a key id that is not cached yet (key rotation).
+// The last good keys stay in use while the source is unreachable.
+type keySet struct {
+	url     string
+	file    string
+	refresh time.Duration
+	client  *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	loaded  time.Time // last successful load
+	fetched time.Time // last attempt
+}
+
+func newKeySet(url, file string, refresh time.Duration) *keySet {
+	return &keySet{url: url, file: file, refresh: refresh, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// key returns the public key with the given id. Tokens without a kid are
+// accepted when the set holds a single key.
+func (ks *keySet) key(kid string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	k, ok := ks.lookup(kid)
+	if (!ok || time.Since(ks.loaded) > ks.refresh) && time.Since(ks.fetched) >= jwksMinRefetch {
+		if err := ks.loadLocked(); err != nil {
+			log.Println("Warning: could not refresh JWKS:", err)
+		}
+		k, ok = ks.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return k, nil
+}
+
+func (ks *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, k := range ks.keys {
+			return k, true
+		}
+	}
+	k, ok := ks.keys[kid]
+	return k, ok
+}
+
+func (ks *keySet) load() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.loadLocked()
+}
+
+func (ks *keySet) loadLocked() error {
+	ks.fetched = time.Now()
+	data, err := ks.read()
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("invalid JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			log.Printf("Warning: skipping JWKS key %q: %v\n", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	if len(keys) == 0 {
+		return errors.New("JWKS holds no usable signing keys")
+	}
+	ks.keys = keys
+	ks.loaded = time.Now()
+	return nil
+}
+
+func (ks *keySet) read() ([]byte, error) {
+	if ks.file != "" {
+		return os.ReadFile(ks.file)
+	}
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint answered %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// publicKey decodes an RSA or EC (P-256, P-384, P-521) key.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if errX != nil || errY != nil || len(x) > size || len(y) > size {
+			return nil, errors.New("invalid coordinates")
+		}
+		// Uncompressed point: 0x04 || X || Y, each left-padded to the curve size
+		point := make([]byte, 1+2*size)
+		point[0] = 4
+		copy(point[1+size-len(x):], x)
+		copy(point[1+2*size-len(y):], y)
+		return ecdsa.ParseUncompressedPublicKey(curve, point)
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
@@ -0,0 +1,342 @@
+package middleware
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"main/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "https://auth.example.com"
+	testAudience = "cryptodoc"
+)
+
+var b64 = base64.RawURLEncoding
+
+func rsaJWK(kid string, pub *rsa.PublicKey) jwk {
+	e := []byte{byte(pub.E >> 16), byte(pub.E >> 8), byte(pub.E)}
+	return jwk{Kty: "RSA", Kid: kid, Use: "sig", N: b64.EncodeToString(pub.N.Bytes()), E: b64.EncodeToString(bytes.TrimLeft(e, "\x00"))}
+}
+
+// ecJWK drops leading zero bytes of the coordinates, as some issuers do.
+func ecJWK(kid, crv string, pub *ecdsa.PublicKey) jwk {
+	point, err := pub.Bytes()
+	if err != nil {
+		panic(err)
+	}
+	size := (len(point) - 1) / 2
+	x, y := point[1:1+size], point[1+size:]
+	return jwk{Kty: "EC", Kid: kid, Crv: crv, X: b64.EncodeToString(bytes.TrimLeft(x, "\x00")), Y: b64.EncodeToString(bytes.TrimLeft(y, "\x00"))}
+}
+
+// jwksServer publishes a key set that tests can replace or break.
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []jwk
+	fail    bool
+	fetches int
+}
+
+func newJWKSServer(t *testing.T, keys ...jwk) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		if s.fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) set(fail bool, keys ...jwk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail, s.keys = fail, keys
+}
+
+func (s *jwksServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// validClaims returns claims that pass every check, with changes applied.
+func validClaims(changes jwt.MapClaims) jwt.MapClaims {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":          "user-1",
+		"email":        "user@example.com",
+		"iss":          testIssuer,
+		"aud":          []string{"other", testAudience},
+		"iat":          now.Unix(),
+		"exp":          now.Add(time.Hour).Unix(),
+		"app_metadata": map[string]any{"roles": []string{"admin", "viewer"}},
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+func initTestAuth(t *testing.T, cfg config.AuthConfig) {
+	t.Helper()
+	cfg.JWKSRefresh = cmp.Or(cfg.JWKSRefresh, time.Hour)
+	cfg.Issuer, cfg.Audience, cfg.ClockSkew, cfg.RoleClaim = testIssuer, testAudience, 30*time.Second, "app_metadata.roles"
+	if err := InitAuth(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { verifier = nil })
+}
+
+func TestJWKSVerifiesTokens(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherRSA, _ := rsa.GenerateKey(rand.Reader, 2048)
+	srv := newJWKSServer(t, rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", "P-256", &ecKey.PublicKey))
+	initTestAuth(t, config.AuthConfig{JWKSURL: srv.URL})
+
+	rsaPub, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	now := time.Now()
+	for _, c := range []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"RS256", sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", validClaims(nil)), true},
+		{"ES256", sign(t, jwt.SigningMethodES256, ecKey, "ec-1", validClaims(nil)), true},
+		{"single audience", sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", validClaims(jwt.MapClaims{"aud": testAudience})), true},
+		{"expired within leeway", sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", validClaims(jwt.MapClaims{"exp": now.Add(-10 * time.Second).Unix()})), true},
+		{"not yet valid within leeway", sign(t, jwt.SigningMethodES256, ecKey, "ec-1", validClaims(jwt.MapClaims{"nbf": now.Add(10 * time.Second).Unix()})), true},
+
+		{"wrong audience", sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", validClaims(jwt.MapClaims{"aud": "other"})), false},
+		{"no audience", sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", validClaims(jwt.MapClaims{"aud": nil})), false},
+		{"wrong issuer", sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", validClaims(jwt.MapClaims{"iss": "https://evil.example.com"})), false},
+		{"expired", sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", validClaims(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()})), false},
+		{"no expiry", sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", validClaims(jwt.MapClaims{"exp": nil})), false},
+		{"not yet valid", sign(t, jwt.SigningMethodES256, ecKey, "ec-1", validClaims(jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()})), false},
+		{"issued in the future", sign(t, jwt.SigningMethodES256, ecKey, "ec-1", validClaims(jwt.MapClaims{"iat": now.Add(time.Minute).Unix()})), false},
+		{"unknown kid", sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-2", validClaims(nil)), false},
+		{"kid of another key", sign(t, jwt.SigningMethodRS256, otherRSA, "rsa-1", validClaims(nil)), false},
+		{"RSA key named for an EC token", sign(t, jwt.SigningMethodES256, ecKey, "rsa-1", validClaims(nil)), false},
+		{"no kid with several keys", sign(t, jwt.SigningMethodRS256, rsaKey, "", validClaims(nil)), false},
+		{"HS256 signed with the public key", sign(t, jwt.SigningMethodHS256, rsaPub, "rsa-1", validClaims(nil)), false},
+		{"alg none", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "rsa-1", validClaims(nil)), false},
+		{"no subject", sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", validClaims(jwt.MapClaims{"sub": nil})), false},
+	} {
+		id, err := IdentityFromAuthHeader("Bearer " + c.token)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err = %v", c.name, err)
+			continue
+		}
+		if c.ok && (id.UserID != "user-1" || id.Email != "user@example.com" || id.Role != "admin") {
+			t.Errorf("%s: identity = %+v", c.name, id)
+		}
+	}
+}
+
+func TestJWKSAlongsideSecret(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	srv := newJWKSServer(t, rsaJWK("rsa-1", &rsaKey.PublicKey))
+	initTestAuth(t, config.AuthConfig{JWKSURL: srv.URL, JWTSecret: "supabase-secret"})
+
+	if _, err := IdentityFromAuthHeader("Bearer " + sign(t, jwt.SigningMethodHS256, []byte("supabase-secret"), "", validClaims(nil))); err != nil {
+		t.Errorf("HS256 with the secret: %v", err)
+	}
+	if _, err := IdentityFromAuthHeader("Bearer " + sign(t, jwt.SigningMethodRS256, rsaKey, "", validClaims(nil))); err != nil {
+		t.Errorf("RS256 without kid, single key: %v", err)
+	}
+	// The classic confusion: HS256 keyed with the published RSA key
+	rsaPub, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if _, err := IdentityFromAuthHeader("Bearer " + sign(t, jwt.SigningMethodHS256, rsaPub, "rsa-1", validClaims(nil))); err == nil {
+		t.Error("HS256 keyed with the public key accepted")
+	}
+}
+
+// Only tokens carrying AUTH_COMPLIANCE_ROLE may change holds and retention
+func TestComplianceRole(t *testing.T) {
+	initTestAuth(t, config.AuthConfig{JWTSecret: "supabase-secret", ComplianceRole: "compliance"})
+	for _, c := range []struct {
+		roles      []string
+		compliance bool
+	}{
+		{[]string{"compliance"}, true},
+		{[]string{"admin", "compliance"}, false}, // the first entry is the role
+		{nil, false},
+	} {
+		token := sign(t, jwt.SigningMethodHS256, []byte("supabase-secret"), "", validClaims(jwt.MapClaims{"app_metadata": map[string]any{"roles": c.roles}}))
+		id, err := IdentityFromAuthHeader("Bearer " + token)
+		if err != nil || id.Compliance != c.compliance {
+			t.Errorf("roles %v: compliance = %v, err %v", c.roles, id.Compliance, err)
+		}
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	srv := newJWKSServer(t, rsaJWK("old", &oldKey.PublicKey))
+	initTestAuth(t, config.AuthConfig{JWKSURL: srv.URL, JWKSRefresh: time.Hour})
+	oldToken := sign(t, jwt.SigningMethodRS256, oldKey, "old", validClaims(nil))
+	newToken := sign(t, jwt.SigningMethodES384, newKey, "new", validClaims(nil))
+	if srv.count() != 1 {
+		t.Fatalf("fetches at start = %d", srv.count())
+	}
+
+	// An unknown kid refetches, but at most once per jwksMinRefetch
+	srv.set(false, rsaJWK("old", &oldKey.PublicKey), ecJWK("new", "P-384", &newKey.PublicKey))
+	verifier.keys.fetched = time.Now().Add(-jwksMinRefetch)
+	if _, err := IdentityFromAuthHeader("Bearer " + newToken); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	unknown := sign(t, jwt.SigningMethodRS256, oldKey, "missing", validClaims(nil))
+	IdentityFromAuthHeader("Bearer " + unknown)
+	IdentityFromAuthHeader("Bearer " + unknown)
+	if srv.count() != 2 {
+		t.Errorf("fetches after rotation = %d, want 2", srv.count())
+	}
+
+	// Keys older than the refresh interval are reloaded: the old key is gone
+	srv.set(false, ecJWK("new", "P-384", &newKey.PublicKey))
+	verifier.keys.loaded = time.Now().Add(-2 * time.Hour)
+	verifier.keys.fetched = time.Now().Add(-jwksMinRefetch)
+	if _, err := IdentityFromAuthHeader("Bearer " + oldToken); err == nil {
+		t.Error("retired key still accepted")
+	}
+
+	// While the endpoint fails the last good keys stay in use
+	srv.set(true)
+	verifier.keys.loaded = time.Now().Add(-2 * time.Hour)
+	verifier.keys.fetched = time.Now().Add(-jwksMinRefetch)
+	if _, err := IdentityFromAuthHeader("Bearer " + newToken); err != nil {
+		t.Errorf("cached key during an outage: %v", err)
+	}
+	if srv.count() != 4 {
+		t.Errorf("fetches = %d, want 4", srv.count())
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if pub, err := rsaJWK("r", &rsaKey.PublicKey).publicKey(); err != nil || !rsaKey.PublicKey.Equal(pub) {
+		t.Errorf("RSA: %v", err)
+	}
+
+	for _, c := range []struct {
+		crv   string
+		curve elliptic.Curve
+	}{{"P-256", elliptic.P256()}, {"P-384", elliptic.P384()}, {"P-521", elliptic.P521()}} {
+		// Enough keys that some coordinates start with a zero byte
+		for range 20 {
+			key, _ := ecdsa.GenerateKey(c.curve, rand.Reader)
+			pub, err := ecJWK("e", c.crv, &key.PublicKey).publicKey()
+			if err != nil || !key.PublicKey.Equal(pub) {
+				t.Fatalf("%s: %v", c.crv, err)
+			}
+		}
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	offCurve := ecJWK("e", "P-256", &ecKey.PublicKey)
+	offCurve.Y = b64.EncodeToString([]byte{1})
+	wrongCurve := ecJWK("e", "P-384", &ecKey.PublicKey)
+	for name, k := range map[string]jwk{
+		"symmetric key":    {Kty: "oct", Kid: "s"},
+		"unknown curve":    {Kty: "EC", Crv: "secp256k1", X: "AA", Y: "AA"},
+		"point off curve":  offCurve,
+		"other curve":      wrongCurve,
+		"bad modulus":      {Kty: "RSA", N: "!!", E: "AQAB"},
+		"no exponent":      {Kty: "RSA", N: "AQAB"},
+		"huge exponent":    {Kty: "RSA", N: "AQAB", E: b64.EncodeToString(make([]byte, 5))},
+		"coordinates long": {Kty: "EC", Crv: "P-256", X: b64.EncodeToString(make([]byte, 33)), Y: "AA"},
+	} {
+		if _, err := k.publicKey(); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestKeySetSkipsUnusableKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	enc := rsaJWK("enc", &rsaKey.PublicKey)
+	enc.Use = "enc"
+	srv := newJWKSServer(t, enc, jwk{Kty: "oct", Kid: "hmac"}, rsaJWK("sig", &rsaKey.PublicKey))
+
+	ks := newKeySet(srv.URL, "", time.Hour)
+	if err := ks.load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.keys) != 1 || ks.keys["sig"] == nil {
+		t.Errorf("keys = %v", ks.keys)
+	}
+
+	srv.set(false, enc)
+	if err := ks.load(); err == nil {
+		t.Error("set without signing keys accepted")
+	}
+	if ks.keys["sig"] == nil {
+		t.Error("failed load dropped the cached keys")
+	}
+}
+
+func TestClaimString(t *testing.T) {
+	claims := jwt.MapClaims{
+		"role":         "editor",
+		"roles":        []any{"admin", "viewer"},
+		"empty":        []any{},
+		"count":        3.0,
+		"app_metadata": map[string]any{"role": "owner", "org": map[string]any{"role": "member"}},
+	}
+	for path, want := range map[string]string{
+		"role":                  "editor",
+		"roles":                 "admin",
+		"empty":                 "",
+		"count":                 "",
+		"missing":               "",
+		"app_metadata.role":     "owner",
+		"app_metadata.org.role": "member",
+		"role.nested":           "",
+		"app_metadata.missing":  "",
+	} {
+		if got := claimString(claims, path); got != want {
+			t.Errorf("claimString(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"main/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit counts requests against the per-minute limit of action for the
+// caller's API key or, for browser sessions, their user. It runs after
+// AuthMiddleware, sets the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers, and answers 429 with Retry-After once the limit
+// is reached.
+func RateLimit(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if services.Limits == nil {
+			c.Next()
+			return
+		}
+		account := "user:" + c.GetString("userID")
+		if key := c.GetString("apiKeyID"); key != "" {
+			account = "key:" + key
+		}
+
+		st := services.Limits.Allow(action, account, time.Now())
+		if st.Limit > 0 {
+			reset := strconv.Itoa(int(math.Ceil(st.Reset.Seconds())))
+			c.Header("RateLimit-Limit", strconv.Itoa(st.Limit))
+			c.Header("RateLimit-Remaining", strconv.Itoa(st.Remaining))
+			c.Header("RateLimit-Reset", reset)
+			if !st.Allowed {
+				c.Header("Retry-After", reset)
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many " + action + " requests, try again in " + reset + "s"})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"main/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	corsAllowMethods  = "POST, GET, OPTIONS, PUT, PATCH, HEAD, DELETE"
+	corsAllowHeaders  = "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-Admin-Token, Range, If-Range, If-None-Match, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata"
+	corsExposeHeaders = "Location, ETag, Content-Range, Accept-Ranges, Content-Disposition, Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Expires, Upload-Document-Id"
+)
+
+// originAllowed matches an Origin header against exact origins and wildcard
+// subdomain patterns such as https://*.example.com.
+func originAllowed(allowed []string, origin string) bool {
+	for _, a := range allowed {
+		if strings.EqualFold(a, origin) {
+			return true
+		}
+		scheme, domain, ok := strings.Cut(a, "://*.")
+		if ok && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(strings.ToLower(origin), "."+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS answers preflights and adds the CORS headers for allowed origins.
+// Requests from other origins are served without them, so browsers do not
+// let the calling page read the response; their preflights get 403.
+func CORS(cfg config.SecurityConfig) gin.HandlerFunc {
+	anyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	maxAge := strconv.Itoa(int(cfg.PreflightMaxAge.Seconds()))
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		if !anyOrigin {
+			h.Add("Vary", "Origin")
+		}
+		origin := c.GetHeader("Origin")
+		// Plain OPTIONS requests reach the routes (tus discovery)
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		if origin == "" {
+			c.Next()
+			return
+		}
+		if !anyOrigin && !originAllowed(cfg.AllowedOrigins, origin) {
+			if preflight {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Origin not allowed"})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if preflight {
+			h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			h.Set("Access-Control-Max-Age", maxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		c.Next()
+	}
+}
+
+// UserContentHeaders goes on the routes that serve uploaded files, error
+// answers included. Uploads are not limited to PDFs, so an HTML or SVG file
+// opened from the API origin gets a sandbox without scripts. Framing is left
+// to the allowed origins, whose pages preview documents in an iframe;
+// X-Frame-Options covers browsers without frame-ancestors.
+func UserContentHeaders(cfg config.SecurityConfig) gin.HandlerFunc {
+	ancestors := "'self'"
+	for _, o := range cfg.AllowedOrigins {
+		ancestors += " " + o
+	}
+	csp := "sandbox; default-src 'none'; frame-ancestors " + ancestors
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("Content-Security-Policy", csp)
+		h.Set("X-Frame-Options", "SAMEORIGIN")
+		c.Next()
+	}
+}
+
+// SecurityHeaders sets X-Content-Type-Options and Referrer-Policy on every
+// response, and Strict-Transport-Security on HTTPS requests. Share links
+// carry their token in the URL, hence no referrer.
+func SecurityHeaders(cfg config.SecurityConfig) gin.HandlerFunc {
+	hsts := "max-age=" + strconv.Itoa(int(cfg.HSTSMaxAge.Seconds())) + "; includeSubDomains"
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "no-referrer")
+		if cfg.HSTSMaxAge > 0 && (c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https") {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		c.Next()
+	}
+}
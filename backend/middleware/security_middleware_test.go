@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"main/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://app.example.com", "https://*.example.org"}
+	for _, c := range []struct {
+		origin string
+		ok     bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"https://docs.example.org", true},
+		{"https://a.b.example.org", true},
+
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://other.example.com", false},
+		{"https://example.org", false}, // the wildcard needs a subdomain
+		{"http://docs.example.org", false},
+		{"https://evilexample.org", false},
+		{"https://docs.example.org.evil.com", false},
+		{"null", false},
+	} {
+		if got := originAllowed(allowed, c.origin); got != c.ok {
+			t.Errorf("%s: allowed = %v", c.origin, got)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, c := range []struct {
+		name        string
+		cfg         config.SecurityConfig
+		method      string
+		origin      string
+		status      int
+		allowOrigin string
+		credentials bool
+		vary        bool
+	}{
+		{"allowed request", config.SecurityConfig{AllowedOrigins: []string{"https://app.example.com"}}, http.MethodGet, "https://app.example.com", http.StatusOK, "https://app.example.com", false, true},
+		{"allowed preflight", config.SecurityConfig{AllowedOrigins: []string{"https://app.example.com"}}, http.MethodOptions, "https://app.example.com", http.StatusNoContent, "https://app.example.com", false, true},
+		{"wildcard subdomain", config.SecurityConfig{AllowedOrigins: []string{"https://*.example.com"}}, http.MethodGet, "https://docs.example.com", http.StatusOK, "https://docs.example.com", false, true},
+		{"credentials", config.SecurityConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true}, http.MethodGet, "https://app.example.com", http.StatusOK, "https://app.example.com", true, true},
+		{"any origin", config.SecurityConfig{AllowedOrigins: []string{"*"}}, http.MethodGet, "https://anywhere.test", http.StatusOK, "*", false, false},
+
+		// Other origins are served without the headers; their preflights are refused
+		{"rejected request", config.SecurityConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true}, http.MethodGet, "https://evil.test", http.StatusOK, "", false, true},
+		{"rejected preflight", config.SecurityConfig{AllowedOrigins: []string{"https://*.example.com"}}, http.MethodOptions, "https://example.com.evil.test", http.StatusForbidden, "", false, true},
+		{"no origin", config.SecurityConfig{AllowedOrigins: []string{"https://app.example.com"}}, http.MethodGet, "", http.StatusOK, "", false, true},
+	} {
+		r := gin.New()
+		r.Use(CORS(c.cfg))
+		r.GET("/documents", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		req := httptest.NewRequest(c.method, "/documents", nil)
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		if c.method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		h := w.Header()
+		if w.Code != c.status {
+			t.Errorf("%s: status = %d", c.name, w.Code)
+		}
+		if got := h.Get("Access-Control-Allow-Origin"); got != c.allowOrigin {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q", c.name, got)
+		}
+		if got := h.Get("Access-Control-Allow-Credentials") == "true"; got != c.credentials {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q", c.name, h.Get("Access-Control-Allow-Credentials"))
+		}
+		// Caches must not hand one origin's answer to another
+		if got := h.Get("Vary") == "Origin"; got != c.vary {
+			t.Errorf("%s: Vary = %q", c.name, h.Get("Vary"))
+		}
+		if c.status == http.StatusNoContent && (h.Get("Access-Control-Allow-Methods") == "" || h.Get("Access-Control-Max-Age") == "") {
+			t.Errorf("%s: preflight headers %v", c.name, h)
+		}
+	}
+}
+
+func TestCORSMaxAge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CORS(config.SecurityConfig{AllowedOrigins: []string{"https://app.example.com"}, PreflightMaxAge: 10 * time.Minute}))
+	req := httptest.NewRequest(http.MethodOptions, "/documents", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("Access-Control-Max-Age = %q", got)
+	}
+}
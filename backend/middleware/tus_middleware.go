@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TusVersion is the only tus protocol version the upload endpoints speak.
+const TusVersion = "1.0.0"
+
+// TusMiddleware adds the Tus-Resumable header to every response and rejects
+// protocol requests from clients speaking another version. OPTIONS (discovery)
+// and GET (upload result) do not require the header.
+func TusMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", TusVersion)
+
+		method := c.Request.Method
+		if method != http.MethodOptions && method != http.MethodGet && c.GetHeader("Tus-Resumable") != TusVersion {
+			c.Header("Tus-Version", TusVersion)
+			c.AbortWithStatus(http.StatusPreconditionFailed)
+			return
+		}
+
+		c.Next()
+	}
+}
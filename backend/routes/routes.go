@@ -1,18 +1,126 @@
 package routes
 
 import (
+	"main/config"
 	"main/controllers"
+	"main/middleware"
+	"main/services"
 
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterRoutes(r gin.IRouter, dc *controllers.DocumentController) {
-	r.POST("/upload", dc.UploadHandler)
-	r.GET("/preview/*filename", controllers.PreviewFile)
-	r.GET("/documents", dc.ListDocuments)
-	r.GET("/documents/:id/preview", dc.GetPreviewURL)
-	r.POST("/documents/:id/chat", dc.ChatHandler)
-	r.POST("/documents/:id/regenerate-summary", dc.RegenerateSummaryHandler)
-	r.GET("/stats", dc.GetStats)
-	r.DELETE("/documents/:id", dc.DeleteHandler)
+// RegisterRoutes puts every document route behind AuthMiddleware, except
+// share links, signed content URLs (checked by the handlers) and, when
+// enabled, anonymous proof verification. API keys are further limited to
+// the routes of their scopes.
+func RegisterRoutes(r gin.IRouter, dc *controllers.DocumentController, authCfg config.AuthConfig, securityCfg config.SecurityConfig) {
+	// Uploaded files are served sandboxed, see UserContentHeaders
+	content := r.Group("", middleware.UserContentHeaders(securityCfg))
+
+	// Public share links
+	content.GET("/s/:token", dc.ShareDownloadHandler)
+	content.HEAD("/s/:token", dc.ShareDownloadHandler)
+	content.GET("/s/:token/info", dc.ShareInfoHandler)
+	content.POST("/s/:token/access", dc.ShareAccessHandler)
+
+	// Signed URL or the owner's credentials, so they work in <img> and <iframe>
+	content.GET("/preview/*filename", dc.PreviewFile)
+	content.GET("/documents/:id/content", dc.ContentHandler)
+
+	auth := r.Group("", middleware.AuthMiddleware())
+	upload := auth.Group("", middleware.RequireScope(services.ScopeUpload))
+	read := auth.Group("", middleware.RequireScope(services.ScopeRead))
+	manage := auth.Group("", middleware.RequireScope(services.ScopeAdmin))
+
+	if authCfg.AnonymousVerify {
+		r.GET("/documents/:id/proof", dc.ProofHandler)
+	} else {
+		auth.GET("/documents/:id/proof", middleware.RequireScope(services.ScopeVerify), dc.ProofHandler)
+	}
+
+	upload.POST("/upload", middleware.RateLimit(services.LimitUpload), dc.UploadHandler)
+	upload.POST("/uploads/init", middleware.RateLimit(services.LimitUpload), dc.InitUploadHandler)
+	upload.GET("/uploads/:id", dc.UploadStatusHandler)
+	upload.POST("/uploads/:id/complete", dc.CompleteUploadHandler)
+	upload.DELETE("/uploads/:id", dc.AbortUploadHandler)
+	upload.POST("/documents/:id/versions", middleware.RateLimit(services.LimitUpload), dc.UploadVersionHandler)
+
+	// Resumable uploads (tus 1.0.0); discovery stays public
+	tus := r.Group("/files", middleware.TusMiddleware())
+	tus.OPTIONS("", dc.TusOptionsHandler)
+	tusAuth := tus.Group("", middleware.AuthMiddleware(), middleware.RequireScope(services.ScopeUpload))
+	tusAuth.POST("", middleware.RateLimit(services.LimitUpload), dc.TusCreateHandler)
+	tusAuth.HEAD("/:id", dc.TusHeadHandler)
+	tusAuth.PATCH("/:id", dc.TusPatchHandler)
+	tusAuth.GET("/:id", dc.TusResultHandler)
+	tusAuth.DELETE("/:id", dc.TusDeleteHandler)
+
+	read.GET("/documents", dc.ListDocuments)
+	read.GET("/documents/:id/preview", dc.GetPreviewURL)
+	read.GET("/documents/:id/versions", dc.ListVersionsHandler)
+	read.GET("/documents/:id/versions/diff", dc.DiffVersionsHandler)
+	read.POST("/documents/:id/chat", middleware.RateLimit(services.LimitChat), dc.ChatHandler)
+	read.GET("/stats", dc.GetStats)
+	read.GET("/trash", dc.TrashHandler)
+	read.GET("/audit", dc.AuditHandler)
+	read.GET("/usage", dc.UsageHandler)
+	read.GET("/documents/:id/holds", dc.ListHoldsHandler)
+	read.GET("/documents/:id/shares", dc.ListSharesHandler)
+	read.GET("/documents/:id/acl", dc.ListACLHandler)
+
+	manage.POST("/documents/:id/regenerate-summary", middleware.RateLimit(services.LimitRegenerate), dc.RegenerateSummaryHandler)
+	manage.POST("/documents/:id/shares", dc.CreateShareHandler)
+	manage.DELETE("/documents/:id/shares/:shareId", dc.RevokeShareHandler)
+	manage.POST("/documents/:id/acl", dc.GrantHandler)
+	manage.DELETE("/documents/:id/acl/:grantId", dc.RevokeGrantHandler)
+	manage.DELETE("/documents/:id", dc.DeleteHandler)
+	manage.POST("/documents/:id/restore", dc.RestoreHandler)
+	manage.POST("/documents/:id/holds", dc.PlaceHoldHandler)
+	manage.DELETE("/documents/:id/holds/:holdId", dc.ReleaseHoldHandler)
+	manage.PUT("/documents/:id/retention", dc.SetRetentionHandler)
+	manage.DELETE("/trash/:id", dc.PurgeHandler)
+
+	// Organizations; member management is checked against roles
+	read.GET("/orgs", dc.ListOrgsHandler)
+	read.GET("/orgs/:orgId", dc.GetOrgHandler)
+	manage.POST("/orgs", dc.CreateOrgHandler)
+	manage.PUT("/orgs/:orgId/members/:userId", dc.SetMemberHandler)
+	manage.DELETE("/orgs/:orgId/members/:userId", dc.RemoveMemberHandler)
+
+	// API keys for service-to-service access
+	manage.POST("/api-keys", dc.CreateAPIKeyHandler)
+	manage.GET("/api-keys", dc.ListAPIKeysHandler)
+	manage.DELETE("/api-keys/:id", dc.RevokeAPIKeyHandler)
+}
+
+// RegisterAdminRoutes puts the contract, integrity and audit endpoints, and
+// hold and retention changes for callers without the compliance role,
+// behind AdminMiddleware.
+func RegisterAdminRoutes(r gin.IRouter, ac *controllers.AdminController, dc *controllers.DocumentController, adminToken string) {
+	admin := r.Group("/admin", middleware.AdminMiddleware(adminToken))
+	admin.GET("/contract", ac.ContractStats)
+	admin.GET("/networks", ac.ListNetworks)
+	admin.GET("/outbox", ac.ListOutbox)
+	admin.GET("/integrity", ac.IntegrityReport)
+	admin.GET("/audit", ac.AuditLog)
+	admin.GET("/audit/verify", ac.VerifyAuditLog)
+	admin.POST("/audit/anchor", ac.AnchorAuditLog)
+	admin.POST("/integrity/run", ac.RunScrub)
+	admin.POST("/integrity/documents/:id", ac.ScrubDocument)
+	admin.GET("/owners", ac.ListOwners)
+	admin.GET("/owners/:address", ac.IsOwner)
+	admin.POST("/owners", ac.AddOwner)
+	admin.POST("/documents/:id/holds", dc.PlaceHoldHandler)
+	admin.DELETE("/documents/:id/holds/:holdId", dc.ReleaseHoldHandler)
+	admin.PUT("/documents/:id/retention", dc.SetRetentionHandler)
+}
+
+// RegisterUI serves the bundled page in public/ at / with its Content
+// Security Policy.
+func RegisterUI(r gin.IRouter, csp string) {
+	r.GET("/", func(c *gin.Context) {
+		c.Header("Content-Security-Policy", csp)
+		c.Header("X-Frame-Options", "DENY")
+		c.File("public/index.html")
+	})
 }
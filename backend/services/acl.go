@@ -0,0 +1,131 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+var (
+	ErrGrantNotFound = errors.New("grant not found")
+	ErrACLSubject    = errors.New("grant needs a user or group")
+)
+
+// ACL subject types. A group is an organization: the grant applies to all of
+// its current members, whatever their role.
+const (
+	SubjectUser  = "user"
+	SubjectGroup = "group"
+)
+
+// ACLEntry gives one user or group access to a single document. Level is a
+// role name; its permissions apply to this document only, and PermManage is
+// never granted, so only owners can change the ACL.
+type ACLEntry struct {
+	ID        string    `json:"id"`
+	Subject   string    `json:"subject"` // SubjectUser or SubjectGroup
+	SubjectID string    `json:"subjectId"`
+	Level     string    `json:"level"` // RoleViewer, RoleAuditor or RoleEditor
+	GrantedBy string    `json:"grantedBy"`
+	GrantedAt time.Time `json:"grantedAt"`
+}
+
+// ACLLevels are the levels a grant can have.
+var ACLLevels = []string{RoleViewer, RoleAuditor, RoleEditor}
+
+// matches reports whether the entry applies to userID.
+func (e ACLEntry) matches(userID string) bool {
+	switch e.Subject {
+	case SubjectUser:
+		return e.SubjectID == userID
+	case SubjectGroup:
+		return Orgs != nil && Orgs.Role(e.SubjectID, userID) != ""
+	}
+	return false
+}
+
+// aclAllows reports whether any entry of the document's ACL gives userID perm.
+func aclAllows(meta DocumentMetadata, userID string, perm Permission) bool {
+	for _, e := range meta.ACL {
+		if perm != PermManage && RoleAllows(e.Level, perm) && e.matches(userID) {
+			return true
+		}
+	}
+	return false
+}
+
+// SharedWith reports whether userID reads the document through its ACL only,
+// not as its owner or a member of its organization.
+func (m DocumentMetadata) SharedWith(userID string) bool {
+	if m.OrgID == "" && m.UserID == userID {
+		return false
+	}
+	if m.OrgID != "" && Orgs != nil && Orgs.Role(m.OrgID, userID) != "" {
+		return false
+	}
+	return aclAllows(m, userID, PermRead)
+}
+
+// Grant adds an entry to a document's ACL, or changes the level of the
+// existing entry for the same subject.
+func (s *MetadataStore) Grant(id string, entry ACLEntry) (ACLEntry, error) {
+	if entry.SubjectID == "" || (entry.Subject != SubjectUser && entry.Subject != SubjectGroup) {
+		return ACLEntry{}, ErrACLSubject
+	}
+	if !slices.Contains(ACLLevels, entry.Level) {
+		return ACLEntry{}, ErrUnknownRole
+	}
+	if entry.Subject == SubjectGroup {
+		if Orgs == nil {
+			return ACLEntry{}, ErrOrgNotFound
+		}
+		if _, ok := Orgs.Get(entry.SubjectID); !ok {
+			return ACLEntry{}, ErrOrgNotFound
+		}
+	}
+
+	s.mu.Lock()
+	meta, ok := s.Data[id]
+	if !ok || meta.PurgedAt != nil {
+		s.mu.Unlock()
+		return ACLEntry{}, ErrDocumentMissing
+	}
+	acl := slices.Clone(meta.ACL)
+	i := slices.IndexFunc(acl, func(e ACLEntry) bool {
+		return e.Subject == entry.Subject && e.SubjectID == entry.SubjectID
+	})
+	if i >= 0 {
+		acl[i].Level = entry.Level
+		acl[i].GrantedBy = entry.GrantedBy
+		acl[i].GrantedAt = time.Now()
+		entry = acl[i]
+	} else {
+		entry.ID = NewRandomID()
+		entry.GrantedAt = time.Now()
+		acl = append(acl, entry)
+	}
+	meta.ACL = acl
+	s.Data[id] = meta
+	s.mu.Unlock()
+	return entry, s.Save()
+}
+
+// RevokeGrant removes an entry from a document's ACL.
+func (s *MetadataStore) RevokeGrant(id, grantID string) (ACLEntry, error) {
+	s.mu.Lock()
+	meta, ok := s.Data[id]
+	if !ok {
+		s.mu.Unlock()
+		return ACLEntry{}, ErrDocumentMissing
+	}
+	i := slices.IndexFunc(meta.ACL, func(e ACLEntry) bool { return e.ID == grantID })
+	if i < 0 {
+		s.mu.Unlock()
+		return ACLEntry{}, ErrGrantNotFound
+	}
+	revoked := meta.ACL[i]
+	meta.ACL = slices.Delete(slices.Clone(meta.ACL), i, i+1)
+	s.Data[id] = meta
+	s.mu.Unlock()
+	return revoked, s.Save()
+}
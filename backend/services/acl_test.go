@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestACLGrantsAccessToOneDocument(t *testing.T) {
+	saved := Orgs
+	t.Cleanup(func() { Orgs = saved })
+	Orgs = &OrgStore{FilePath: filepath.Join(t.TempDir(), "orgs.json"), Data: map[string]Organization{}}
+	team, _ := Orgs.Create("Finance", "dave")
+	Orgs.SetMember(team.ID, "erin", RoleViewer, "dave")
+
+	s := &MetadataStore{FilePath: filepath.Join(t.TempDir(), "metadata.json"), Data: map[string]DocumentMetadata{
+		"1": {ID: "1", UserID: "alice"},
+		"2": {ID: "2", UserID: "alice"},
+	}}
+
+	bob, err := s.Grant("1", ACLEntry{Subject: SubjectUser, SubjectID: "bob", Level: RoleViewer, GrantedBy: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Grant("1", ACLEntry{Subject: SubjectGroup, SubjectID: team.ID, Level: RoleEditor, GrantedBy: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, _ := s.Get("1")
+	if !Can(doc, "bob", PermRead) || Can(doc, "bob", PermDelete) || Can(s.Data["2"], "bob", PermRead) {
+		t.Error("user grant should allow reading this document only")
+	}
+	if !Can(doc, "erin", PermDelete) || Can(doc, "erin", PermManage) {
+		t.Error("group grant should give members its level, without managing the ACL")
+	}
+	if docs := s.GetAllForUser("bob"); len(docs) != 1 || !docs[0].SharedWith("bob") || docs[0].SharedWith("alice") {
+		t.Errorf("GetAllForUser(bob) = %+v", docs)
+	}
+
+	// Saving a stale copy keeps the grants
+	s.AddOrUpdate(DocumentMetadata{ID: "1", UserID: "alice", Name: "renamed"})
+	if doc, _ = s.Get("1"); len(doc.ACL) != 2 {
+		t.Errorf("ACL lost on update: %+v", doc.ACL)
+	}
+
+	// Granting the same subject again changes the level
+	if again, _ := s.Grant("1", ACLEntry{Subject: SubjectUser, SubjectID: "bob", Level: RoleEditor}); again.ID != bob.ID {
+		t.Error("regrant created a second entry")
+	}
+	if _, err := s.RevokeGrant("1", bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if doc, _ = s.Get("1"); Can(doc, "bob", PermRead) {
+		t.Error("revoked grant still allows access")
+	}
+
+	if _, err := s.Grant("1", ACLEntry{Subject: SubjectUser, SubjectID: "bob", Level: RoleOwner}); !errors.Is(err, ErrUnknownRole) {
+		t.Errorf("owner level: %v", err)
+	}
+	if _, err := s.Grant("1", ACLEntry{Subject: SubjectGroup, SubjectID: "nope", Level: RoleViewer}); !errors.Is(err, ErrOrgNotFound) {
+		t.Errorf("unknown group: %v", err)
+	}
+	if _, err := s.RevokeGrant("1", bob.ID); !errors.Is(err, ErrGrantNotFound) {
+		t.Errorf("revoking twice: %v", err)
+	}
+}
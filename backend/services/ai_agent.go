@@ -3,43 +3,181 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"log"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/openai"
 )
 
-var (
-	// Rate limiter: 1 request every 4 seconds (15 RPM)
-	// Using a buffered channel as a simple token bucket
-	rateLimitToken = make(chan struct{}, 1)
+// openAIKey is set by InitAI.
+var openAIKey string
+
+// InitAI sets the OpenAI API key used for document analysis and chat.
+func InitAI(apiKey string) {
+	openAIKey = apiKey
+}
+
+const (
+	// llmInterval spaces model calls: one every 4 seconds (15 RPM).
+	llmInterval = 4 * time.Second
+	// llmMaxTokens caps each answer, so a call's reservation is an upper
+	// bound of what it spends.
+	llmMaxTokens = 2048
 )
 
-func init() {
-	// Fill the token bucket initially
-	rateLimitToken <- struct{}{}
+// Model call priorities: a chat answer has someone waiting on the page,
+// upload analysis can wait.
+const (
+	priorityChat = iota
+	priorityAnalysis
+	priorities
+)
+
+// llmGate lets one model call through every llmInterval. Waiting calls are
+// served chat first and, within a priority, taking turns between users, so
+// one account's batch of uploads cannot hold up everyone else.
+type llmGate struct {
+	mu     sync.Mutex
+	ready  bool // a slot is free and nobody is waiting
+	queues [priorities]map[string][]chan struct{}
+	turns  [priorities][]string // users with waiting calls, next first
+}
+
+var gate = newLLMGate()
+
+func newLLMGate() *llmGate {
+	g := &llmGate{ready: true}
+	for p := range g.queues {
+		g.queues[p] = make(map[string][]chan struct{})
+	}
+	return g
+}
 
-	// Start a goroutine to refill the token every 4 seconds
-	go func() {
-		ticker := time.NewTicker(4 * time.Second)
-		for range ticker.C {
+func init() {
+	// Hand out a slot every interval until shutdown
+	track(func() {
+		ticker := time.NewTicker(llmInterval)
+		defer ticker.Stop()
+		for {
 			select {
-			case rateLimitToken <- struct{}{}:
-				// Token added
-			default:
-				// Bucket full, wait for next tick
+			case <-background.Done():
+				return
+			case <-ticker.C:
 			}
+			gate.release()
 		}
-	}()
+	})
+}
+
+// wait blocks until a call of userID may run, or returns ctx's error.
+func (g *llmGate) wait(ctx context.Context, priority int, userID string) error {
+	g.mu.Lock()
+	if g.ready {
+		g.ready = false
+		g.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	if len(g.queues[priority][userID]) == 0 {
+		g.turns[priority] = append(g.turns[priority], userID)
+	}
+	g.queues[priority][userID] = append(g.queues[priority][userID], ch)
+	g.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.dropLocked(priority, userID, ch) {
+		// The slot was handed over meanwhile; pass it on
+		g.releaseLocked()
+	}
+	return ctx.Err()
+}
+
+// dropLocked removes a waiting call, reporting false if it was served.
+func (g *llmGate) dropLocked(priority int, userID string, ch chan struct{}) bool {
+	queue := g.queues[priority][userID]
+	i := slices.Index(queue, ch)
+	if i < 0 {
+		return false
+	}
+	if len(queue) == 1 {
+		delete(g.queues[priority], userID)
+		g.turns[priority] = slices.DeleteFunc(g.turns[priority], func(u string) bool { return u == userID })
+	} else {
+		g.queues[priority][userID] = slices.Delete(queue, i, i+1)
+	}
+	return true
 }
 
-func waitForRateLimit() {
-	// Wait for a token
-	<-rateLimitToken
+func (g *llmGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.releaseLocked()
+}
+
+// releaseLocked serves the oldest call of the next user, who then goes to
+// the back of the line, or frees the slot when nobody waits.
+func (g *llmGate) releaseLocked() {
+	for p, turns := range g.turns {
+		if len(turns) == 0 {
+			continue
+		}
+		user := turns[0]
+		queue := g.queues[p][user]
+		if len(queue) == 1 {
+			delete(g.queues[p], user)
+			g.turns[p] = turns[1:]
+		} else {
+			g.queues[p][user] = queue[1:]
+			g.turns[p] = append(turns[1:], user)
+		}
+		close(queue[0])
+		return
+	}
+	g.ready = true
+}
+
+// generate runs a prompt on behalf of userID: it reserves the tokens the
+// call may spend from their monthly quota, waits for its turn and records
+// the tokens actually used.
+func generate(ctx context.Context, llm llms.Model, priority int, userID, prompt string) (string, error) {
+	// Roughly 3 bytes per token in Spanish text, rounded up
+	done, err := ReserveTokens(userID, int64(len(prompt))/3+llmMaxTokens)
+	if err != nil {
+		return "", err
+	}
+	var used int64
+	defer func() {
+		if err := done(used); err != nil {
+			log.Println("Warning: could not save token usage:", err)
+		}
+	}()
+	if err := gate.wait(ctx, priority, userID); err != nil {
+		return "", err
+	}
+
+	resp, err := llm.GenerateContent(ctx, []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)},
+		llms.WithMaxTokens(llmMaxTokens))
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("empty response from model")
+	}
+	tokens, _ := resp.Choices[0].GenerationInfo["TotalTokens"].(int)
+	used = int64(tokens)
+	return resp.Choices[0].Content, nil
 }
 
 // DocumentAnalysis represents the complete AI analysis of a document
@@ -51,13 +189,9 @@ type DocumentAnalysis struct {
 	DocumentType string `json:"document_type"`
 }
 
-// AnalyzeDocument performs comprehensive AI analysis of a document
-func AnalyzeDocument(documentContent string) (*DocumentAnalysis, error) {
-	waitForRateLimit()
-	godotenv.Load()
-	ctx := context.Background()
-
-	llm, err := openai.New(openai.WithToken(os.Getenv("OPENAI_API_KEY")))
+// AnalyzeDocument performs comprehensive AI analysis of a document for userID
+func AnalyzeDocument(ctx context.Context, userID, documentContent string) (*DocumentAnalysis, error) {
+	llm, err := openai.New(openai.WithToken(openAIKey))
 	if err != nil {
 		return nil, err
 	}
@@ -78,7 +212,7 @@ Por favor, proporciona tu análisis en formato JSON con la siguiente estructura:
 
 IMPORTANTE: Responde SOLO con el JSON, sin texto adicional antes o después.`, documentContent)
 
-	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt)
+	completion, err := generate(ctx, llm, priorityAnalysis, userID, prompt)
 	if err != nil {
 		return nil, err
 	}
@@ -107,13 +241,9 @@ IMPORTANTE: Responde SOLO con el JSON, sin texto adicional antes o después.`, d
 	return &analysis, nil
 }
 
-// ChatWithDocument uses LangChain to answer questions about a document
-func ChatWithDocument(documentContent string, question string) (string, error) {
-	waitForRateLimit()
-	godotenv.Load()
-	ctx := context.Background()
-
-	llm, err := openai.New(openai.WithToken(os.Getenv("OPENAI_API_KEY")))
+// ChatWithDocument uses LangChain to answer questions about a document for userID
+func ChatWithDocument(ctx context.Context, userID, documentContent string, question string) (string, error) {
+	llm, err := openai.New(openai.WithToken(openAIKey))
 	if err != nil {
 		return "", fmt.Errorf("failed to initialize AI: %w", err)
 	}
@@ -130,8 +260,11 @@ Por favor, proporciona una respuesta útil y precisa basada en el contenido del
 
 RESPUESTA:`, documentContent, question)
 
-	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt)
+	completion, err := generate(ctx, llm, priorityChat, userID, prompt)
 	if err != nil {
+		if errors.Is(err, ErrTokenQuota) || ctx.Err() != nil {
+			return "", err
+		}
 		// Check if it's a rate limit error
 		if strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "quota") {
 			return "", fmt.Errorf("API rate limit exceeded. Please wait a moment and try again")
@@ -142,14 +275,10 @@ RESPUESTA:`, documentContent, question)
 	return completion, nil
 }
 
-// RegenerateSummary generates a new summary for a document
-func RegenerateSummary(documentContent string) (string, error) {
-	waitForRateLimit()
-	godotenv.Load()
-	ctx := context.Background()
-
+// RegenerateSummary generates a new summary for a document for userID
+func RegenerateSummary(ctx context.Context, userID, documentContent string) (string, error) {
 	llm, err := openai.New(
-		openai.WithToken(os.Getenv("OPENAI_API_KEY")),
+		openai.WithToken(openAIKey),
 	)
 	if err != nil {
 		return "", err
@@ -164,7 +293,7 @@ DOCUMENTO:
 
 RESUMEN:`, documentContent)
 
-	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt)
+	completion, err := generate(ctx, llm, priorityChat, userID, prompt)
 	if err != nil {
 		return "", err
 	}
@@ -172,16 +301,15 @@ RESUMEN:`, documentContent)
 	return completion, nil
 }
 
-// GenerateCompletion is a legacy function for backward compatibility
-func GenerateCompletion(data string) (string, error) {
-	godotenv.Load()
-	ctx := context.Background()
-	llm, err := openai.New(openai.WithToken(os.Getenv("OPENAI_API_KEY")))
+// GenerateCompletion writes a short summary when AnalyzeDocument fails. It
+// goes through the same gate and token quota as the analysis.
+func GenerateCompletion(ctx context.Context, userID, data string) (string, error) {
+	llm, err := openai.New(openai.WithToken(openAIKey))
 	if err != nil {
 		return "", err
 	}
 	prompt := "Resume el siguiente texto en español, máximo 200 caracteres:\n" + data
-	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt)
+	completion, err := generate(ctx, llm, priorityAnalysis, userID, prompt)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// queued waits until the gate holds n waiting calls.
+func queued(t *testing.T, g *llmGate, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		g.mu.Lock()
+		total := 0
+		for _, queues := range g.queues {
+			for _, q := range queues {
+				total += len(q)
+			}
+		}
+		g.mu.Unlock()
+		if total == n {
+			return
+		}
+	}
+	t.Fatalf("gate never held %d waiting calls", n)
+}
+
+func TestLLMGateOrder(t *testing.T) {
+	g := newLLMGate()
+	if err := g.wait(context.Background(), priorityAnalysis, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice queues three uploads before bob and carol ask anything
+	served := make(chan string, 5)
+	calls := []struct {
+		priority int
+		user     string
+	}{
+		{priorityAnalysis, "alice"},
+		{priorityAnalysis, "alice"},
+		{priorityAnalysis, "alice"},
+		{priorityAnalysis, "bob"},
+		{priorityChat, "carol"},
+	}
+	for i, call := range calls {
+		go func() {
+			if err := g.wait(context.Background(), call.priority, call.user); err == nil {
+				served <- call.user
+			}
+		}()
+		queued(t, g, i+1)
+	}
+
+	var order []string
+	for range calls {
+		g.release()
+		order = append(order, <-served)
+	}
+	want := []string{"carol", "alice", "bob", "alice", "alice"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("served %v, want %v", order, want)
+		}
+	}
+
+	g.release()
+	if !g.ready {
+		t.Error("slot not freed with nobody waiting")
+	}
+}
+
+func TestLLMGateCancel(t *testing.T) {
+	g := newLLMGate()
+	g.wait(context.Background(), priorityChat, "alice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- g.wait(ctx, priorityChat, "alice") }()
+	queued(t, g, 1)
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled wait = %v", err)
+	}
+
+	// The cancelled call takes no slot
+	queued(t, g, 0)
+	g.release()
+	if err := g.wait(context.Background(), priorityChat, "bob"); err != nil || len(g.turns[priorityChat]) != 0 {
+		t.Errorf("gate after cancel: %v, turns %v", err, g.turns)
+	}
+}
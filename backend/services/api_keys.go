@@ -0,0 +1,263 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API key scopes. Browser sessions (JWTs) have all of them.
+const (
+	ScopeUpload = "upload" // POST /upload, direct and tus uploads, new versions
+	ScopeRead   = "read"   // list, preview, download, versions, chat
+	ScopeVerify = "verify" // proofs
+	ScopeAdmin  = "admin"  // delete, restore, purge, holds, retention, shares, API keys
+)
+
+var AllScopes = []string{ScopeUpload, ScopeRead, ScopeVerify, ScopeAdmin}
+
+// apiKeyPrefix marks API keys so the auth middleware can tell them from JWTs.
+const apiKeyPrefix = "cdk_"
+
+var (
+	ErrAPIKeyInvalid     = errors.New("invalid API key")
+	ErrAPIKeyNotFound    = errors.New("API key not found")
+	ErrAPIKeyRateLimited = errors.New("API key rate limit exceeded")
+	ErrAPIKeyScope       = errors.New("invalid API key scopes")
+)
+
+// APIKey lets a service act on behalf of the user who created it, limited to
+// its scopes. Only a SHA-256 of the key is stored; the key itself is shown
+// once, when it is created.
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"userId"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"` // last characters, to tell keys apart
+	Hash       string     `json:"hash,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  int        `json:"rateLimit,omitempty"` // requests per minute, 0 for the default
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Active reports whether the key can still be used.
+func (k APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+func (k APIKey) HasScope(scope string) bool { return slices.Contains(k.Scopes, scope) }
+
+// MarshalJSON hides the key hash from API responses.
+func (k APIKey) MarshalJSON() ([]byte, error) {
+	type apiKey APIKey
+	out := struct {
+		apiKey
+		Hash string `json:"hash,omitempty"`
+	}{apiKey: apiKey(k)}
+	return json.Marshal(out)
+}
+
+// IsAPIKey reports whether a credential looks like an API key rather than a
+// JWT.
+func IsAPIKey(token string) bool { return strings.HasPrefix(token, apiKeyPrefix) }
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// rateWindow counts the requests of one key in the current minute.
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// APIKeyStore persists API keys to a JSON file, like ShareStore. Rate limit
+// counters are kept in memory only.
+type APIKeyStore struct {
+	FilePath     string
+	Data         map[string]APIKey
+	DefaultLimit int // requests per minute for keys without their own limit
+	mu           sync.RWMutex
+
+	windowsMu sync.Mutex
+	windows   map[string]*rateWindow
+}
+
+var APIKeys *APIKeyStore
+
+func InitAPIKeys(filePath string, defaultLimit int) error {
+	APIKeys = &APIKeyStore{
+		FilePath:     filePath,
+		Data:         make(map[string]APIKey),
+		DefaultLimit: defaultLimit,
+	}
+	return APIKeys.Load()
+}
+
+func (s *APIKeyStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.ReadFile(s.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(file, &s.Data)
+}
+
+func (s *APIKeyStore) Save() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Keys cannot be marshalled through APIKey.MarshalJSON here: it drops
+	// the hash, which the file must keep.
+	type stored APIKey
+	data := make(map[string]stored, len(s.Data))
+	for id, key := range s.Data {
+		data[id] = stored(key)
+	}
+	out, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.FilePath, out, 0600)
+}
+
+// Create issues a key for key.UserID and returns it with the secret, which
+// is not stored and cannot be shown again.
+func (s *APIKeyStore) Create(key APIKey) (APIKey, string, error) {
+	for _, scope := range key.Scopes {
+		if !slices.Contains(AllScopes, scope) {
+			return APIKey{}, "", fmt.Errorf("%w: unknown scope %q", ErrAPIKeyScope, scope)
+		}
+	}
+	if len(key.Scopes) == 0 {
+		return APIKey{}, "", fmt.Errorf("%w: at least one is required", ErrAPIKeyScope)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return APIKey{}, "", err
+	}
+	key.ID = NewRandomID()
+	secret := apiKeyPrefix + key.ID + "_" + base64.RawURLEncoding.EncodeToString(b)
+	key.Hash = hashAPIKey(secret)
+	key.Hint = secret[len(secret)-4:]
+	key.CreatedAt = time.Now()
+	key.LastUsedAt = nil
+	key.RevokedAt = nil
+
+	s.mu.Lock()
+	s.Data[key.ID] = key
+	s.mu.Unlock()
+	return key, secret, s.Save()
+}
+
+// ListForUser returns the keys of userID, newest first.
+func (s *APIKeyStore) ListForUser(userID string) []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []APIKey{}
+	for _, key := range s.Data {
+		if key.UserID == userID {
+			list = append(list, key)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// Revoke disables a key of userID.
+func (s *APIKeyStore) Revoke(userID, id string) (APIKey, error) {
+	s.mu.Lock()
+	key, ok := s.Data[id]
+	if !ok || key.UserID != userID {
+		s.mu.Unlock()
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		s.Data[id] = key
+	}
+	s.mu.Unlock()
+	return key, s.Save()
+}
+
+// Authenticate checks a presented key and counts the request against the
+// key's rate limit. Last use is saved at most once a minute per key.
+func (s *APIKeyStore) Authenticate(secret string) (APIKey, error) {
+	rest, ok := strings.CutPrefix(secret, apiKeyPrefix)
+	id, _, ok2 := strings.Cut(rest, "_")
+	if !ok || !ok2 {
+		return APIKey{}, ErrAPIKeyInvalid
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	key, found := s.Data[id]
+	if !found || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashAPIKey(secret))) != 1 || !key.Active(now) {
+		s.mu.Unlock()
+		return APIKey{}, ErrAPIKeyInvalid
+	}
+	save := key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > time.Minute
+	if save {
+		key.LastUsedAt = &now
+		s.Data[id] = key
+	}
+	s.mu.Unlock()
+	if save {
+		s.Save()
+	}
+
+	if !s.allow(key, now) {
+		return key, ErrAPIKeyRateLimited
+	}
+	return key, nil
+}
+
+// allow applies the per-minute limit of a key.
+func (s *APIKeyStore) allow(key APIKey, now time.Time) bool {
+	limit := key.RateLimit
+	if limit == 0 {
+		limit = s.DefaultLimit
+	}
+	if limit <= 0 {
+		return true
+	}
+
+	s.windowsMu.Lock()
+	defer s.windowsMu.Unlock()
+	if s.windows == nil {
+		s.windows = make(map[string]*rateWindow)
+	}
+	w, ok := s.windows[key.ID]
+	if !ok || now.Sub(w.start) >= time.Minute {
+		w = &rateWindow{start: now}
+		s.windows[key.ID] = w
+	}
+	if w.count >= limit {
+		return false
+	}
+	w.count++
+	return true
+}
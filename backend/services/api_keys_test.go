@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestAPIKeys(t *testing.T, limit int) *APIKeyStore {
+	t.Helper()
+	return &APIKeyStore{FilePath: filepath.Join(t.TempDir(), "api_keys.json"), Data: map[string]APIKey{}, DefaultLimit: limit}
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	s := newTestAPIKeys(t, 0)
+	key, secret, err := s.Create(APIKey{UserID: "alice", Name: "scanner", Scopes: []string{ScopeUpload}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAPIKey(secret) {
+		t.Fatalf("key %q lacks the API key prefix", secret)
+	}
+
+	got, err := s.Authenticate(secret)
+	if err != nil || got.UserID != "alice" || !got.HasScope(ScopeUpload) || got.HasScope(ScopeRead) {
+		t.Fatalf("Authenticate = %+v, %v", got, err)
+	}
+	if _, err := s.Authenticate(secret[:len(secret)-1] + "x"); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Errorf("tampered key: %v", err)
+	}
+
+	// Only the hash is stored
+	file, _ := os.ReadFile(s.FilePath)
+	if strings.Contains(string(file), secret) || !strings.Contains(string(file), key.Hash) {
+		t.Error("key file should hold the hash and not the key")
+	}
+
+	if _, err := s.Revoke("bob", key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("revoke by another user: %v", err)
+	}
+	if _, err := s.Revoke("alice", key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(secret); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Errorf("revoked key: %v", err)
+	}
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	s := newTestAPIKeys(t, 5)
+	_, secret, _ := s.Create(APIKey{UserID: "alice", Scopes: []string{ScopeRead}, RateLimit: 2})
+	for i := 0; i < 2; i++ {
+		if _, err := s.Authenticate(secret); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	if _, err := s.Authenticate(secret); !errors.Is(err, ErrAPIKeyRateLimited) {
+		t.Fatalf("third request: %v", err)
+	}
+
+	if _, _, err := s.Create(APIKey{UserID: "alice", Scopes: []string{"everything"}}); !errors.Is(err, ErrAPIKeyScope) {
+		t.Errorf("unknown scope: %v", err)
+	}
+}
@@ -0,0 +1,397 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit results, from the response status.
+const (
+	AuditOK     = "ok"
+	AuditDenied = "denied" // 4xx: unauthenticated, forbidden, missing or locked
+	AuditError  = "error"  // 5xx
+)
+
+// auditGenesis is the PrevHash of the first entry.
+var auditGenesis = strings.Repeat("0", 64)
+
+var ErrNothingToAnchor = errors.New("no audit entries since the last anchor")
+
+// AuditEntry records one action on a document. Hash covers every other field
+// and PrevHash, so changing, removing or reordering entries breaks the chain.
+type AuditEntry struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	UserID     string    `json:"userId,omitempty"`
+	APIKeyID   string    `json:"apiKeyId,omitempty"`
+	Via        string    `json:"via,omitempty"` // "signed-url", "share" or "admin" when no user is known
+	IP         string    `json:"ip"`
+	Action     string    `json:"action"`
+	DocumentID string    `json:"documentId,omitempty"`
+	Result     string    `json:"result"`
+	Status     int       `json:"status"`
+	PrevHash   string    `json:"prevHash"`
+	Hash       string    `json:"hash"`
+}
+
+func (e AuditEntry) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditResult maps a response status to AuditOK, AuditDenied or AuditError.
+func AuditResult(status int) string {
+	switch {
+	case status >= 500:
+		return AuditError
+	case status >= 400:
+		return AuditDenied
+	default:
+		return AuditOK
+	}
+}
+
+// AuditAnchor is a chain head recorded on chain. Verifying the log up to
+// Seq against Hash proves it was not rewritten after AnchoredAt.
+type AuditAnchor struct {
+	Seq        int64     `json:"seq"`
+	Hash       string    `json:"hash"`
+	TxHash     string    `json:"txHash"`
+	Network    string    `json:"network"`
+	ChainID    int64     `json:"chainId"`
+	AnchoredAt time.Time `json:"anchoredAt"`
+	// Method is AnchorTxData for heads sent as transaction data. Anchors
+	// made before have none: they were registered as documents.
+	Method string `json:"method,omitempty"`
+}
+
+// AnchorTxData marks anchors sent with EthService.SendData.
+const AnchorTxData = "tx-data"
+
+// auditAnchorData is the transaction data of an anchor.
+func auditAnchorData(seq int64, head string) string {
+	return fmt.Sprintf("cryptodoc-audit:%d:%s", seq, head)
+}
+
+// anchoredHead reads the chain head an anchor recorded on chain.
+func anchoredHead(eth *EthService, anchor AuditAnchor) (string, error) {
+	if anchor.Method != AnchorTxData {
+		return eth.RegisteredHash(anchor.TxHash)
+	}
+	data, err := eth.SentData(anchor.TxHash)
+	if err != nil {
+		return "", err
+	}
+	head, ok := strings.CutPrefix(string(data), auditAnchorData(anchor.Seq, ""))
+	if !ok {
+		return "", errors.New("transaction is not an anchor of this entry")
+	}
+	return head, nil
+}
+
+// AuditFilter narrows a query; zero fields match everything.
+type AuditFilter struct {
+	UserID     string
+	Action     string
+	DocumentID string
+	Result     string
+	From, To   time.Time
+}
+
+func (f AuditFilter) Matches(e AuditEntry) bool {
+	return (f.UserID == "" || e.UserID == f.UserID) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.DocumentID == "" || e.DocumentID == f.DocumentID) &&
+		(f.Result == "" || e.Result == f.Result) &&
+		(f.From.IsZero() || !e.Time.Before(f.From)) &&
+		(f.To.IsZero() || e.Time.Before(f.To))
+}
+
+// AuditVerification is the outcome of walking the whole chain.
+type AuditVerification struct {
+	Valid    bool          `json:"valid"`
+	Entries  int64         `json:"entries"`
+	Head     string        `json:"head"`
+	BrokenAt int64         `json:"brokenAt,omitempty"` // first entry that does not verify
+	Error    string        `json:"error,omitempty"`
+	Anchors  []AuditAnchor `json:"anchors"`
+	// AnchorErrors lists anchors that do not match the log or the chain, by seq.
+	AnchorErrors map[int64]string `json:"anchorErrors,omitempty"`
+}
+
+// AuditLog appends entries to a JSON lines file that is never rewritten.
+// Anchors are kept in a separate JSON file, like the other stores.
+type AuditLog struct {
+	FilePath    string
+	AnchorsPath string
+	Anchors     []AuditAnchor
+	mu          sync.Mutex
+	seq         int64
+	head        string
+}
+
+var Audit *AuditLog
+
+func InitAudit(filePath, anchorsPath string) error {
+	Audit = &AuditLog{FilePath: filePath, AnchorsPath: anchorsPath, head: auditGenesis}
+	return Audit.Load()
+}
+
+// Load reads the anchors and continues the chain from the last entry. A
+// broken chain is reported but does not stop the server; GET
+// /admin/audit/verify shows where it breaks.
+func (a *AuditLog) Load() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if file, err := os.ReadFile(a.AnchorsPath); err == nil {
+		if err := json.Unmarshal(file, &a.Anchors); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	v, err := a.verifyLocked()
+	if err != nil {
+		return err
+	}
+	if v.BrokenAt > 0 {
+		log.Printf("Warning: audit log chain broken at entry %d: %s\n", v.BrokenAt, v.Error)
+	}
+	for seq, msg := range v.AnchorErrors {
+		log.Printf("Warning: audit anchor at entry %d: %s\n", seq, msg)
+	}
+	a.seq, a.head = v.Entries, v.Head
+	return nil
+}
+
+func (a *AuditLog) saveAnchors() error {
+	data, err := json.MarshalIndent(a.Anchors, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.AnchorsPath, data, 0644)
+}
+
+// Record appends an entry, filling in its sequence number and hashes.
+func (a *AuditLog) Record(e AuditEntry) (AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e.Seq = a.seq + 1
+	e.Time = e.Time.UTC()
+	e.PrevHash = a.head
+	e.Hash = e.computeHash()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+
+	f, err := os.OpenFile(a.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return e, err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return e, err
+	}
+	if err := f.Close(); err != nil {
+		return e, err
+	}
+	a.seq, a.head = e.Seq, e.Hash
+	return e, nil
+}
+
+// scan calls fn for every entry in the file, in order.
+func (a *AuditLog) scan(fn func(AuditEntry, error) bool) error {
+	f, err := os.Open(a.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		var e AuditEntry
+		err := json.Unmarshal(sc.Bytes(), &e)
+		if !fn(e, err) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+// Query returns the entries keep accepts, oldest first.
+func (a *AuditLog) Query(keep func(AuditEntry) bool) ([]AuditEntry, error) {
+	list := []AuditEntry{}
+	err := a.scan(func(e AuditEntry, err error) bool {
+		if err == nil && keep(e) {
+			list = append(list, e)
+		}
+		return true
+	})
+	return list, err
+}
+
+// Verify walks the chain and checks every anchor against the log and, when
+// its network is connected, against the hash registered on chain.
+func (a *AuditLog) Verify() (AuditVerification, error) {
+	a.mu.Lock()
+	v, err := a.verifyLocked()
+	anchors := append([]AuditAnchor(nil), a.Anchors...)
+	a.mu.Unlock()
+	if err != nil {
+		return v, err
+	}
+
+	for _, anchor := range anchors {
+		eth := NetworkByChainID(anchor.ChainID)
+		if eth == nil {
+			continue
+		}
+		registered, err := anchoredHead(eth, anchor)
+		switch {
+		case err != nil:
+			v.anchorError(anchor.Seq, "could not read transaction: "+err.Error())
+		case registered != anchor.Hash:
+			v.anchorError(anchor.Seq, "hash on chain differs from the anchor")
+		}
+	}
+	return v, nil
+}
+
+func (v *AuditVerification) anchorError(seq int64, msg string) {
+	if v.AnchorErrors == nil {
+		v.AnchorErrors = make(map[int64]string)
+	}
+	v.AnchorErrors[seq] = msg
+	v.Valid = false
+}
+
+func (a *AuditLog) verifyLocked() (AuditVerification, error) {
+	v := AuditVerification{Valid: true, Head: auditGenesis, Anchors: a.Anchors}
+	if v.Anchors == nil {
+		v.Anchors = []AuditAnchor{}
+	}
+	hashes := make(map[int64]string, len(a.Anchors))
+	for _, anchor := range a.Anchors {
+		hashes[anchor.Seq] = ""
+	}
+
+	err := a.scan(func(e AuditEntry, err error) bool {
+		var problem string
+		switch {
+		case err != nil:
+			problem = "unreadable entry: " + err.Error()
+		case e.Seq != v.Entries+1:
+			problem = fmt.Sprintf("expected entry %d, found %d", v.Entries+1, e.Seq)
+		case e.PrevHash != v.Head:
+			problem = "previous hash does not match"
+		case e.computeHash() != e.Hash:
+			problem = "entry hash does not match its contents"
+		}
+		if problem != "" && v.BrokenAt == 0 {
+			v.Valid = false
+			v.BrokenAt = v.Entries + 1
+			v.Error = problem
+		}
+		// Keep counting so appends continue after the last entry
+		v.Entries++
+		v.Head = e.Hash
+		if _, ok := hashes[v.Entries]; ok {
+			hashes[v.Entries] = e.Hash
+		}
+		return true
+	})
+	if err != nil {
+		return v, err
+	}
+
+	for _, anchor := range a.Anchors {
+		if hashes[anchor.Seq] != anchor.Hash {
+			v.anchorError(anchor.Seq, "log entry differs from the anchored hash")
+		}
+	}
+	return v, nil
+}
+
+// Anchor records the current chain head on the default network in the data
+// of a transaction from the signer to itself. It does not use the document
+// registry, so anchors take no document id there.
+func (a *AuditLog) Anchor(privateKey string) (AuditAnchor, error) {
+	eth := DefaultEth()
+	if eth == nil || privateKey == "" {
+		return AuditAnchor{}, errors.New("blockchain service not available")
+	}
+
+	a.mu.Lock()
+	seq, head := a.seq, a.head
+	anchored := len(a.Anchors) > 0 && a.Anchors[len(a.Anchors)-1].Seq >= seq
+	a.mu.Unlock()
+	if seq == 0 || anchored {
+		return AuditAnchor{}, ErrNothingToAnchor
+	}
+
+	txHash, err := eth.SendData(privateKey, []byte(auditAnchorData(seq, head)))
+	if err != nil {
+		return AuditAnchor{}, err
+	}
+	anchor := AuditAnchor{
+		Seq:        seq,
+		Hash:       head,
+		TxHash:     txHash,
+		Network:    eth.Network,
+		ChainID:    eth.ChainID.Int64(),
+		AnchoredAt: time.Now().UTC(),
+		Method:     AnchorTxData,
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Anchors = append(a.Anchors, anchor)
+	return anchor, a.saveAnchors()
+}
+
+// StartAuditAnchoring anchors new entries every interval until ctx is
+// cancelled. Nothing runs when the interval is zero.
+func StartAuditAnchoring(ctx context.Context, privateKey string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	track(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			anchor, err := Audit.Anchor(privateKey)
+			switch {
+			case errors.Is(err, ErrNothingToAnchor):
+			case err != nil:
+				log.Println("Audit: anchoring failed, retrying next interval:", err)
+			default:
+				log.Printf("Audit: entries up to %d anchored on %s. Tx: %s\n", anchor.Seq, anchor.Network, anchor.TxHash)
+			}
+		}
+	})
+}
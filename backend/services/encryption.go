@@ -0,0 +1,262 @@
+package services
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Objects are encrypted as a header followed by fixed-size AES-GCM segments
+// (the STREAM construction): the nonce is a random per-object prefix, the
+// segment counter and a flag marking the last segment, so segments cannot be
+// reordered, dropped or the stream truncated without failing authentication.
+const (
+	EncryptionAlgorithm = "AES-256-GCM-STREAM"
+
+	encMagic           = "CDE1"
+	encNoncePrefixSize = 7
+	encHeaderSize      = len(encMagic) + encNoncePrefixSize
+	encSegmentSize     = 64 << 10
+	encTagSize         = 16
+)
+
+var (
+	ErrEncryptionDisabled = errors.New("document is encrypted but no key manager is configured")
+	// ErrSegmentAuth means stored ciphertext was modified or truncated.
+	ErrSegmentAuth = errors.New("encrypted segment failed authentication")
+)
+
+// EncryptionInfo is stored with a document: its data key, wrapped by the
+// key manager's master key.
+type EncryptionInfo struct {
+	Algorithm   string `json:"algorithm"`
+	KeyID       string `json:"keyId"`
+	WrappedKey  string `json:"wrappedKey"`
+	SegmentSize int    `json:"segmentSize"`
+}
+
+// NewEncryption generates a data key for a new object. It returns nil when
+// encryption at rest is disabled.
+func NewEncryption() (*EncryptionInfo, error) {
+	if KMS == nil {
+		return nil, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := KMS.WrapKey(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key: %w", err)
+	}
+	return &EncryptionInfo{
+		Algorithm:   EncryptionAlgorithm,
+		KeyID:       KMS.KeyID(),
+		WrappedKey:  base64.StdEncoding.EncodeToString(wrapped),
+		SegmentSize: encSegmentSize,
+	}, nil
+}
+
+func (e *EncryptionInfo) aead() (cipher.AEAD, error) {
+	if e.Algorithm != EncryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported encryption algorithm %q", e.Algorithm)
+	}
+	if KMS == nil {
+		return nil, ErrEncryptionDisabled
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(e.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := KMS.UnwrapKey(e.KeyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PlainSize returns the plaintext size of an encrypted object of the given size.
+func (e *EncryptionInfo) PlainSize(cipherSize int64) int64 {
+	return cipherSize - int64(encHeaderSize) - e.segmentCount(cipherSize)*encTagSize
+}
+
+func (e *EncryptionInfo) segmentCount(cipherSize int64) int64 {
+	full := int64(e.SegmentSize + encTagSize)
+	return (cipherSize - int64(encHeaderSize) + full - 1) / full
+}
+
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encNoncePrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// EncryptReader returns a reader producing the encrypted form of r.
+func (e *EncryptionInfo) EncryptReader(r io.Reader) (io.Reader, error) {
+	aead, err := e.aead()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encHeaderSize)
+	copy(header, encMagic)
+	if _, err := rand.Read(header[len(encMagic):]); err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		src:    bufio.NewReaderSize(r, e.SegmentSize),
+		aead:   aead,
+		prefix: header[len(encMagic):],
+		plain:  make([]byte, e.SegmentSize),
+		buf:    make([]byte, 0, e.SegmentSize+encTagSize),
+		out:    header,
+		size:   e.SegmentSize,
+	}, nil
+}
+
+type encryptReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	plain   []byte
+	buf     []byte
+	out     []byte // encrypted bytes not yet returned
+	size    int
+	done    bool
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.sealNext(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *encryptReader) sealNext() error {
+	n, err := io.ReadFull(r.src, r.plain)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := n < r.size
+	if !last {
+		// A full segment is the last one only if nothing follows it
+		if _, peekErr := r.src.Peek(1); peekErr == io.EOF {
+			last = true
+		} else if peekErr != nil {
+			return peekErr
+		}
+	}
+	if r.counter == ^uint32(0) {
+		return errors.New("object too large to encrypt")
+	}
+
+	r.out = r.aead.Seal(r.buf[:0], segmentNonce(r.prefix, r.counter, last), r.plain[:n], nil)
+	r.counter++
+	r.done = last
+	return nil
+}
+
+// DecryptReader returns a reader producing the plaintext of the encrypted
+// stream r. Any tampering surfaces as a read error.
+func (e *EncryptionInfo) DecryptReader(r io.Reader) (io.Reader, error) {
+	header := make([]byte, encHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("read encryption header: %w", err)
+	}
+	return e.segmentReader(header, r, 0, -1)
+}
+
+// segmentReader decrypts segments read from r, the first one being number
+// first. final is the number of the object's last segment, or -1 to detect
+// it from the end of r.
+func (e *EncryptionInfo) segmentReader(header []byte, r io.Reader, first uint32, final int64) (io.Reader, error) {
+	if len(header) != encHeaderSize || string(header[:len(encMagic)]) != encMagic {
+		return nil, errors.New("object is not encrypted with " + EncryptionAlgorithm)
+	}
+	aead, err := e.aead()
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		src:     bufio.NewReaderSize(r, e.SegmentSize+encTagSize),
+		aead:    aead,
+		prefix:  header[len(encMagic):],
+		counter: first,
+		final:   final,
+		sealed:  make([]byte, e.SegmentSize+encTagSize),
+		buf:     make([]byte, 0, e.SegmentSize),
+	}, nil
+}
+
+type decryptReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	final   int64
+	sealed  []byte
+	buf     []byte
+	out     []byte // plaintext not yet returned
+	done    bool
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.openNext(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *decryptReader) openNext() error {
+	n, err := io.ReadFull(r.src, r.sealed)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := n < len(r.sealed)
+	if r.final >= 0 {
+		last = int64(r.counter) == r.final
+	} else if !last {
+		if _, peekErr := r.src.Peek(1); peekErr == io.EOF {
+			last = true
+		} else if peekErr != nil {
+			return peekErr
+		}
+	}
+
+	plain, err := r.aead.Open(r.buf[:0], segmentNonce(r.prefix, r.counter, last), r.sealed[:n], nil)
+	if err != nil {
+		return fmt.Errorf("decrypt segment %d: %w", r.counter, ErrSegmentAuth)
+	}
+	r.out = plain
+	r.counter++
+	r.done = last
+	return nil
+}
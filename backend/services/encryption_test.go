@@ -0,0 +1,139 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func withLocalKMS(t *testing.T) {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	km, err := NewLocalKeyManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	KMS = km
+	t.Cleanup(func() { KMS = nil })
+}
+
+func encryptBytes(t *testing.T, enc *EncryptionInfo, plain []byte) []byte {
+	t.Helper()
+	r, err := enc.EncryptReader(bytes.NewReader(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	withLocalKMS(t)
+	enc, err := NewEncryption()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, encSegmentSize - 1, encSegmentSize, encSegmentSize + 1, 3*encSegmentSize + 17} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		sealed := encryptBytes(t, enc, plain)
+		if got := enc.PlainSize(int64(len(sealed))); got != int64(size) {
+			t.Errorf("size %d: PlainSize = %d", size, got)
+		}
+
+		r, err := enc.DecryptReader(bytes.NewReader(sealed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: plaintext differs after round trip", size)
+		}
+	}
+}
+
+func TestDecryptDetectsTampering(t *testing.T) {
+	withLocalKMS(t)
+	enc, _ := NewEncryption()
+	plain := make([]byte, 2*encSegmentSize+100)
+	sealed := encryptBytes(t, enc, plain)
+
+	flipped := append([]byte(nil), sealed...)
+	flipped[encHeaderSize+10] ^= 1
+	// Dropping the final segment must not look like a shorter document
+	truncated := sealed[:encHeaderSize+2*(encSegmentSize+encTagSize)]
+
+	for name, data := range map[string][]byte{"flipped": flipped, "truncated": truncated} {
+		r, err := enc.DecryptReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Errorf("%s: expected a decryption error", name)
+		}
+	}
+}
+
+func TestUnwrapWithRotatedKey(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, newPath := filepath.Join(dir, "old.key"), filepath.Join(dir, "new.key")
+	for _, p := range []string{oldPath, newPath} {
+		key := make([]byte, 32)
+		rand.Read(key)
+		os.WriteFile(p, []byte(hex.EncodeToString(key)), 0600)
+	}
+
+	oldKM, _ := NewLocalKeyManager(oldPath)
+	wrapped, _ := oldKM.WrapKey([]byte("data key"))
+
+	rotated, err := NewLocalKeyManager(newPath, oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.KeyID() == oldKM.KeyID() {
+		t.Fatal("rotated manager should wrap with the new key")
+	}
+	got, err := rotated.UnwrapKey(oldKM.KeyID(), wrapped)
+	if err != nil || string(got) != "data key" {
+		t.Fatalf("UnwrapKey = %q, %v", got, err)
+	}
+}
+
+func TestDecryptFromMiddleSegment(t *testing.T) {
+	withLocalKMS(t)
+	enc, _ := NewEncryption()
+	plain := make([]byte, 3*encSegmentSize+5)
+	rand.Read(plain)
+	sealed := encryptBytes(t, enc, plain)
+
+	full := encSegmentSize + encTagSize
+	body := sealed[encHeaderSize+2*full:]
+	r, err := enc.segmentReader(sealed[:encHeaderSize], bytes.NewReader(body), 2, enc.segmentCount(int64(len(sealed)))-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain[2*encSegmentSize:]) {
+		t.Error("segments 2.. decrypted to the wrong plaintext")
+	}
+}
@@ -4,13 +4,21 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"math/big"
+	"strings"
+	"time"
 
 	"main/config"
 	"main/contracts"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
@@ -22,36 +30,114 @@ type EthService struct {
 	Address  common.Address
 	ChainID  *big.Int
 	Context  context.Context
+	// DeployBlock bounds event scans to blocks after the contract deployment.
+	DeployBlock uint64
+
+	Network       string // registry name, e.g. "sepolia"
+	ExplorerTxURL string // optional override of the chain's explorer template
+	Confirmations uint64
 }
 
-var Eth *EthService
+// ethReconnectInterval is how often networks that failed to connect are retried.
+const ethReconnectInterval = time.Minute
+
+// ethCallTimeout bounds every RPC call, so a hung node fails the call (and a
+// registration falls back to the outbox) instead of blocking the upload.
+const ethCallTimeout = 30 * time.Second
+
+var (
+	// eth is the service for the default network, where new documents are
+	// anchored. It is nil while that network is unreachable.
+	eth            *EthService
+	defaultNetwork string
+)
+
+// DefaultEth returns the default network service, or nil while it is down.
+func DefaultEth() *EthService {
+	networksMu.RLock()
+	defer networksMu.RUnlock()
+	return eth
+}
 
+// InitEth connects to every configured network and registers it in the
+// network registry. Networks that fail to connect are skipped so the others
+// keep working; StartEthReconnect keeps retrying them.
 func InitEth(cfg config.EthConfig) {
-	if cfg.RPCURL == "" || cfg.ContractAddr == "" {
-		return
+	networksMu.Lock()
+	defaultNetwork = cfg.DefaultNetwork
+	networksMu.Unlock()
+
+	for _, n := range cfg.Networks {
+		if n.RPCURL == "" || n.ContractAddr == "" {
+			log.Printf("Network %s: missing RPC URL or contract address, skipping\n", n.Name)
+			continue
+		}
+		if err := connectNetwork(n); err != nil {
+			log.Printf("Network %s: could not connect: %v\n", n.Name, err)
+		}
 	}
-	svc, err := NewEthService(cfg.RPCURL, cfg.ContractAddr)
+}
+
+// StartEthReconnect periodically retries networks that are not connected,
+// so an RPC outage at boot does not disable anchoring until the next restart.
+func StartEthReconnect(ctx context.Context, cfg config.EthConfig) {
+	track(func() {
+		ticker := time.NewTicker(ethReconnectInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, n := range cfg.Networks {
+				if n.RPCURL == "" || n.ContractAddr == "" || NetworkByName(n.Name) != nil {
+					continue
+				}
+				if err := connectNetwork(n); err != nil {
+					log.Printf("Network %s: reconnect failed: %v\n", n.Name, err)
+					continue
+				}
+				log.Printf("Network %s: connected\n", n.Name)
+			}
+		}
+	})
+}
+
+func connectNetwork(n config.NetworkConfig) error {
+	svc, err := NewEthService(n.RPCURL, n.ContractAddr)
 	if err != nil {
-		// Log error but don't crash, just disable eth features
-		// log.Println("Error initializing EthService:", err)
-		return
+		return err
+	}
+	if n.ChainID != 0 && svc.ChainID.Int64() != n.ChainID {
+		svc.Client.Close()
+		return fmt.Errorf("RPC reports chain id %s, expected %d", svc.ChainID, n.ChainID)
 	}
-	Eth = svc
+	svc.Network = n.Name
+	svc.ExplorerTxURL = n.ExplorerTxURL
+	svc.Confirmations = n.Confirmations
+	svc.DeployBlock = n.DeployBlock
+	RegisterNetwork(svc)
+	return nil
 }
 
 func NewEthService(rpcURL, contractAddr string) (*EthService, error) {
 	ctx := context.Background()
-	client, err := ethclient.DialContext(ctx, rpcURL)
+	dialCtx, cancel := context.WithTimeout(ctx, ethCallTimeout)
+	defer cancel()
+	client, err := ethclient.DialContext(dialCtx, rpcURL)
 	if err != nil {
 		return nil, err
 	}
-	chainID, err := client.ChainID(ctx)
+	chainID, err := client.ChainID(dialCtx)
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
 	addr := common.HexToAddress(contractAddr)
 	ctr, err := contracts.NewContracts(addr, client)
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
 	return &EthService{
@@ -69,41 +155,197 @@ func Sha256Hex(b []byte) string {
 	return hex.EncodeToString(sum[:])
 }
 
+// transactor builds signing options for the given hex private key on this
+// chain; the transaction's RPC calls use ctx.
+func (e *EthService) transactor(ctx context.Context, privateKeyHex string) (*bind.TransactOpts, error) {
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(privateKeyHex, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, e.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	auth.Context = ctx
+	return auth, nil
+}
+
+// rpcContext bounds one call to the node by ethCallTimeout.
+func (e *EthService) rpcContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(e.Context, ethCallTimeout)
+}
+
+// Sha256Reader hashes r without loading it in memory and returns the hex
+// digest and the number of bytes read.
+func Sha256Reader(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
 // RegisterDocument sends a transaction to register the document and returns tx hash.
 func (e *EthService) RegisterDocument(privateKeyHex, filename, fileHash, minioId, tag string) (string, error) {
-	privateKey, err := crypto.HexToECDSA(privateKeyHex)
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	auth, err := e.transactor(ctx, privateKeyHex)
 	if err != nil {
 		return "", err
 	}
 
-	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, e.ChainID)
+	tx, err := e.Contract.RegisterDocument(auth, filename, fileHash, minioId, tag)
 	if err != nil {
 		return "", err
 	}
+	return tx.Hash().Hex(), nil
+}
 
-	tx, err := e.Contract.RegisterDocument(auth, filename, fileHash, minioId, tag)
+// SendData sends a transaction without value from the signer to itself that
+// carries data, and returns its hash. It records a value on chain without
+// going through the document registry.
+func (e *EthService) SendData(privateKeyHex string, data []byte) (string, error) {
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	auth, err := e.transactor(ctx, privateKeyHex)
 	if err != nil {
 		return "", err
 	}
-	return tx.Hash().Hex(), nil
+	nonce, err := e.Client.PendingNonceAt(ctx, auth.From)
+	if err != nil {
+		return "", err
+	}
+	gas, err := e.Client.EstimateGas(ctx, ethereum.CallMsg{From: auth.From, To: &auth.From, Data: data})
+	if err != nil {
+		return "", err
+	}
+	head, err := e.Client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var tx *types.Transaction
+	if head.BaseFee != nil {
+		tip, err := e.Client.SuggestGasTipCap(ctx)
+		if err != nil {
+			return "", err
+		}
+		feeCap := new(big.Int).Add(tip, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+		tx = types.NewTx(&types.DynamicFeeTx{ChainID: e.ChainID, Nonce: nonce, GasTipCap: tip, GasFeeCap: feeCap, Gas: gas, To: &auth.From, Data: data})
+	} else {
+		price, err := e.Client.SuggestGasPrice(ctx)
+		if err != nil {
+			return "", err
+		}
+		tx = types.NewTx(&types.LegacyTx{Nonce: nonce, GasPrice: price, Gas: gas, To: &auth.From, Data: data})
+	}
+	signed, err := auth.Signer(auth.From, tx)
+	if err != nil {
+		return "", err
+	}
+	if err := e.Client.SendTransaction(ctx, signed); err != nil {
+		return "", err
+	}
+	return signed.Hash().Hex(), nil
+}
+
+// SentData returns the data of a mined transaction sent by SendData.
+func (e *EthService) SentData(txHash string) ([]byte, error) {
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	tx, pending, err := e.Client.TransactionByHash(ctx, common.HexToHash(txHash))
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		return nil, errors.New("transaction not mined yet")
+	}
+	return tx.Data(), nil
 }
 
 // GetDocumentsByName (call)
 func (e *EthService) GetDocumentsByName(name string) ([]*big.Int, error) {
-	return e.Contract.GetDocumentsByName(&bind.CallOpts{Context: e.Context}, name)
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	return e.Contract.GetDocumentsByName(&bind.CallOpts{Context: ctx}, name)
 }
 
 // GetDocument (call)
 func (e *EthService) GetDocument(id *big.Int) (contracts.FileDocument, error) {
-	return e.Contract.GetDocument(&bind.CallOpts{Context: e.Context}, id)
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	return e.Contract.GetDocument(&bind.CallOpts{Context: ctx}, id)
 }
 
 // VerifyDocument (call)
 func (e *EthService) VerifyDocument(id *big.Int, givenHash string) (bool, error) {
-	return e.Contract.VerifyDocument(&bind.CallOpts{Context: e.Context}, id, givenHash)
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	return e.Contract.VerifyDocument(&bind.CallOpts{Context: ctx}, id, givenHash)
 }
 
 // GetDocumentCount (call)
 func (e *EthService) GetDocumentCount() (*big.Int, error) {
-	return e.Contract.DocumentCount(&bind.CallOpts{Context: e.Context})
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	return e.Contract.DocumentCount(&bind.CallOpts{Context: ctx})
+}
+
+// registration returns the DocumentRegistered event emitted by txHash.
+func (e *EthService) registration(txHash string) (*contracts.ContractsDocumentRegistered, error) {
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	receipt, err := e.Client.TransactionReceipt(ctx, common.HexToHash(txHash))
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range receipt.Logs {
+		if l.Address != e.Address {
+			continue
+		}
+		if ev, err := e.Contract.ParseDocumentRegistered(*l); err == nil {
+			return ev, nil
+		}
+	}
+	return nil, fmt.Errorf("no DocumentRegistered event in transaction %s", txHash)
+}
+
+// RegisteredHash returns the hash recorded by the registration in txHash.
+func (e *EthService) RegisteredHash(txHash string) (string, error) {
+	ev, err := e.registration(txHash)
+	if err != nil {
+		return "", err
+	}
+	return ev.Hash, nil
+}
+
+// RegisteredID returns the id the contract assigned to the registration in
+// txHash.
+func (e *EthService) RegisteredID(txHash string) (string, error) {
+	ev, err := e.registration(txHash)
+	if err != nil {
+		return "", err
+	}
+	return ev.Id.String(), nil
+}
+
+// FindDocumentByHash scans DocumentRegistered events since DeployBlock for a
+// document with the given hash. The hash is not an indexed topic, so this
+// walks every registration and is meant as an optional extra check.
+func (e *EthService) FindDocumentByHash(fileHash string) (*contracts.ContractsDocumentRegistered, error) {
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	it, err := e.Contract.FilterDocumentRegistered(&bind.FilterOpts{Start: e.DeployBlock, Context: ctx}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	for it.Next() {
+		if it.Event.Hash == fileHash {
+			return it.Event, nil
+		}
+	}
+	return nil, it.Error()
 }
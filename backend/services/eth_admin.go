@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"main/contracts"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// ContractStats summarizes the state of the deployed File contract.
+type ContractStats struct {
+	ContractAddress string `json:"contractAddress"`
+	ChainID         string `json:"chainId"`
+	BlockNumber     uint64 `json:"blockNumber"`
+	DocumentCount   string `json:"documentCount"`
+	Signer          string `json:"signer,omitempty"`
+	SignerIsOwner   bool   `json:"signerIsOwner"`
+	SignerBalance   string `json:"signerBalance,omitempty"` // in ETH
+}
+
+// AddressFromPrivateKey derives the account address for a hex encoded private key.
+func AddressFromPrivateKey(privateKeyHex string) (common.Address, error) {
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(privateKeyHex, "0x"))
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*privateKey.Public().(*ecdsa.PublicKey)), nil
+}
+
+// DeployFileContract deploys a fresh File contract signed by privateKeyHex and
+// waits until it is mined. It returns the contract address, the deployment tx
+// hash and the block it was mined in (useful as ETH_CONTRACT_DEPLOY_BLOCK).
+func DeployFileContract(ctx context.Context, rpcURL, privateKeyHex string) (common.Address, string, uint64, error) {
+	client, err := ethclient.DialContext(ctx, rpcURL)
+	if err != nil {
+		return common.Address{}, "", 0, err
+	}
+	defer client.Close()
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return common.Address{}, "", 0, err
+	}
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(privateKeyHex, "0x"))
+	if err != nil {
+		return common.Address{}, "", 0, err
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, chainID)
+	if err != nil {
+		return common.Address{}, "", 0, err
+	}
+	auth.Context = ctx
+
+	addr, tx, _, err := contracts.DeployContracts(auth, client)
+	if err != nil {
+		return common.Address{}, "", 0, err
+	}
+	receipt, err := bind.WaitMined(ctx, client, tx)
+	if err != nil {
+		return addr, tx.Hash().Hex(), 0, err
+	}
+	if receipt.Status != 1 {
+		return addr, tx.Hash().Hex(), 0, fmt.Errorf("deployment transaction %s reverted", tx.Hash().Hex())
+	}
+	return addr, tx.Hash().Hex(), receipt.BlockNumber.Uint64(), nil
+}
+
+// AddOwner authorizes a new account to register documents and returns the tx hash.
+// The signer must already be an owner of the contract.
+func (e *EthService) AddOwner(privateKeyHex string, newOwner common.Address) (string, error) {
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	auth, err := e.transactor(ctx, privateKeyHex)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := e.Contract.AddOwner(auth, newOwner)
+	if err != nil {
+		return "", err
+	}
+	return tx.Hash().Hex(), nil
+}
+
+// IsOwner (call)
+func (e *EthService) IsOwner(account common.Address) (bool, error) {
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	return e.Contract.IsOwner(&bind.CallOpts{Context: ctx}, account)
+}
+
+// ListOwners scans OwnerAdded events since DeployBlock. The deployer is an
+// owner too but the constructor emits no event, so extra candidates (e.g. the
+// configured signer) can be passed and are included when isOwner confirms them.
+func (e *EthService) ListOwners(extra ...common.Address) ([]common.Address, error) {
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	it, err := e.Contract.FilterOwnerAdded(&bind.FilterOpts{Start: e.DeployBlock, Context: ctx}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	seen := make(map[common.Address]bool)
+	var owners []common.Address
+	for it.Next() {
+		if !seen[it.Event.NewOwner] {
+			seen[it.Event.NewOwner] = true
+			owners = append(owners, it.Event.NewOwner)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+
+	for _, addr := range extra {
+		if seen[addr] {
+			continue
+		}
+		ok, err := e.IsOwner(addr)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			seen[addr] = true
+			owners = append(owners, addr)
+		}
+	}
+	return owners, nil
+}
+
+// Stats gathers contract-level information. privateKeyHex is optional; when set
+// the signer's address, ownership and balance are included.
+func (e *EthService) Stats(privateKeyHex string) (ContractStats, error) {
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	stats := ContractStats{
+		ContractAddress: e.Address.Hex(),
+		ChainID:         e.ChainID.String(),
+	}
+
+	count, err := e.GetDocumentCount()
+	if err != nil {
+		return stats, err
+	}
+	stats.DocumentCount = count.String()
+
+	block, err := e.Client.BlockNumber(ctx)
+	if err != nil {
+		return stats, err
+	}
+	stats.BlockNumber = block
+
+	if privateKeyHex == "" {
+		return stats, nil
+	}
+	signer, err := AddressFromPrivateKey(privateKeyHex)
+	if err != nil {
+		return stats, err
+	}
+	stats.Signer = signer.Hex()
+	if stats.SignerIsOwner, err = e.IsOwner(signer); err != nil {
+		return stats, err
+	}
+	balance, err := e.Client.BalanceAt(ctx, signer, nil)
+	if err != nil {
+		return stats, err
+	}
+	stats.SignerBalance = formatEther(balance)
+	return stats, nil
+}
+
+// CheckSignerOwnership reports whether the backend signing key can register
+// documents, so misconfigured keys are caught at startup instead of on upload.
+func (e *EthService) CheckSignerOwnership(privateKeyHex string) (common.Address, bool, error) {
+	signer, err := AddressFromPrivateKey(privateKeyHex)
+	if err != nil {
+		return common.Address{}, false, err
+	}
+	ok, err := e.IsOwner(signer)
+	return signer, ok, err
+}
+
+// formatEther renders a wei amount as ETH with 6 decimals.
+func formatEther(wei *big.Int) string {
+	f := new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(1e18))
+	return f.Text('f', 6)
+}
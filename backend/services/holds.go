@@ -0,0 +1,282 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	ErrLegalHold        = errors.New("document is under legal hold")
+	ErrUnderRetention   = errors.New("document is under retention")
+	ErrHoldNotFound     = errors.New("legal hold not found")
+	ErrRetentionShorten = errors.New("retention can only be extended")
+	// ErrObjectLock means a change was saved and is enforced by the API, but
+	// could not be mirrored onto S3 Object Lock.
+	ErrObjectLock = errors.New("object lock not applied")
+)
+
+// LegalHold blocks deletion of a document until it is released. Released
+// holds are kept as a record of who placed and lifted them.
+type LegalHold struct {
+	ID         string     `json:"id"`
+	Reason     string     `json:"reason"`
+	PlacedBy   string     `json:"placedBy"`
+	PlacedAt   time.Time  `json:"placedAt"`
+	ReleasedBy string     `json:"releasedBy,omitempty"`
+	ReleasedAt *time.Time `json:"releasedAt,omitempty"`
+}
+
+func (h LegalHold) Active() bool { return h.ReleasedAt == nil }
+
+// categoryRetention maps lower-cased categories to their minimum retention.
+var categoryRetention map[string]time.Duration
+
+// InitRetention sets the minimum retention of documents per category. It is
+// applied when a document is saved with a matching category and is never
+// shortened afterwards, even if the category changes.
+func InitRetention(policies map[string]time.Duration) {
+	categoryRetention = make(map[string]time.Duration, len(policies))
+	for category, d := range policies {
+		categoryRetention[strings.ToLower(category)] = d
+	}
+}
+
+// ApplyRetentionPolicies extends the retention of stored documents whose
+// category has a policy, e.g. after a policy was added or lengthened.
+func (s *MetadataStore) ApplyRetentionPolicies() error {
+	var changed [][]string
+	s.mu.Lock()
+	for id, meta := range s.Data {
+		if meta.PurgedAt != nil {
+			continue
+		}
+		d, ok := categoryRetention[strings.ToLower(meta.Category)]
+		if ok && d > 0 && extendRetention(&meta, documentCreated(meta).Add(d)) {
+			s.Data[id] = meta
+			changed = append(changed, documentObjects(meta))
+		}
+	}
+	s.mu.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if err := s.Save(); err != nil {
+		return err
+	}
+	for _, objects := range changed {
+		s.syncObjectLock(objects)
+	}
+	return nil
+}
+
+// ActiveHolds returns the holds that have not been released.
+func (m DocumentMetadata) ActiveHolds() []LegalHold {
+	var active []LegalHold
+	for _, h := range m.LegalHolds {
+		if h.Active() {
+			active = append(active, h)
+		}
+	}
+	return active
+}
+
+// CheckDeletable explains why a document may not be deleted or purged yet.
+func (m DocumentMetadata) CheckDeletable(now time.Time) error {
+	if len(m.ActiveHolds()) > 0 {
+		return ErrLegalHold
+	}
+	if m.RetainUntil != nil && now.Before(*m.RetainUntil) {
+		return fmt.Errorf("%w until %s", ErrUnderRetention, m.RetainUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// documentCreated is when the first version of a document was stored.
+func documentCreated(m DocumentMetadata) time.Time {
+	if len(m.Versions) > 0 {
+		return m.Versions[0].CreatedAt
+	}
+	if t, err := time.Parse("Jan 02, 2006", m.Date); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// extendRetention moves RetainUntil to until if that is later.
+func extendRetention(m *DocumentMetadata, until time.Time) bool {
+	if m.RetainUntil != nil && !until.After(*m.RetainUntil) {
+		return false
+	}
+	m.RetainUntil = &until
+	return true
+}
+
+// protectLocked carries holds and retention over from the stored record, so
+// callers saving a stale copy cannot drop them, and applies the category
+// retention policy.
+func protectLocked(meta *DocumentMetadata, stored DocumentMetadata, exists bool) {
+	if exists {
+		meta.LegalHolds = stored.LegalHolds
+		meta.RetainUntil = stored.RetainUntil
+	}
+	if d, ok := categoryRetention[strings.ToLower(meta.Category)]; ok && d > 0 {
+		extendRetention(meta, documentCreated(*meta).Add(d))
+	}
+}
+
+// documentObjects lists the stored objects of every version of a document.
+func documentObjects(m DocumentMetadata) []string {
+	seen := map[string]bool{"": true}
+	var names []string
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	add(m.MinioID)
+	for _, v := range m.Versions {
+		add(v.MinioID)
+	}
+	return names
+}
+
+// PlaceHold puts a legal hold on a document.
+func (s *MetadataStore) PlaceHold(id, reason, placedBy string) (LegalHold, error) {
+	hold := LegalHold{ID: NewRandomID(), Reason: reason, PlacedBy: placedBy, PlacedAt: time.Now()}
+
+	s.mu.Lock()
+	meta, ok := s.Data[id]
+	if !ok || meta.PurgedAt != nil {
+		s.mu.Unlock()
+		return LegalHold{}, ErrDocumentMissing
+	}
+	if s.purging[id] {
+		s.mu.Unlock()
+		return LegalHold{}, ErrPurging
+	}
+	meta.LegalHolds = append(meta.LegalHolds, hold)
+	s.Data[id] = meta
+	s.mu.Unlock()
+
+	if err := s.Save(); err != nil {
+		return hold, err
+	}
+	return hold, s.syncObjectLock(documentObjects(meta))
+}
+
+// ReleaseHold lifts one legal hold. Other holds and retention still apply.
+func (s *MetadataStore) ReleaseHold(id, holdID, releasedBy string) (LegalHold, error) {
+	s.mu.Lock()
+	meta, ok := s.Data[id]
+	if !ok {
+		s.mu.Unlock()
+		return LegalHold{}, ErrDocumentMissing
+	}
+	var released LegalHold
+	for i, h := range meta.LegalHolds {
+		if h.ID == holdID && h.Active() {
+			now := time.Now()
+			h.ReleasedAt = &now
+			h.ReleasedBy = releasedBy
+			meta.LegalHolds[i] = h
+			released = h
+		}
+	}
+	if released.ID == "" {
+		s.mu.Unlock()
+		return LegalHold{}, ErrHoldNotFound
+	}
+	s.Data[id] = meta
+	s.mu.Unlock()
+
+	if err := s.Save(); err != nil {
+		return released, err
+	}
+	return released, s.syncObjectLock(documentObjects(meta))
+}
+
+// SetRetention keeps a document until the given time. Retention is
+// write-once-read-many: it can be extended but never shortened.
+func (s *MetadataStore) SetRetention(id string, until time.Time) (DocumentMetadata, error) {
+	s.mu.Lock()
+	meta, ok := s.Data[id]
+	if !ok || meta.PurgedAt != nil {
+		s.mu.Unlock()
+		return meta, ErrDocumentMissing
+	}
+	if s.purging[id] {
+		s.mu.Unlock()
+		return meta, ErrPurging
+	}
+	if meta.RetainUntil != nil && until.Before(*meta.RetainUntil) {
+		s.mu.Unlock()
+		return meta, ErrRetentionShorten
+	}
+	extendRetention(&meta, until)
+	s.Data[id] = meta
+	s.mu.Unlock()
+
+	if err := s.Save(); err != nil {
+		return meta, err
+	}
+	return meta, s.syncObjectLock(documentObjects(meta))
+}
+
+// syncObjectLock mirrors holds and retention onto the stored objects with S3
+// Object Lock, so they cannot be removed even with direct bucket access. An
+// object shared by several documents keeps its legal hold while any of them
+// is held, and the latest retention date of all of them.
+func (s *MetadataStore) syncObjectLock(objects []string) error {
+	if !ObjectLockEnabled() || len(objects) == 0 {
+		return nil
+	}
+
+	type lockState struct {
+		hold  bool
+		until time.Time
+	}
+	wanted := make(map[string]*lockState, len(objects))
+	for _, name := range objects {
+		wanted[name] = &lockState{}
+	}
+
+	s.mu.RLock()
+	for _, meta := range s.Data {
+		if meta.PurgedAt != nil {
+			continue
+		}
+		for _, name := range documentObjects(meta) {
+			st, ok := wanted[name]
+			if !ok {
+				continue
+			}
+			st.hold = st.hold || len(meta.ActiveHolds()) > 0
+			if meta.RetainUntil != nil && meta.RetainUntil.After(st.until) {
+				st.until = *meta.RetainUntil
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	var errs []error
+	for name, st := range wanted {
+		if err := SetObjectLegalHold(name, st.hold); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+		if st.until.After(time.Now()) {
+			if err := SetObjectRetention(name, st.until); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		log.Println("Warning: object lock not applied:", err)
+		return fmt.Errorf("%w: %w", ErrObjectLock, err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLegalHoldBlocksDeletion(t *testing.T) {
+	s := &MetadataStore{FilePath: filepath.Join(t.TempDir(), "metadata.json"), Data: map[string]DocumentMetadata{
+		"1": {ID: "1", Name: "dispute.pdf"},
+	}}
+
+	stale, _ := s.Get("1")
+	hold, err := s.PlaceHold("1", "litigation", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Saving a copy read before the hold must not drop it
+	stale.Summary = "updated"
+	s.AddOrUpdate(stale)
+
+	if err := s.Delete("1"); !errors.Is(err, ErrLegalHold) {
+		t.Fatalf("delete under hold: %v", err)
+	}
+	if _, err := s.ReleaseHold("1", hold.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("1"); err != nil {
+		t.Fatalf("delete after release: %v", err)
+	}
+
+	got, _ := s.Get("1")
+	if len(got.LegalHolds) != 1 || got.LegalHolds[0].ReleasedBy != "bob" || got.LegalHolds[0].PlacedBy != "alice" {
+		t.Errorf("hold history = %+v", got.LegalHolds)
+	}
+}
+
+func TestRetentionOnlyExtends(t *testing.T) {
+	InitRetention(map[string]time.Duration{"Contract": 365 * 24 * time.Hour})
+	t.Cleanup(func() { InitRetention(nil) })
+
+	s := &MetadataStore{FilePath: filepath.Join(t.TempDir(), "metadata.json"), Data: map[string]DocumentMetadata{}}
+	s.AddOrUpdate(DocumentMetadata{ID: "1", Category: "contract", Date: CurrentDate()})
+
+	meta, _ := s.Get("1")
+	if meta.RetainUntil == nil || meta.RetainUntil.Before(time.Now().Add(360*24*time.Hour)) {
+		t.Fatalf("category retention not applied: %v", meta.RetainUntil)
+	}
+
+	// A new category does not lift the retention already applied
+	meta.Category = "General"
+	s.AddOrUpdate(meta)
+	if err := s.Delete("1"); !errors.Is(err, ErrUnderRetention) {
+		t.Fatalf("delete under retention: %v", err)
+	}
+	if _, err := s.SetRetention("1", time.Now().Add(time.Hour)); !errors.Is(err, ErrRetentionShorten) {
+		t.Errorf("shortening retention: %v", err)
+	}
+	if _, err := s.SetRetention("1", time.Now().Add(2*365*24*time.Hour)); err != nil {
+		t.Errorf("extending retention: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"main/config"
+)
+
+// KeyManager wraps and unwraps per-document data keys with a master key that
+// never leaves it. Implementations can back onto a cloud KMS or an HSM; the
+// local one keeps the master key in a file.
+type KeyManager interface {
+	// KeyID identifies the master key used by WrapKey.
+	KeyID() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// KMS is nil when encryption at rest is disabled.
+var KMS KeyManager
+
+var ErrUnknownKey = errors.New("unknown master key")
+
+func InitEncryption(cfg config.EncryptionConfig) error {
+	switch cfg.Provider {
+	case "":
+		KMS = nil
+		return nil
+	case "local":
+		km, err := NewLocalKeyManager(cfg.KeyFile, cfg.PreviousKeyFiles...)
+		if err != nil {
+			return err
+		}
+		KMS = km
+		return nil
+	default:
+		return fmt.Errorf("unknown KMS_PROVIDER %q", cfg.Provider)
+	}
+}
+
+// LocalKeyManager wraps data keys with AES-256-GCM under master keys read
+// from files. Older keys stay usable for unwrapping after a rotation.
+type LocalKeyManager struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+func NewLocalKeyManager(keyFile string, previous ...string) (*LocalKeyManager, error) {
+	if keyFile == "" {
+		return nil, errors.New("KMS_KEY_FILE is required for the local key manager")
+	}
+	km := &LocalKeyManager{keys: make(map[string]cipher.AEAD)}
+	for i, f := range append([]string{keyFile}, previous...) {
+		id, aead, err := loadMasterKey(f)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			km.current = id
+		}
+		km.keys[id] = aead
+	}
+	return km, nil
+}
+
+// loadMasterKey reads a 32-byte key stored as hex or base64. Its id is a
+// short fingerprint, so metadata never contains key material.
+func loadMasterKey(path string) (string, cipher.AEAD, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("read master key: %w", err)
+	}
+	text := strings.TrimSpace(string(raw))
+	key, err := hex.DecodeString(text)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(text)
+	}
+	if err != nil || len(key) != 32 {
+		return "", nil, fmt.Errorf("master key %s must be 32 bytes, hex or base64 encoded", path)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", nil, err
+	}
+	fingerprint := sha256.Sum256(key)
+	return "local:" + hex.EncodeToString(fingerprint[:8]), aead, nil
+}
+
+func (km *LocalKeyManager) KeyID() string { return km.current }
+
+func (km *LocalKeyManager) WrapKey(dataKey []byte) ([]byte, error) {
+	aead := km.keys[km.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(km.current)), nil
+}
+
+func (km *LocalKeyManager) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := km.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, []byte(keyID))
+}
@@ -0,0 +1,414 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"main/config"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	TierStandard  = "standard"
+	TierArchive   = "archive"
+	TierRestoring = "restoring" // archived in GLACIER/DEEP_ARCHIVE, restore requested
+)
+
+// accessSaveInterval limits how often reads rewrite the tier file just to
+// move a last access time forward.
+const accessSaveInterval = time.Hour
+
+// ObjectTier records where an archived object lives. Objects without an
+// entry are in the standard tier under their own key in the main bucket.
+type ObjectTier struct {
+	Tier         string     `json:"tier"`
+	Bucket       string     `json:"bucket"`
+	Key          string     `json:"key"`
+	StorageClass string     `json:"storageClass,omitempty"`
+	ArchivedAt   time.Time  `json:"archivedAt"`
+	RestoreAt    *time.Time `json:"restoreRequestedAt,omitempty"`
+}
+
+// TierStore persists archived object locations and the last time each
+// object was read, which drives the lifecycle rules.
+type TierStore struct {
+	FilePath   string                `json:"-"`
+	Objects    map[string]ObjectTier `json:"objects"`
+	LastAccess map[string]time.Time  `json:"lastAccess"`
+	cfg        config.LifecycleConfig
+	promoting  map[string]bool
+	mu         sync.RWMutex
+}
+
+var Tiers *TierStore
+
+func InitLifecycle(filePath string, cfg config.LifecycleConfig) error {
+	Tiers = &TierStore{
+		FilePath:   filePath,
+		Objects:    make(map[string]ObjectTier),
+		LastAccess: make(map[string]time.Time),
+		cfg:        cfg,
+		promoting:  make(map[string]bool),
+	}
+	return Tiers.Load()
+}
+
+func (t *TierStore) Load() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	file, err := os.ReadFile(t.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(file, t)
+}
+
+func (t *TierStore) Save() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.FilePath, data, 0644)
+}
+
+// objectLocation is where an object is stored right now.
+func objectLocation(objectName string) (bucket, key string) {
+	if Tiers != nil {
+		Tiers.mu.RLock()
+		entry, ok := Tiers.Objects[objectName]
+		Tiers.mu.RUnlock()
+		if ok {
+			return entry.Bucket, entry.Key
+		}
+	}
+	return StorageBucket, objectName
+}
+
+// StorageTier returns the tier of a document: archived or restoring if any
+// of its objects is.
+func StorageTier(meta DocumentMetadata) string {
+	tier := TierStandard
+	if Tiers == nil {
+		return tier
+	}
+	Tiers.mu.RLock()
+	defer Tiers.mu.RUnlock()
+	for _, name := range documentObjects(meta) {
+		entry, ok := Tiers.Objects[name]
+		if !ok {
+			continue
+		}
+		if entry.Tier == TierRestoring {
+			return TierRestoring
+		}
+		tier = entry.Tier
+	}
+	return tier
+}
+
+func (t *TierStore) forget(objectName string) {
+	t.mu.Lock()
+	_, archived := t.Objects[objectName]
+	_, accessed := t.LastAccess[objectName]
+	delete(t.Objects, objectName)
+	delete(t.LastAccess, objectName)
+	t.mu.Unlock()
+	if archived || accessed {
+		if err := t.Save(); err != nil {
+			log.Println("Lifecycle: failed to save tiers:", err)
+		}
+	}
+}
+
+// needsRestore reports whether objects of a storage class must be restored
+// before they can be read.
+func needsRestore(storageClass string) bool {
+	return storageClass == s3.StorageClassGlacier || storageClass == s3.StorageClassDeepArchive
+}
+
+// objectReadable reports whether an object can be read without a restore.
+func objectReadable(objectName string) bool {
+	if Tiers == nil {
+		return true
+	}
+	Tiers.mu.RLock()
+	entry, ok := Tiers.Objects[objectName]
+	Tiers.mu.RUnlock()
+	return !ok || !needsRestore(entry.StorageClass)
+}
+
+// PrepareObject makes an archived object readable. It reports false while a
+// restore from GLACIER or DEEP_ARCHIVE is still running, starting one if
+// needed; other tiers are readable right away.
+func PrepareObject(objectName string) (bool, error) {
+	if Tiers == nil {
+		return true, nil
+	}
+	Tiers.mu.RLock()
+	entry, ok := Tiers.Objects[objectName]
+	Tiers.mu.RUnlock()
+	if !ok || !needsRestore(entry.StorageClass) {
+		return true, nil
+	}
+
+	head, err := S3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(entry.Bucket),
+		Key:    aws.String(entry.Key),
+	})
+	if err != nil {
+		return false, err
+	}
+	restore := aws.StringValue(head.Restore)
+	switch {
+	case strings.Contains(restore, `ongoing-request="false"`):
+		return true, nil
+	case strings.Contains(restore, `ongoing-request="true"`):
+		return false, nil
+	}
+
+	_, err = S3Client.RestoreObject(&s3.RestoreObjectInput{
+		Bucket: aws.String(entry.Bucket),
+		Key:    aws.String(entry.Key),
+		RestoreRequest: &s3.RestoreRequest{
+			Days:                 aws.Int64(Tiers.cfg.RestoreDays),
+			GlacierJobParameters: &s3.GlacierJobParameters{Tier: aws.String(s3.TierStandard)},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	Tiers.mu.Lock()
+	entry.Tier = TierRestoring
+	entry.RestoreAt = &now
+	Tiers.Objects[objectName] = entry
+	Tiers.mu.Unlock()
+	log.Printf("Lifecycle: restore of %s requested\n", objectName)
+	return false, Tiers.Save()
+}
+
+// ObjectAccessed records a read of an object. Archived objects that are read
+// again move back to the standard tier in the background.
+func ObjectAccessed(objectName string) {
+	if Tiers == nil {
+		return
+	}
+	now := time.Now()
+	Tiers.mu.Lock()
+	save := now.Sub(Tiers.LastAccess[objectName]) > accessSaveInterval
+	if save {
+		Tiers.LastAccess[objectName] = now
+	}
+	_, archived := Tiers.Objects[objectName]
+	Tiers.mu.Unlock()
+
+	if save {
+		if err := Tiers.Save(); err != nil {
+			log.Println("Lifecycle: failed to save tiers:", err)
+		}
+	}
+	if archived {
+		track(func() { Tiers.promote(objectName) })
+	}
+}
+
+// copyObject copies an object within or between buckets.
+func copyObject(srcBucket, srcKey, dstBucket, dstKey, storageClass string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(dstBucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String((&url.URL{Path: srcBucket + "/" + srcKey}).EscapedPath()),
+	}
+	if storageClass != "" {
+		input.StorageClass = aws.String(storageClass)
+	}
+	_, err := S3Client.CopyObject(input)
+	return err
+}
+
+func deleteAt(bucket, key string) error {
+	_, err := S3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// archive moves an object to the archive tier. The copy is made before the
+// original is removed, so a failure leaves the object where it was.
+func (t *TierStore) archive(objectName string) error {
+	bucket := t.cfg.ArchiveBucket
+	if bucket == "" {
+		bucket = StorageBucket
+	}
+	key := t.cfg.ArchivePrefix + objectName
+
+	if err := copyObject(StorageBucket, objectName, bucket, key, t.cfg.ArchiveStorageClass); err != nil {
+		return err
+	}
+
+	// Readers switch to the archived copy before the original goes away
+	t.mu.Lock()
+	t.Objects[objectName] = ObjectTier{
+		Tier:         TierArchive,
+		Bucket:       bucket,
+		Key:          key,
+		StorageClass: t.cfg.ArchiveStorageClass,
+		ArchivedAt:   time.Now(),
+	}
+	t.mu.Unlock()
+	if err := t.Save(); err != nil {
+		return err
+	}
+	if bucket != StorageBucket || key != objectName {
+		if err := deleteAt(StorageBucket, objectName); err != nil {
+			log.Printf("Lifecycle: failed to remove standard copy of %s: %v\n", objectName, err)
+		}
+	}
+	return nil
+}
+
+// promote moves an archived object back to the standard tier.
+func (t *TierStore) promote(objectName string) {
+	t.mu.Lock()
+	entry, ok := t.Objects[objectName]
+	if !ok || t.promoting[objectName] {
+		t.mu.Unlock()
+		return
+	}
+	t.promoting[objectName] = true
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.promoting, objectName)
+		t.mu.Unlock()
+	}()
+
+	if err := copyObject(entry.Bucket, entry.Key, StorageBucket, objectName, s3.StorageClassStandard); err != nil {
+		log.Printf("Lifecycle: failed to restore %s to the standard tier: %v\n", objectName, err)
+		return
+	}
+	t.mu.Lock()
+	delete(t.Objects, objectName)
+	t.mu.Unlock()
+	if err := t.Save(); err != nil {
+		log.Println("Lifecycle: failed to save tiers:", err)
+	}
+	if entry.Bucket != StorageBucket || entry.Key != objectName {
+		if err := deleteAt(entry.Bucket, entry.Key); err != nil {
+			log.Printf("Lifecycle: failed to remove archived copy of %s: %v\n", objectName, err)
+		}
+	}
+	log.Printf("Lifecycle: %s moved back to the standard tier\n", objectName)
+}
+
+// lifecycleRule returns how long objects of a category may stay unopened.
+func (t *TierStore) lifecycleRule(category string) (time.Duration, bool) {
+	for c, d := range t.cfg.Rules {
+		if strings.EqualFold(c, category) {
+			return d, true
+		}
+	}
+	d, ok := t.cfg.Rules["*"]
+	return d, ok
+}
+
+// idleObjects returns the objects of live documents that nobody opened for
+// longer than their category allows. An object counts as opened when it was
+// stored.
+func (t *TierStore) idleObjects(now time.Time) []string {
+	var idle []string
+	seen := make(map[string]bool)
+	for _, meta := range Store.GetAll() {
+		maxIdle, ok := t.lifecycleRule(meta.Category)
+		if !ok {
+			continue
+		}
+		// Object Lock retention would keep the original after the copy
+		if ObjectLockEnabled() && meta.CheckDeletable(now) != nil {
+			continue
+		}
+
+		stored := map[string]time.Time{meta.MinioID: documentCreated(meta)}
+		for _, v := range meta.Versions {
+			stored[v.MinioID] = v.CreatedAt
+		}
+
+		t.mu.RLock()
+		for name, created := range stored {
+			if _, archived := t.Objects[name]; archived || name == "" || seen[name] {
+				continue
+			}
+			last := created
+			if a := t.LastAccess[name]; a.After(last) {
+				last = a
+			}
+			if now.Sub(last) > maxIdle {
+				idle = append(idle, name)
+				seen[name] = true
+			}
+		}
+		t.mu.RUnlock()
+	}
+	return idle
+}
+
+// run archives idle objects and finishes restores that completed.
+func (t *TierStore) run() {
+	for _, name := range t.idleObjects(time.Now()) {
+		if err := t.archive(name); err != nil {
+			log.Printf("Lifecycle: failed to archive %s: %v\n", name, err)
+			continue
+		}
+		log.Printf("Lifecycle: %s archived\n", name)
+	}
+
+	t.mu.RLock()
+	var restoring []string
+	for name, entry := range t.Objects {
+		if entry.Tier == TierRestoring {
+			restoring = append(restoring, name)
+		}
+	}
+	t.mu.RUnlock()
+	for _, name := range restoring {
+		if ready, err := PrepareObject(name); err == nil && ready {
+			t.promote(name)
+		}
+	}
+}
+
+// StartLifecycle applies the lifecycle rules every interval until ctx is
+// cancelled. Nothing runs without rules.
+func StartLifecycle(ctx context.Context) {
+	if Tiers == nil || len(Tiers.cfg.Rules) == 0 {
+		return
+	}
+	track(func() {
+		ticker := time.NewTicker(Tiers.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			Tiers.run()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"main/config"
+)
+
+func TestIdleObjectsFollowCategoryRules(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-400 * 24 * time.Hour)
+	prev := Store
+	Store = &MetadataStore{Data: map[string]DocumentMetadata{
+		"scan":    {ID: "scan", MinioID: "docs/scan.pdf", Category: "Scan", Versions: []DocumentVersion{{Number: 1, MinioID: "docs/scan.pdf", CreatedAt: old}}},
+		"opened":  {ID: "opened", MinioID: "docs/opened.pdf", Category: "scan", Versions: []DocumentVersion{{Number: 1, MinioID: "docs/opened.pdf", CreatedAt: old}}},
+		"invoice": {ID: "invoice", MinioID: "docs/invoice.pdf", Category: "Invoice", Versions: []DocumentVersion{{Number: 1, MinioID: "docs/invoice.pdf", CreatedAt: old}}},
+		"deleted": {ID: "deleted", MinioID: "docs/deleted.pdf", Category: "Scan", Deleted: true, Versions: []DocumentVersion{{Number: 1, MinioID: "docs/deleted.pdf", CreatedAt: old}}},
+	}}
+	t.Cleanup(func() { Store = prev })
+
+	tiers := &TierStore{
+		Objects:    map[string]ObjectTier{},
+		LastAccess: map[string]time.Time{"docs/opened.pdf": now.Add(-time.Hour)},
+		cfg:        config.LifecycleConfig{Rules: map[string]time.Duration{"Scan": 90 * 24 * time.Hour}},
+	}
+
+	idle := tiers.idleObjects(now)
+	if len(idle) != 1 || idle[0] != "docs/scan.pdf" {
+		t.Fatalf("idleObjects = %v, want only the unopened scan", idle)
+	}
+
+	tiers.Objects["docs/scan.pdf"] = ObjectTier{Tier: TierArchive, Bucket: "archive", Key: "archive/docs/scan.pdf"}
+	if idle := tiers.idleObjects(now); len(idle) != 0 {
+		t.Errorf("archived object selected again: %v", idle)
+	}
+}
+
+func TestObjectLocationFollowsTier(t *testing.T) {
+	prev := Tiers
+	t.Cleanup(func() { Tiers = prev })
+	StorageBucket = "documents"
+	Tiers = &TierStore{Objects: map[string]ObjectTier{
+		"docs/a.pdf": {Tier: TierArchive, Bucket: "cold", Key: "archive/docs/a.pdf"},
+	}}
+
+	if b, k := objectLocation("docs/a.pdf"); b != "cold" || k != "archive/docs/a.pdf" {
+		t.Errorf("archived object at %s/%s", b, k)
+	}
+	if b, k := objectLocation("docs/b.pdf"); b != "documents" || k != "docs/b.pdf" {
+		t.Errorf("standard object at %s/%s", b, k)
+	}
+	if tier := StorageTier(DocumentMetadata{MinioID: "docs/a.pdf"}); tier != TierArchive {
+		t.Errorf("StorageTier = %q", tier)
+	}
+}
@@ -4,15 +4,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
 type DocumentMetadata struct {
-	ID                 string `json:"id"` // Blockchain ID (stringified) or MinIO object name if not on chain yet
+	ID                 string `json:"id"`               // random local ID; see ChainDocID for the on-chain one
+	UserID             string `json:"userId,omitempty"` // uploader, and owner unless OrgID is set
+	OrgID              string `json:"orgId,omitempty"`  // owning organization; access follows member roles
 	MinioID            string `json:"minioId"`
 	Name               string `json:"name"`
 	Size               string `json:"size"`
+	SizeBytes          int64  `json:"sizeBytes,omitempty"` // exact Size; missing on older records
 	Date               string `json:"date"`
 	Hash               string `json:"hash"`
 	AIStatus           string `json:"aiStatus"` // 'Processed' | 'Queued' | 'Failed'
@@ -22,13 +26,53 @@ type DocumentMetadata struct {
 	Summary            string `json:"summary"`
 	Validity           string `json:"validity"`
 	URL                string `json:"url"`
+	StorageTier        string `json:"storageTier,omitempty"` // filled in responses, see Tiers
 	Deleted            bool   `json:"deleted"`
+
+	// DeletedAt starts the trash retention period; PurgedAt is set once the
+	// content is erased and only a tombstone remains.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	PurgedAt  *time.Time `json:"purgedAt,omitempty"`
+
+	// LegalHolds and RetainUntil block deletion and purging. Both are only
+	// changed through PlaceHold, ReleaseHold and SetRetention.
+	LegalHolds  []LegalHold `json:"legalHolds,omitempty"`
+	RetainUntil *time.Time  `json:"retainUntil,omitempty"`
+
+	// ACL grants other users and groups access to this document only. It is
+	// only changed through Grant and RevokeGrant.
+	ACL []ACLEntry `json:"acl,omitempty"`
+
+	// Anchoring details; ChainID selects the network used to verify the proof.
+	// ChainDocID is the id the contract assigned, known once the registration
+	// is mined; ids restart on every contract, so it never keys the store.
+	TxHash      string `json:"txHash,omitempty"`
+	Network     string `json:"network,omitempty"`
+	ChainID     int64  `json:"chainId,omitempty"`
+	ChainDocID  string `json:"chainDocId,omitempty"`
+	ExplorerURL string `json:"explorerUrl,omitempty"`
+
+	// DuplicateOf is the document whose stored object and anchor this record reuses.
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+
+	// Encryption holds the wrapped data key when the stored object is
+	// encrypted at rest. Hash always refers to the plaintext.
+	Encryption *EncryptionInfo `json:"encryption,omitempty"`
+
+	// Integrity is the worst result of the last scrub over all versions.
+	Integrity *IntegrityCheck `json:"integrity,omitempty"`
+
+	// Versions is the ordered revision history; the fields above always
+	// describe the latest version. Empty until a second version is uploaded.
+	Versions []DocumentVersion `json:"versions,omitempty"`
 }
 
 type MetadataStore struct {
 	FilePath string
 	Data     map[string]DocumentMetadata
 	mu       sync.RWMutex
+	// purging holds the documents PurgeDocument is erasing, see claimPurge.
+	purging map[string]bool
 }
 
 var Store *MetadataStore
@@ -70,9 +114,43 @@ func (s *MetadataStore) Save() error {
 
 func (s *MetadataStore) AddOrUpdate(meta DocumentMetadata) error {
 	s.mu.Lock()
+	stored, exists := s.Data[meta.ID]
+	protectLocked(&meta, stored, exists)
+	if exists {
+		meta.ACL = stored.ACL
+	}
 	s.Data[meta.ID] = meta
 	s.mu.Unlock()
-	return s.Save()
+	if err := s.Save(); err != nil {
+		return err
+	}
+
+	// Lock new versions of held documents and newly applied retention
+	// (protectLocked only replaces the RetainUntil pointer when extending it)
+	changed := !exists || len(meta.Versions) != len(stored.Versions) || meta.RetainUntil != stored.RetainUntil
+	if changed && (len(meta.ActiveHolds()) > 0 || meta.RetainUntil != nil) {
+		s.syncObjectLock(documentObjects(meta))
+	}
+	return nil
+}
+
+// Update applies fn to the current record of id under the store lock, so
+// changes made meanwhile by other requests are not overwritten with a stale
+// copy. An error from fn leaves the record unchanged.
+func (s *MetadataStore) Update(id string, fn func(meta *DocumentMetadata) error) (DocumentMetadata, error) {
+	s.mu.Lock()
+	meta, ok := s.Data[id]
+	if !ok {
+		s.mu.Unlock()
+		return DocumentMetadata{}, ErrDocumentMissing
+	}
+	if err := fn(&meta); err != nil {
+		s.mu.Unlock()
+		return meta, err
+	}
+	s.Data[id] = meta
+	s.mu.Unlock()
+	return meta, s.Save()
 }
 
 func (s *MetadataStore) Get(id string) (DocumentMetadata, bool) {
@@ -95,10 +173,117 @@ func (s *MetadataStore) GetAll() []DocumentMetadata {
 	return list
 }
 
+// GetForUser returns a document only if userID may read it (see Can), like
+// SupabaseStore.Get. Deleted and purged records are returned too so callers
+// can tell them apart.
+func (s *MetadataStore) GetForUser(id, userID string) (DocumentMetadata, bool) {
+	meta, ok := s.Get(id)
+	if !ok || !Can(meta, userID, PermRead) {
+		return DocumentMetadata{}, false
+	}
+	return meta, true
+}
+
+// GetAllForUser returns the live documents userID may read: their own,
+// those of their organizations and those shared with them.
+func (s *MetadataStore) GetAllForUser(userID string) []DocumentMetadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []DocumentMetadata{}
+	for _, v := range s.Data {
+		if !v.Deleted && Can(v, userID, PermRead) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// ClaimUnowned assigns documents stored before ownership was recorded to
+// userID, and returns how many were claimed.
+func (s *MetadataStore) ClaimUnowned(userID string) (int, error) {
+	s.mu.Lock()
+	claimed := 0
+	for id, v := range s.Data {
+		if v.UserID == "" && v.OrgID == "" {
+			v.UserID = userID
+			s.Data[id] = v
+			claimed++
+		}
+	}
+	s.mu.Unlock()
+	if claimed == 0 {
+		return 0, nil
+	}
+	return claimed, s.Save()
+}
+
+// FindByHash returns a live document with the given content hash among the
+// personal documents of userID, or those of orgID when set. Anchored
+// documents are preferred, then the lowest ID, so repeated lookups agree.
+func (s *MetadataStore) FindByHash(hash, userID, orgID string) (DocumentMetadata, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var best DocumentMetadata
+	found := false
+	for _, v := range s.Data {
+		if v.Deleted || v.Hash != hash || v.DuplicateOf != "" || v.OrgID != orgID || (orgID == "" && v.UserID != userID) {
+			continue
+		}
+		if !found || betterOriginal(v, best) {
+			best = v
+			found = true
+		}
+	}
+	return best, found
+}
+
+// FindByObject returns the document stored under objectName and the version
+// it belongs to; for documents without history the version is built from the
+// top-level fields.
+func (s *MetadataStore) FindByObject(objectName string) (DocumentMetadata, DocumentVersion, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, v := range s.Data {
+		if v.Deleted {
+			continue
+		}
+		for _, version := range v.Versions {
+			if version.MinioID == objectName {
+				return v, version, true
+			}
+		}
+		if v.MinioID == objectName {
+			return v, DocumentVersion{Name: v.Name, Hash: v.Hash, MinioID: v.MinioID, Encryption: v.Encryption}, true
+		}
+	}
+	return DocumentMetadata{}, DocumentVersion{}, false
+}
+
+func betterOriginal(a, b DocumentMetadata) bool {
+	if (a.TxHash != "") != (b.TxHash != "") {
+		return a.TxHash != ""
+	}
+	if len(a.ID) != len(b.ID) {
+		return len(a.ID) < len(b.ID)
+	}
+	return a.ID < b.ID
+}
+
+// Delete moves a document to the trash unless a legal hold or retention
+// period protects it.
 func (s *MetadataStore) Delete(id string) error {
 	s.mu.Lock()
-	if val, ok := s.Data[id]; ok {
+	if val, ok := s.Data[id]; ok && !val.Deleted {
+		if err := val.CheckDeletable(time.Now()); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		now := time.Now()
 		val.Deleted = true
+		val.DeletedAt = &now
 		s.Data[id] = val
 	}
 	s.mu.Unlock()
@@ -108,7 +293,7 @@ func (s *MetadataStore) Delete(id string) error {
 func FormatBytes(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
-		return string(bytes) + " B"
+		return fmt.Sprintf("%d B", bytes)
 	}
 	div, exp := int64(unit), 0
 	for n := bytes / unit; n >= unit; n /= unit {
@@ -118,6 +303,33 @@ func FormatBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+// StoredBytes is the exact size of the current content, or Size read back
+// for records stored before SizeBytes.
+func (m DocumentMetadata) StoredBytes() int64 {
+	if m.SizeBytes > 0 {
+		return m.SizeBytes
+	}
+	return ParseBytes(m.Size)
+}
+
+// ParseBytes reads a size written by FormatBytes back, to within the
+// precision of its one decimal. Unreadable sizes count as zero.
+func ParseBytes(s string) int64 {
+	var value float64
+	var unit string
+	if _, err := fmt.Sscanf(s, "%g %s", &value, &unit); err != nil {
+		return 0
+	}
+	if unit == "B" {
+		return int64(value)
+	}
+	exp := strings.IndexByte("KMGTPE", unit[0])
+	if exp < 0 || len(unit) != 2 || unit[1] != 'B' {
+		return 0
+	}
+	return int64(value * float64(int64(1)<<(10*(exp+1))))
+}
+
 func CurrentDate() string {
 	return time.Now().Format("Jan 02, 2006")
 }
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindByHashPrefersAnchoredOriginal(t *testing.T) {
+	s := &MetadataStore{Data: map[string]DocumentMetadata{
+		"0":  {ID: "0", Hash: "abc"},
+		"10": {ID: "10", Hash: "abc", TxHash: "0x1"},
+		"2":  {ID: "2", Hash: "abc", TxHash: "0x2"},
+		"3":  {ID: "3", Hash: "abc", TxHash: "0x3", Deleted: true},
+		"4":  {ID: "4", Hash: "abc", TxHash: "0x2", DuplicateOf: "2"},
+		"5":  {ID: "5", Hash: "def"},
+	}}
+
+	got, ok := s.FindByHash("abc", "", "")
+	if !ok || got.ID != "2" {
+		t.Fatalf("FindByHash = %q, %v; want \"2\", true", got.ID, ok)
+	}
+	if _, ok := s.FindByHash("missing", "", ""); ok {
+		t.Fatal("FindByHash found a document for an unknown hash")
+	}
+}
+
+func TestQueriesAreScopedPerUser(t *testing.T) {
+	s := &MetadataStore{Data: map[string]DocumentMetadata{
+		"1": {ID: "1", UserID: "alice", Hash: "abc"},
+		"2": {ID: "2", UserID: "bob", Hash: "abc"},
+		"3": {ID: "3", UserID: "bob", Hash: "def", Deleted: true},
+	}}
+
+	if _, ok := s.GetForUser("2", "alice"); ok {
+		t.Error("GetForUser returned another user's document")
+	}
+	if got, ok := s.GetForUser("1", "alice"); !ok || got.ID != "1" {
+		t.Errorf("GetForUser = %q, %v", got.ID, ok)
+	}
+	if docs := s.GetAllForUser("bob"); len(docs) != 1 || docs[0].ID != "2" {
+		t.Errorf("GetAllForUser = %+v", docs)
+	}
+	if got, ok := s.FindByHash("abc", "bob", ""); !ok || got.ID != "2" {
+		t.Errorf("FindByHash = %q, %v; want bob's document", got.ID, ok)
+	}
+	if trash := s.Trash("alice"); len(trash) != 0 {
+		t.Errorf("Trash(alice) = %+v", trash)
+	}
+}
+
+func TestUpdateKeepsConcurrentChanges(t *testing.T) {
+	s := &MetadataStore{FilePath: filepath.Join(t.TempDir(), "metadata.json"), Data: map[string]DocumentMetadata{
+		"1": {ID: "1", Hash: "abc"},
+	}}
+
+	// Another request trashes the document after a stale copy was read
+	stale, _ := s.Get("1")
+	if err := s.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Update(stale.ID, func(meta *DocumentMetadata) error {
+		meta.TxHash = "0x1"
+		return nil
+	})
+	if err != nil || !got.Deleted || got.TxHash != "0x1" {
+		t.Errorf("Update = %+v, %v", got, err)
+	}
+
+	if _, err := s.Update("missing", func(*DocumentMetadata) error { return nil }); !errors.Is(err, ErrDocumentMissing) {
+		t.Errorf("Update of unknown document: %v", err)
+	}
+	abort := errors.New("abort")
+	if _, err := s.Update("1", func(meta *DocumentMetadata) error {
+		meta.Hash = "changed"
+		return abort
+	}); err != abort {
+		t.Errorf("Update error = %v", err)
+	}
+	if meta, _ := s.Get("1"); meta.Hash != "abc" {
+		t.Error("failed Update changed the record")
+	}
+}
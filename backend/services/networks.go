@@ -0,0 +1,134 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	networksMu sync.RWMutex
+	// networks holds a connected service per chain id so documents anchored on
+	// a previous network can still be verified after the default changes.
+	networks = make(map[int64]*EthService)
+)
+
+// LegacyChainID is assumed for documents stored before the chain id was
+// recorded; the backend only anchored on Sepolia until then.
+const LegacyChainID int64 = 11155111
+
+// knownExplorers maps chain ids to block explorer tx URL templates.
+var knownExplorers = map[int64]string{
+	1:        "https://etherscan.io/tx/%s",
+	11155111: "https://sepolia.etherscan.io/tx/%s",
+	17000:    "https://holesky.etherscan.io/tx/%s",
+	10:       "https://optimistic.etherscan.io/tx/%s",
+	11155420: "https://sepolia-optimism.etherscan.io/tx/%s",
+	8453:     "https://basescan.org/tx/%s",
+	84532:    "https://sepolia.basescan.org/tx/%s",
+	42161:    "https://arbiscan.io/tx/%s",
+	421614:   "https://sepolia.arbiscan.io/tx/%s",
+	137:      "https://polygonscan.com/tx/%s",
+	80002:    "https://amoy.polygonscan.com/tx/%s",
+}
+
+// RegisterNetwork makes a connected service available by chain id. When it is
+// the default network it also becomes DefaultEth.
+func RegisterNetwork(svc *EthService) {
+	networksMu.Lock()
+	defer networksMu.Unlock()
+	networks[svc.ChainID.Int64()] = svc
+	if svc.Network == defaultNetwork {
+		eth = svc
+	}
+}
+
+// NetworkByChainID returns the service for a chain, or nil if it is not configured.
+func NetworkByChainID(chainID int64) *EthService {
+	networksMu.RLock()
+	defer networksMu.RUnlock()
+	return networks[chainID]
+}
+
+// NetworkByName returns the service registered under name, or nil.
+func NetworkByName(name string) *EthService {
+	networksMu.RLock()
+	defer networksMu.RUnlock()
+	for _, svc := range networks {
+		if svc.Network == name {
+			return svc
+		}
+	}
+	return nil
+}
+
+// AllNetworks lists the connected networks ordered by chain id.
+func AllNetworks() []*EthService {
+	networksMu.RLock()
+	defer networksMu.RUnlock()
+	list := make([]*EthService, 0, len(networks))
+	for _, svc := range networks {
+		list = append(list, svc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ChainID.Cmp(list[j].ChainID) < 0 })
+	return list
+}
+
+// ExplorerTxURL builds a block explorer link for a transaction. A configured
+// network override wins over the built-in table; unknown chains yield "".
+func ExplorerTxURL(chainID int64, txHash string) string {
+	if txHash == "" {
+		return ""
+	}
+	if svc := NetworkByChainID(chainID); svc != nil && svc.ExplorerTxURL != "" {
+		return fmt.Sprintf(svc.ExplorerTxURL, txHash)
+	}
+	if tmpl, ok := knownExplorers[chainID]; ok {
+		return fmt.Sprintf(tmpl, txHash)
+	}
+	return ""
+}
+
+// DocumentChainID returns the chain a document was anchored on.
+func DocumentChainID(meta DocumentMetadata) int64 {
+	if meta.ChainID == 0 {
+		return LegacyChainID
+	}
+	return meta.ChainID
+}
+
+// TxURL is the explorer link for a transaction sent through this service.
+func (e *EthService) TxURL(txHash string) string {
+	return ExplorerTxURL(e.ChainID.Int64(), txHash)
+}
+
+// TxConfirmations returns how many blocks have been mined on top of the block
+// that included txHash (1 when it is in the latest block). mined is false
+// while the transaction is still pending.
+func (e *EthService) TxConfirmations(txHash string) (confirmations uint64, mined bool, err error) {
+	ctx, cancel := e.rpcContext()
+	defer cancel()
+	receipt, err := e.Client.TransactionReceipt(ctx, common.HexToHash(txHash))
+	if errors.Is(err, ethereum.NotFound) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	head, err := e.Client.BlockNumber(ctx)
+	if err != nil {
+		return 0, true, err
+	}
+	if receipt.Status != 1 {
+		return 0, true, fmt.Errorf("transaction %s reverted", txHash)
+	}
+	// A load-balanced RPC may answer from a node behind the one that had the receipt
+	block := receipt.BlockNumber.Uint64()
+	if head < block {
+		return 0, true, nil
+	}
+	return head - block + 1, true, nil
+}
@@ -0,0 +1,24 @@
+package services
+
+import "testing"
+
+func TestExplorerTxURL(t *testing.T) {
+	if got, want := ExplorerTxURL(11155111, "0xabc"), "https://sepolia.etherscan.io/tx/0xabc"; got != want {
+		t.Fatalf("sepolia: got %s want %s", got, want)
+	}
+	if got := ExplorerTxURL(999999, "0xabc"); got != "" {
+		t.Fatalf("unknown chain should have no explorer, got %s", got)
+	}
+	if got := ExplorerTxURL(1, ""); got != "" {
+		t.Fatalf("empty tx should have no explorer, got %s", got)
+	}
+}
+
+func TestDocumentChainIDDefaultsToLegacy(t *testing.T) {
+	if got := DocumentChainID(DocumentMetadata{}); got != LegacyChainID {
+		t.Fatalf("got %d want %d", got, LegacyChainID)
+	}
+	if got := DocumentChainID(DocumentMetadata{ChainID: 8453}); got != 8453 {
+		t.Fatalf("got %d want 8453", got)
+	}
+}
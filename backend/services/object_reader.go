@@ -0,0 +1,203 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var ErrContentHashMismatch = errors.New("stored content does not match the document hash")
+
+// ObjectSize returns the stored size of an object.
+func ObjectSize(objectName string) (int64, error) {
+	bucket, key := objectLocation(objectName)
+	out, err := S3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return aws.Int64Value(out.ContentLength), nil
+}
+
+// getObjectRange opens bytes start..end (inclusive) of an object.
+func getObjectRange(objectName string, start, end int64) (io.ReadCloser, error) {
+	bucket, key := objectLocation(objectName)
+	out, err := S3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// openPlainRange opens plaintext bytes [start, end) of a document object
+// whose stored size is storedSize. Encrypted objects are fetched from the
+// first segment covering start, so only the requested part is decrypted.
+func openPlainRange(objectName string, enc *EncryptionInfo, storedSize, start, end int64) (io.ReadCloser, error) {
+	if enc == nil {
+		return getObjectRange(objectName, start, end-1)
+	}
+
+	headerBody, err := getObjectRange(objectName, 0, int64(encHeaderSize)-1)
+	if err != nil {
+		return nil, err
+	}
+	header, err := io.ReadAll(headerBody)
+	headerBody.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	seg := int64(enc.SegmentSize)
+	full := seg + encTagSize
+	first := start / seg
+	last := (end - 1) / seg
+	from := int64(encHeaderSize) + first*full
+	to := min(int64(encHeaderSize)+(last+1)*full, storedSize) - 1
+
+	body, err := getObjectRange(objectName, from, to)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := enc.segmentReader(header, body, uint32(first), enc.segmentCount(storedSize)-1)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, plain, start-first*seg); err != nil {
+		body.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(plain, end-start), body}, nil
+}
+
+// ObjectReader gives random access to a document object through ranged
+// storage reads, decrypting on the fly. It implements io.ReadSeeker so it can
+// be handed to http.ServeContent, which takes care of Range requests.
+type ObjectReader struct {
+	name       string
+	enc        *EncryptionInfo
+	storedSize int64
+	size       int64
+	offset     int64
+	body       io.ReadCloser // open stream positioned at offset
+}
+
+func OpenObjectReader(objectName string, enc *EncryptionInfo) (*ObjectReader, error) {
+	stored, err := ObjectSize(objectName)
+	if err != nil {
+		return nil, err
+	}
+	size := stored
+	if enc != nil {
+		size = enc.PlainSize(stored)
+	}
+	return &ObjectReader{name: objectName, enc: enc, storedSize: stored, size: size}, nil
+}
+
+// Size is the plaintext size of the object.
+func (r *ObjectReader) Size() int64 { return r.size }
+
+func (r *ObjectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := openPlainRange(r.name, r.enc, r.storedSize, r.offset, r.size)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of object")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *ObjectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// VerifyingReader re-hashes a full read of an object and fails the read that
+// would return the final bytes when the content does not match want, so a
+// tampered object is never delivered complete. Seeking anywhere but the start
+// disables the check (range requests only see part of the content).
+type VerifyingReader struct {
+	*ObjectReader
+	want   string
+	hasher hash.Hash
+	active bool
+}
+
+func NewVerifyingReader(r *ObjectReader, want string) *VerifyingReader {
+	return &VerifyingReader{ObjectReader: r, want: want, hasher: sha256.New(), active: true}
+}
+
+func (v *VerifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ObjectReader.Read(p)
+	if !v.active {
+		return n, err
+	}
+	v.hasher.Write(p[:n])
+	if v.offset == v.size {
+		v.active = false
+		if got := hex.EncodeToString(v.hasher.Sum(nil)); got != v.want {
+			log.Printf("Integrity check failed for %s: hash %s, expected %s\n", v.name, got, v.want)
+			return 0, ErrContentHashMismatch
+		}
+	}
+	return n, err
+}
+
+func (v *VerifyingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := v.ObjectReader.Seek(offset, whence)
+	switch {
+	case err != nil:
+	case pos == 0:
+		v.hasher.Reset()
+		v.active = true
+	case whence != io.SeekEnd:
+		v.active = false
+	}
+	return pos, err
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrOrgNotFound    = errors.New("organization not found")
+	ErrMemberNotFound = errors.New("member not found")
+	ErrLastOwner      = errors.New("an organization needs at least one owner")
+	ErrUnknownRole    = errors.New("unknown role")
+)
+
+// Organization roles.
+const (
+	RoleOwner   = "owner"   // everything, including members, but not holds and retention
+	RoleEditor  = "editor"  // upload, edit, share and delete documents
+	RoleViewer  = "viewer"  // read documents
+	RoleAuditor = "auditor" // read documents plus trash, holds and share links
+)
+
+// Permission is an action on a document.
+type Permission string
+
+const (
+	PermRead   Permission = "read"   // list, preview, download, versions, chat, proof
+	PermWrite  Permission = "write"  // upload, new versions, summaries, share links
+	PermDelete Permission = "delete" // delete, restore and purge
+	PermAudit  Permission = "audit"  // trash, legal holds and share links, read-only
+	PermManage Permission = "manage" // organization members
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleOwner:   {PermRead, PermWrite, PermDelete, PermAudit, PermManage},
+	RoleEditor:  {PermRead, PermWrite, PermDelete, PermAudit},
+	RoleViewer:  {PermRead},
+	RoleAuditor: {PermRead, PermAudit},
+}
+
+// RoleAllows reports whether role grants perm. Unknown roles grant nothing.
+func RoleAllows(role string, perm Permission) bool {
+	return slices.Contains(rolePermissions[role], perm)
+}
+
+// Can reports whether userID may act on a document. Personal documents are
+// accessible to their owner, who may do anything; documents of an
+// organization follow the user's role in it. Legal holds and retention are
+// not a permission: they are changed by the compliance role or with the
+// admin token, so no owner can lift the hold on their own document. Either may also be granted to
+// other users and groups through the document's ACL.
+func Can(meta DocumentMetadata, userID string, perm Permission) bool {
+	if userID == "" {
+		return false
+	}
+	if meta.OrgID == "" && meta.UserID == userID {
+		return true
+	}
+	if meta.OrgID != "" && Orgs != nil && RoleAllows(Orgs.Role(meta.OrgID, userID), perm) {
+		return true
+	}
+	return aclAllows(meta, userID, perm)
+}
+
+// Member is a user's membership in an organization.
+type Member struct {
+	UserID  string    `json:"userId"`
+	Role    string    `json:"role"`
+	AddedBy string    `json:"addedBy,omitempty"`
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// Organization is a shared document vault.
+type Organization struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	Members   []Member  `json:"members"`
+}
+
+func (o Organization) member(userID string) (int, bool) {
+	i := slices.IndexFunc(o.Members, func(m Member) bool { return m.UserID == userID })
+	return i, i >= 0
+}
+
+func (o Organization) owners() int {
+	n := 0
+	for _, m := range o.Members {
+		if m.Role == RoleOwner {
+			n++
+		}
+	}
+	return n
+}
+
+// OrgStore persists organizations to a JSON file, like MetadataStore.
+type OrgStore struct {
+	FilePath string
+	Data     map[string]Organization
+	mu       sync.RWMutex
+}
+
+var Orgs *OrgStore
+
+func InitOrgs(filePath string) error {
+	Orgs = &OrgStore{
+		FilePath: filePath,
+		Data:     make(map[string]Organization),
+	}
+	return Orgs.Load()
+}
+
+func (s *OrgStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.ReadFile(s.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(file, &s.Data)
+}
+
+func (s *OrgStore) Save() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := json.MarshalIndent(s.Data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.FilePath, data, 0644)
+}
+
+// Create makes a new organization owned by userID.
+func (s *OrgStore) Create(name, userID string) (Organization, error) {
+	now := time.Now()
+	org := Organization{
+		ID:        NewRandomID(),
+		Name:      name,
+		CreatedBy: userID,
+		CreatedAt: now,
+		Members:   []Member{{UserID: userID, Role: RoleOwner, AddedBy: userID, AddedAt: now}},
+	}
+	s.mu.Lock()
+	s.Data[org.ID] = org
+	s.mu.Unlock()
+	return org, s.Save()
+}
+
+func (s *OrgStore) Get(id string) (Organization, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	org, ok := s.Data[id]
+	return org, ok
+}
+
+// Role returns the role of userID in an organization, or "" for non-members.
+func (s *OrgStore) Role(orgID, userID string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	org := s.Data[orgID]
+	if i, ok := org.member(userID); ok {
+		return org.Members[i].Role
+	}
+	return ""
+}
+
+// ForUser returns the organizations userID belongs to, by name.
+func (s *OrgStore) ForUser(userID string) []Organization {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []Organization{}
+	for _, org := range s.Data {
+		if _, ok := org.member(userID); ok {
+			list = append(list, org)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// SetMember adds userID to an organization or changes their role. The last
+// owner cannot be demoted.
+func (s *OrgStore) SetMember(orgID, userID, role, addedBy string) (Organization, error) {
+	if _, ok := rolePermissions[role]; !ok {
+		return Organization{}, ErrUnknownRole
+	}
+
+	s.mu.Lock()
+	org, ok := s.Data[orgID]
+	if !ok {
+		s.mu.Unlock()
+		return org, ErrOrgNotFound
+	}
+	members := slices.Clone(org.Members)
+	if i, ok := org.member(userID); ok {
+		if members[i].Role == RoleOwner && role != RoleOwner && org.owners() == 1 {
+			s.mu.Unlock()
+			return org, ErrLastOwner
+		}
+		members[i].Role = role
+	} else {
+		members = append(members, Member{UserID: userID, Role: role, AddedBy: addedBy, AddedAt: time.Now()})
+	}
+	org.Members = members
+	s.Data[orgID] = org
+	s.mu.Unlock()
+	return org, s.Save()
+}
+
+// RemoveMember takes userID out of an organization. The last owner cannot
+// leave.
+func (s *OrgStore) RemoveMember(orgID, userID string) (Organization, error) {
+	s.mu.Lock()
+	org, ok := s.Data[orgID]
+	if !ok {
+		s.mu.Unlock()
+		return org, ErrOrgNotFound
+	}
+	i, ok := org.member(userID)
+	if !ok {
+		s.mu.Unlock()
+		return org, ErrMemberNotFound
+	}
+	if org.Members[i].Role == RoleOwner && org.owners() == 1 {
+		s.mu.Unlock()
+		return org, ErrLastOwner
+	}
+	org.Members = slices.Delete(slices.Clone(org.Members), i, i+1)
+	s.Data[orgID] = org
+	s.mu.Unlock()
+	return org, s.Save()
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestOrgRolesAndDocumentAccess(t *testing.T) {
+	saved := Orgs
+	t.Cleanup(func() { Orgs = saved })
+	Orgs = &OrgStore{FilePath: filepath.Join(t.TempDir(), "orgs.json"), Data: map[string]Organization{}}
+
+	org, err := Orgs.Create("Legal", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Orgs.SetMember(org.ID, "bob", RoleViewer, "alice")
+	Orgs.SetMember(org.ID, "carol", RoleAuditor, "alice")
+
+	s := &MetadataStore{Data: map[string]DocumentMetadata{
+		"1": {ID: "1", UserID: "alice", OrgID: org.ID, Hash: "abc"},
+		"2": {ID: "2", UserID: "alice", Hash: "abc"},
+		"3": {ID: "3", UserID: "alice", OrgID: org.ID, Deleted: true},
+	}}
+
+	vault := s.Data["1"]
+	if !Can(vault, "bob", PermRead) || Can(vault, "bob", PermDelete) || Can(vault, "bob", PermAudit) {
+		t.Error("viewer permissions")
+	}
+	if !Can(vault, "carol", PermAudit) || Can(vault, "carol", PermWrite) {
+		t.Error("auditor permissions")
+	}
+	if Can(vault, "mallory", PermRead) || Can(s.Data["2"], "bob", PermRead) {
+		t.Error("non-member or personal document readable")
+	}
+
+	if docs := s.GetAllForUser("bob"); len(docs) != 1 || docs[0].ID != "1" {
+		t.Errorf("GetAllForUser(bob) = %+v", docs)
+	}
+	if trash := s.Trash("carol"); len(trash) != 1 {
+		t.Errorf("auditor trash = %+v", trash)
+	}
+	if trash := s.Trash("bob"); len(trash) != 0 {
+		t.Errorf("viewer trash = %+v", trash)
+	}
+	// Duplicates are looked up within the owner: the org or the personal vault
+	if got, ok := s.FindByHash("abc", "bob", org.ID); !ok || got.ID != "1" {
+		t.Errorf("FindByHash in org = %q, %v", got.ID, ok)
+	}
+	if got, ok := s.FindByHash("abc", "alice", ""); !ok || got.ID != "2" {
+		t.Errorf("FindByHash personal = %q, %v", got.ID, ok)
+	}
+}
+
+func TestOrgKeepsAnOwner(t *testing.T) {
+	s := &OrgStore{FilePath: filepath.Join(t.TempDir(), "orgs.json"), Data: map[string]Organization{}}
+	org, _ := s.Create("Legal", "alice")
+
+	if _, err := s.SetMember(org.ID, "alice", RoleEditor, "alice"); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("demoting the last owner: %v", err)
+	}
+	if _, err := s.RemoveMember(org.ID, "alice"); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("removing the last owner: %v", err)
+	}
+	if _, err := s.SetMember(org.ID, "bob", "superuser", "alice"); !errors.Is(err, ErrUnknownRole) {
+		t.Errorf("unknown role: %v", err)
+	}
+	s.SetMember(org.ID, "bob", RoleOwner, "alice")
+	if _, err := s.RemoveMember(org.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Role(org.ID, "alice") != "" || s.Role(org.ID, "bob") != RoleOwner {
+		t.Error("membership not updated")
+	}
+}
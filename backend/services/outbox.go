@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	outboxPollInterval = 15 * time.Second
+	outboxBaseBackoff  = 30 * time.Second
+	outboxMaxBackoff   = time.Hour
+)
+
+// OutboxEntry is a blockchain registration that failed or was skipped and
+// still has to be sent.
+type OutboxEntry struct {
+	DocID       string    `json:"docId"`
+	Filename    string    `json:"filename"`
+	Hash        string    `json:"hash"`
+	MinioID     string    `json:"minioId"`
+	Tag         string    `json:"tag"`
+	Version     int       `json:"version,omitempty"` // 0 for a document's first upload
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError,omitempty"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Key identifies the entry; versions of one document are queued separately.
+func (e OutboxEntry) Key() string {
+	if e.Version > 0 {
+		return VersionTextCacheID(e.DocID, e.Version)
+	}
+	return e.DocID
+}
+
+// RegistrationOutbox persists pending registrations to a JSON file so they
+// survive restarts, in the same way MetadataStore keeps document metadata.
+type RegistrationOutbox struct {
+	FilePath string
+	Data     map[string]OutboxEntry
+	mu       sync.RWMutex
+}
+
+var Outbox *RegistrationOutbox
+
+func InitOutbox(filePath string) error {
+	Outbox = &RegistrationOutbox{
+		FilePath: filePath,
+		Data:     make(map[string]OutboxEntry),
+	}
+	return Outbox.Load()
+}
+
+func (o *RegistrationOutbox) Load() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	file, err := os.ReadFile(o.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	return json.Unmarshal(file, &o.Data)
+}
+
+func (o *RegistrationOutbox) Save() error {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	data, err := json.MarshalIndent(o.Data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(o.FilePath, data, 0644)
+}
+
+// Enqueue schedules a registration for the next worker pass.
+func (o *RegistrationOutbox) Enqueue(entry OutboxEntry, reason string) error {
+	now := time.Now()
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = now
+	}
+	entry.LastError = reason
+	entry.NextAttempt = now
+
+	o.mu.Lock()
+	o.Data[entry.Key()] = entry
+	o.mu.Unlock()
+	return o.Save()
+}
+
+func (o *RegistrationOutbox) Remove(key string) error {
+	o.mu.Lock()
+	delete(o.Data, key)
+	o.mu.Unlock()
+	return o.Save()
+}
+
+// List returns all pending entries, oldest first.
+func (o *RegistrationOutbox) List() []OutboxEntry {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	list := make([]OutboxEntry, 0, len(o.Data))
+	for _, e := range o.Data {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// due returns the entries whose next attempt is at or before now.
+func (o *RegistrationOutbox) due(now time.Time) []OutboxEntry {
+	var list []OutboxEntry
+	for _, e := range o.List() {
+		if !e.NextAttempt.After(now) {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// markFailed records a failed attempt and pushes the next one back
+// exponentially, capped at outboxMaxBackoff.
+func (o *RegistrationOutbox) markFailed(entry OutboxEntry, err error) error {
+	entry.Attempts++
+	entry.LastError = err.Error()
+	entry.NextAttempt = time.Now().Add(outboxBackoff(entry.Attempts))
+
+	o.mu.Lock()
+	o.Data[entry.Key()] = entry
+	o.mu.Unlock()
+	return o.Save()
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	d := outboxBaseBackoff
+	for i := 1; i < attempts && d < outboxMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > outboxMaxBackoff {
+		d = outboxMaxBackoff
+	}
+	return d
+}
+
+// StartOutboxWorker retries pending registrations on the default network
+// until ctx is cancelled. Successful registrations update the document's
+// metadata with the transaction and network.
+func StartOutboxWorker(ctx context.Context, privateKey string) {
+	track(func() {
+		ticker := time.NewTicker(outboxPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			Outbox.flush(ctx, privateKey)
+		}
+	})
+}
+
+func (o *RegistrationOutbox) flush(ctx context.Context, privateKey string) {
+	eth := DefaultEth()
+	if eth == nil || privateKey == "" {
+		return
+	}
+
+	for _, entry := range o.due(time.Now()) {
+		if ctx.Err() != nil {
+			// Shutting down; the rest stays queued
+			return
+		}
+		txHash, err := eth.RegisterDocument(privateKey, entry.Filename, entry.Hash, entry.MinioID, entry.Tag)
+		if err != nil {
+			log.Printf("Outbox: registration of %s failed (attempt %d): %v\n", entry.DocID, entry.Attempts+1, err)
+			if saveErr := o.markFailed(entry, err); saveErr != nil {
+				log.Println("Outbox: failed to save:", saveErr)
+			}
+			continue
+		}
+
+		log.Printf("Outbox: document %s registered on %s. Tx: %s\n", entry.Key(), eth.Network, txHash)
+		// Update the current record, not a copy read before registering,
+		// so changes made meanwhile (new versions, deletion) are kept
+		_, err = Store.Update(entry.DocID, func(meta *DocumentMetadata) error {
+			if len(meta.Versions) > 0 {
+				// The first upload became version 1 when a revision was added
+				SetVersionAnchor(meta, max(entry.Version, 1), txHash, eth.Network, eth.ChainID.Int64())
+			} else if meta.Hash == entry.Hash {
+				meta.TxHash = txHash
+				meta.Network = eth.Network
+				meta.ChainID = eth.ChainID.Int64()
+				meta.ChainDocID = ""
+			}
+			if meta.TxHash != "" {
+				meta.VerificationStatus = "Verified"
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrDocumentMissing) {
+			log.Println("Outbox: failed to update metadata:", err)
+		}
+		if err := o.Remove(entry.Key()); err != nil {
+			log.Println("Outbox: failed to save:", err)
+		}
+	}
+}
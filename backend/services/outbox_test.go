@@ -0,0 +1,20 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		20: time.Hour,
+	}
+	for attempts, want := range cases {
+		if got := outboxBackoff(attempts); got != want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
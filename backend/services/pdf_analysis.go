@@ -2,13 +2,23 @@ package services
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+
 	"github.com/ledongthuc/pdf"
 )
 
 // Extrae texto de PDF (sin OCR, si PDF es escaneado usar Tesseract)
 func ExtractTextFromPDFBytes(data []byte) (string, error) {
-	r := bytes.NewReader(data)
-	reader, err := pdf.NewReader(r, int64(len(data)))
+	return ExtractTextFromPDF(bytes.NewReader(data), int64(len(data)))
+}
+
+// ExtractTextFromPDF lee el PDF directamente desde r (acceso aleatorio), sin
+// cargarlo completo en memoria.
+func ExtractTextFromPDF(r io.ReaderAt, size int64) (string, error) {
+	reader, err := pdf.NewReader(r, size)
 	if err != nil {
 		return "", err
 	}
@@ -29,3 +39,26 @@ func ExtractTextFromPDFBytes(data []byte) (string, error) {
 	}
 	return buf.String(), nil
 }
+
+// SpoolToTemp copia r a un archivo temporal cuando la extracción necesita
+// acceso aleatorio y la fuente solo se puede leer secuencialmente (p. ej. un
+// objeto descargado del almacenamiento). Calcula el SHA-256 en la misma
+// pasada. El llamador debe invocar cleanup.
+func SpoolToTemp(r io.Reader) (f *os.File, size int64, hash string, cleanup func(), err error) {
+	f, err = os.CreateTemp("", "cryptodoc-*.spool")
+	if err != nil {
+		return nil, 0, "", func() {}, err
+	}
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	hasher := sha256.New()
+	size, err = io.Copy(io.MultiWriter(f, hasher), r)
+	if err != nil {
+		cleanup()
+		return nil, 0, "", func() {}, err
+	}
+	return f, size, hex.EncodeToString(hasher.Sum(nil)), cleanup, nil
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"main/config"
+)
+
+// Rate-limited actions.
+const (
+	LimitUpload     = "upload"
+	LimitChat       = "chat"
+	LimitRegenerate = "regenerate"
+	// Password attempts on protected share links, counted per share and per
+	// client IP.
+	LimitSharePassword   = "share password"
+	LimitSharePasswordIP = "share password IP"
+)
+
+var (
+	ErrStorageQuota = errors.New("storage quota exceeded")
+	ErrTokenQuota   = errors.New("monthly AI token quota exceeded")
+)
+
+// RateStatus describes a rate limit window after counting a request, for the
+// RateLimit-* response headers.
+type RateStatus struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration // until the window starts over
+}
+
+// Limiter applies the per-minute limits of LimitsConfig. Like API key limits
+// it counts requests in fixed one-minute windows kept in memory.
+type Limiter struct {
+	cfg     config.LimitsConfig
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+var Limits *Limiter
+
+// InitLimits sets the rate limits and quotas, and loads the token usage
+// recorded so far.
+func InitLimits(usagePath string, cfg config.LimitsConfig) error {
+	Limits = &Limiter{cfg: cfg, windows: make(map[string]*rateWindow)}
+	Usage = &UsageStore{FilePath: usagePath, Data: make(map[string]map[string]int64)}
+	return Usage.Load()
+}
+
+func (l *Limiter) limit(action string) int {
+	switch action {
+	case LimitUpload:
+		return l.cfg.UploadsPerMinute
+	case LimitChat:
+		return l.cfg.ChatPerMinute
+	case LimitRegenerate:
+		return l.cfg.RegeneratePerMinute
+	case LimitSharePassword:
+		return l.cfg.SharePasswordPerMinute
+	case LimitSharePasswordIP:
+		return l.cfg.SharePasswordPerIPMinute
+	}
+	return 0
+}
+
+// Allow counts a request of account for action. Actions without a limit are
+// always allowed and report a zero Limit.
+func (l *Limiter) Allow(action, account string, now time.Time) RateStatus {
+	limit := l.limit(action)
+	if limit <= 0 {
+		return RateStatus{Allowed: true}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := action + ":" + account
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= time.Minute {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+		l.pruneLocked(now)
+	}
+	st := RateStatus{Limit: limit, Reset: w.start.Add(time.Minute).Sub(now)}
+	if w.count >= limit {
+		return st
+	}
+	w.count++
+	st.Allowed = true
+	st.Remaining = limit - w.count
+	return st
+}
+
+// pruneLocked drops finished windows so idle accounts do not pile up.
+func (l *Limiter) pruneLocked(now time.Time) {
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= time.Minute {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// StorageQuota returns the quota of a vault: the organization's when orgID is
+// set, the user's otherwise. Zero is unlimited.
+func (l *Limiter) StorageQuota(orgID string) int64 {
+	if orgID != "" {
+		return l.cfg.OrgStorageQuota
+	}
+	return l.cfg.UserStorageQuota
+}
+
+// CheckStorage reports ErrStorageQuota if adding size bytes to the personal
+// vault of userID, or to orgID, would exceed its quota.
+func (l *Limiter) CheckStorage(userID, orgID string, size int64) error {
+	quota := l.StorageQuota(orgID)
+	if quota <= 0 {
+		return nil
+	}
+	if used := Store.StorageUsed(userID, orgID); used+size > quota {
+		return fmt.Errorf("%w: %s of %s used", ErrStorageQuota, FormatBytes(used), FormatBytes(quota))
+	}
+	return nil
+}
+
+// StorageUsed adds up the stored versions of a vault's documents, trash
+// included until purged. Links to another document's object are free.
+func (s *MetadataStore) StorageUsed(userID, orgID string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var used int64
+	for _, m := range s.Data {
+		if m.PurgedAt != nil || m.DuplicateOf != "" || m.OrgID != orgID || (orgID == "" && m.UserID != userID) {
+			continue
+		}
+		if len(m.Versions) == 0 {
+			used += m.StoredBytes()
+		}
+		for _, v := range m.Versions {
+			used += v.StoredBytes()
+		}
+	}
+	return used
+}
+
+// UsageStore persists LLM token usage per user and month to a JSON file.
+type UsageStore struct {
+	FilePath string
+	Data     map[string]map[string]int64 // user -> "2006-01" -> tokens
+	// reserved holds the tokens of calls in flight per user, so concurrent
+	// calls cannot overshoot the quota together. It is not saved.
+	reserved map[string]int64
+	mu       sync.RWMutex
+}
+
+var Usage *UsageStore
+
+func (u *UsageStore) Load() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	file, err := os.ReadFile(u.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(file, &u.Data)
+}
+
+func (u *UsageStore) Save() error {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	data, err := json.MarshalIndent(u.Data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(u.FilePath, data, 0644)
+}
+
+func usageMonth(t time.Time) string { return t.UTC().Format("2006-01") }
+
+// Tokens returns the tokens userID spent in the month of t.
+func (u *UsageStore) Tokens(userID string, t time.Time) int64 {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.Data[userID][usageMonth(t)]
+}
+
+// AddTokens records tokens spent for userID.
+func (u *UsageStore) AddTokens(userID string, tokens int64) error {
+	if userID == "" || tokens <= 0 {
+		return nil
+	}
+	month := usageMonth(time.Now())
+	u.mu.Lock()
+	if u.Data[userID] == nil {
+		u.Data[userID] = make(map[string]int64)
+	}
+	u.Data[userID][month] += tokens
+	u.mu.Unlock()
+	return u.Save()
+}
+
+// TokenQuota is the monthly token quota of every user; zero is unlimited.
+func (l *Limiter) TokenQuota() int64 { return l.cfg.MonthlyTokenQuota }
+
+// ReserveTokens sets aside up to n tokens of userID's monthly quota for a
+// call, or reports ErrTokenQuota when they do not fit in what is left after
+// the calls already in flight. done records the tokens the call used and
+// frees the reservation. Calls without a user, such as background jobs, are
+// not limited.
+func ReserveTokens(userID string, n int64) (done func(used int64) error, err error) {
+	if Usage == nil {
+		return func(int64) error { return nil }, nil
+	}
+	u := Usage
+	if Limits == nil || userID == "" || Limits.TokenQuota() <= 0 {
+		return func(used int64) error { return u.AddTokens(userID, used) }, nil
+	}
+
+	u.mu.Lock()
+	if u.Data[userID][usageMonth(time.Now())]+u.reserved[userID]+n > Limits.TokenQuota() {
+		u.mu.Unlock()
+		return nil, ErrTokenQuota
+	}
+	if u.reserved == nil {
+		u.reserved = make(map[string]int64)
+	}
+	u.reserved[userID] += n
+	u.mu.Unlock()
+
+	return func(used int64) error {
+		u.mu.Lock()
+		if u.reserved[userID] -= n; u.reserved[userID] <= 0 {
+			delete(u.reserved, userID)
+		}
+		u.mu.Unlock()
+		return u.AddTokens(userID, used)
+	}, nil
+}
+
+// NextMonth is when monthly token quotas start over.
+func NextMonth(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"main/config"
+)
+
+func TestLimiterCountsPerAccount(t *testing.T) {
+	l := &Limiter{cfg: config.LimitsConfig{ChatPerMinute: 2}, windows: map[string]*rateWindow{}}
+	now := time.Now()
+
+	for i, want := range []int{1, 0} {
+		if st := l.Allow(LimitChat, "user:alice", now); !st.Allowed || st.Remaining != want {
+			t.Fatalf("request %d: %+v", i+1, st)
+		}
+	}
+	if st := l.Allow(LimitChat, "user:alice", now.Add(10*time.Second)); st.Allowed || st.Reset != 50*time.Second {
+		t.Errorf("third request: %+v", st)
+	}
+	// Other users and the next window are not affected
+	if !l.Allow(LimitChat, "user:bob", now).Allowed || !l.Allow(LimitChat, "user:alice", now.Add(time.Minute)).Allowed {
+		t.Error("limit leaked across accounts or windows")
+	}
+	if st := l.Allow(LimitUpload, "user:alice", now); !st.Allowed || st.Limit != 0 {
+		t.Errorf("unlimited action: %+v", st)
+	}
+}
+
+func TestStorageAndTokenQuotas(t *testing.T) {
+	savedStore, savedLimits, savedUsage := Store, Limits, Usage
+	t.Cleanup(func() { Store, Limits, Usage = savedStore, savedLimits, savedUsage })
+
+	Store = &MetadataStore{Data: map[string]DocumentMetadata{
+		// Exact sizes where recorded, Size read back for older records
+		"1": {ID: "1", UserID: "alice", Size: FormatBytes(3<<20 + 40), SizeBytes: 3<<20 + 40},
+		"2": {ID: "2", UserID: "alice", Versions: []DocumentVersion{{Size: "1.0 MB"}, {Size: FormatBytes(1537), SizeBytes: 1537}}},
+		"3": {ID: "3", UserID: "alice", Size: "3.0 MB", DuplicateOf: "1"},
+		"4": {ID: "4", UserID: "alice", OrgID: "legal", Size: "9.0 MB"},
+	}}
+	if err := InitLimits(filepath.Join(t.TempDir(), "usage.json"), config.LimitsConfig{UserStorageQuota: 5 << 20, MonthlyTokenQuota: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	if used := Store.StorageUsed("alice", ""); used != 4<<20+40+1537 {
+		t.Errorf("StorageUsed = %d", used)
+	}
+	if err := Limits.CheckStorage("alice", "", 1<<20); !errors.Is(err, ErrStorageQuota) {
+		t.Errorf("over quota: %v", err)
+	}
+	if err := Limits.CheckStorage("alice", "legal", 1<<30); err != nil {
+		t.Errorf("organizations have no quota here: %v", err)
+	}
+
+	Usage.AddTokens("alice", 60)
+	done, err := ReserveTokens("alice", 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A concurrent call does not fit next to the reservation
+	if _, err := ReserveTokens("alice", 20); !errors.Is(err, ErrTokenQuota) {
+		t.Errorf("concurrent reservation: %v", err)
+	}
+	if err := done(25); err != nil {
+		t.Fatal(err)
+	}
+	if used := Usage.Tokens("alice", time.Now()); used != 85 {
+		t.Errorf("tokens used = %d, want 85", used)
+	}
+	if _, err := ReserveTokens("alice", 20); !errors.Is(err, ErrTokenQuota) {
+		t.Errorf("token quota: %v", err)
+	}
+	if _, err := ReserveTokens("alice", 15); err != nil {
+		t.Errorf("reservation that fits: %v", err)
+	}
+	if _, err := ReserveTokens("bob", 100); err != nil {
+		t.Error("quota applied to another user:", err)
+	}
+	if _, err := ReserveTokens("", 1000); err != nil {
+		t.Error("quota applied without a user:", err)
+	}
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"main/config"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Integrity check outcomes.
+const (
+	IntegrityOK             = "ok"
+	IntegrityMismatch       = "mismatch"       // stored bytes no longer match the metadata hash
+	IntegrityAnchorMismatch = "anchorMismatch" // metadata hash differs from the one anchored on chain
+	IntegrityMissing        = "missing"        // object not found in storage
+	IntegrityError          = "error"          // could not be checked this time
+	IntegritySkipped        = "skipped"        // archived in a tier that needs a restore
+)
+
+// verificationCompromised is the VerificationStatus of documents whose
+// stored content failed an integrity check.
+const verificationCompromised = "Compromised"
+
+var ErrScrubRunning = errors.New("integrity scrub already running")
+
+// IntegrityCheck is the result of re-hashing one stored object.
+type IntegrityCheck struct {
+	Status       string    `json:"status"`
+	CheckedAt    time.Time `json:"checkedAt"`
+	StoredHash   string    `json:"storedHash,omitempty"`   // SHA-256 of the bytes read back
+	AnchoredHash string    `json:"anchoredHash,omitempty"` // hash in the registration transaction
+	Error        string    `json:"error,omitempty"`
+}
+
+// Failed reports whether the check found tampering, bit rot or a lost object.
+func (c *IntegrityCheck) Failed() bool {
+	if c == nil {
+		return false
+	}
+	return c.Status == IntegrityMismatch || c.Status == IntegrityAnchorMismatch || c.Status == IntegrityMissing
+}
+
+// ScrubReport summarises a scrubbing run.
+type ScrubReport struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Checked    int       `json:"checked"`
+	Failed     []string  `json:"failed"` // document ids
+	Errors     int       `json:"errors"`
+	Skipped    int       `json:"skipped"`
+}
+
+// Scrubber periodically re-downloads stored objects and compares their
+// SHA-256 with the metadata and with the hash anchored on chain.
+type Scrubber struct {
+	cfg     config.ScrubConfig
+	running sync.Mutex
+	mu      sync.RWMutex
+	last    *ScrubReport
+}
+
+var Scrub *Scrubber
+
+func InitScrubber(cfg config.ScrubConfig) {
+	Scrub = &Scrubber{cfg: cfg}
+}
+
+// LastReport returns the report of the last finished run, if any.
+func (s *Scrubber) LastReport() *ScrubReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.last
+}
+
+// Start runs the scrubber every interval until ctx is cancelled. Nothing
+// runs when the interval is zero.
+func (s *Scrubber) Start(ctx context.Context) {
+	if s.cfg.Interval <= 0 {
+		return
+	}
+	track(func() {
+		ticker := time.NewTicker(s.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := s.Run(ctx); err != nil {
+				log.Println("Scrubber:", err)
+			}
+		}
+	})
+}
+
+// Run checks every live document once.
+func (s *Scrubber) Run(ctx context.Context) (*ScrubReport, error) {
+	if !s.running.TryLock() {
+		return nil, ErrScrubRunning
+	}
+	defer s.running.Unlock()
+	return s.run(ctx), nil
+}
+
+// RunInBackground starts a full run and returns right away, or returns
+// ErrScrubRunning if one is already in progress.
+func (s *Scrubber) RunInBackground() error {
+	if !s.running.TryLock() {
+		return ErrScrubRunning
+	}
+	Go(func(ctx context.Context) {
+		defer s.running.Unlock()
+		s.run(ctx)
+	})
+	return nil
+}
+
+func (s *Scrubber) run(ctx context.Context) *ScrubReport {
+	report := &ScrubReport{StartedAt: time.Now(), Failed: []string{}}
+	for _, meta := range Store.GetAll() {
+		if ctx.Err() != nil {
+			break
+		}
+		checked, err := s.ScrubDocument(ctx, meta.ID)
+		if err != nil {
+			log.Printf("Scrubber: document %s: %v\n", meta.ID, err)
+			report.Errors++
+			continue
+		}
+		report.Checked++
+		switch {
+		case checked.Integrity.Failed():
+			report.Failed = append(report.Failed, meta.ID)
+		case checked.Integrity != nil && checked.Integrity.Status == IntegrityError:
+			report.Errors++
+		case checked.Integrity != nil && checked.Integrity.Status == IntegritySkipped:
+			report.Skipped++
+		}
+	}
+	report.FinishedAt = time.Now()
+
+	s.mu.Lock()
+	s.last = report
+	s.mu.Unlock()
+	log.Printf("Scrubber: checked %d documents, %d failed, %d errors, %d skipped\n",
+		report.Checked, len(report.Failed), report.Errors, report.Skipped)
+	return report
+}
+
+// ScrubDocument checks every version of one document and alerts on new
+// failures.
+func (s *Scrubber) ScrubDocument(ctx context.Context, id string) (DocumentMetadata, error) {
+	meta, ok := Store.Get(id)
+	if !ok || meta.PurgedAt != nil {
+		return meta, ErrDocumentMissing
+	}
+
+	var checks map[int]*IntegrityCheck
+	if len(meta.Versions) == 0 {
+		checks = map[int]*IntegrityCheck{0: s.check(ctx, currentVersionOf(meta))}
+	} else {
+		checks = make(map[int]*IntegrityCheck, len(meta.Versions))
+		for _, v := range meta.Versions {
+			checks[v.Number] = s.check(ctx, v)
+		}
+	}
+
+	updated, newlyFailed, err := Store.recordIntegrity(id, checks)
+	if err != nil {
+		return updated, err
+	}
+	for number, check := range newlyFailed {
+		s.alert(updated, number, check)
+	}
+	return updated, nil
+}
+
+// currentVersionOf describes a document without version history.
+func currentVersionOf(meta DocumentMetadata) DocumentVersion {
+	return DocumentVersion{Name: meta.Name, Hash: meta.Hash, MinioID: meta.MinioID, Encryption: meta.Encryption,
+		TxHash: meta.TxHash, Network: meta.Network, ChainID: meta.ChainID}
+}
+
+// check re-hashes one version and compares it with its anchor.
+func (s *Scrubber) check(ctx context.Context, v DocumentVersion) *IntegrityCheck {
+	check := &IntegrityCheck{CheckedAt: time.Now()}
+	if !objectReadable(v.MinioID) {
+		check.Status = IntegritySkipped
+		return check
+	}
+
+	body, _, err := OpenDocumentObject(v.MinioID, v.Encryption)
+	if err != nil {
+		check.Status, check.Error = classifyReadError(err)
+		return check
+	}
+	hash, _, err := Sha256Reader(&rateLimitedReader{ctx: ctx, r: body, rate: s.cfg.RateLimit, start: time.Now()})
+	body.Close()
+	if err != nil {
+		check.Status, check.Error = classifyReadError(err)
+		return check
+	}
+	check.StoredHash = hash
+	if hash != v.Hash {
+		check.Status = IntegrityMismatch
+		return check
+	}
+
+	if v.TxHash != "" {
+		chainID := DocumentChainID(DocumentMetadata{ChainID: v.ChainID, Network: v.Network})
+		if eth := NetworkByChainID(chainID); eth != nil {
+			anchored, err := eth.RegisteredHash(v.TxHash)
+			if err != nil {
+				check.Status, check.Error = IntegrityError, "anchor lookup: "+err.Error()
+				return check
+			}
+			check.AnchoredHash = anchored
+			if anchored != v.Hash {
+				check.Status = IntegrityAnchorMismatch
+				return check
+			}
+		}
+	}
+	check.Status = IntegrityOK
+	return check
+}
+
+func classifyReadError(err error) (string, string) {
+	var aerr awserr.Error
+	switch {
+	case errors.As(err, &aerr) && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound"):
+		return IntegrityMissing, err.Error()
+	case errors.Is(err, ErrSegmentAuth):
+		return IntegrityMismatch, err.Error()
+	default:
+		return IntegrityError, err.Error()
+	}
+}
+
+// recordIntegrity stores check results on a document and returns the
+// checks that failed when the previous one did not, so alerts fire once.
+func (s *MetadataStore) recordIntegrity(id string, checks map[int]*IntegrityCheck) (DocumentMetadata, map[int]*IntegrityCheck, error) {
+	newlyFailed := make(map[int]*IntegrityCheck)
+
+	s.mu.Lock()
+	meta, ok := s.Data[id]
+	if !ok {
+		s.mu.Unlock()
+		return meta, nil, ErrDocumentMissing
+	}
+	// The document shows its worst version
+	var worst *IntegrityCheck
+	consider := func(number int, prev, check *IntegrityCheck) {
+		if check.Failed() && !prev.Failed() {
+			newlyFailed[number] = check
+		}
+		if worst == nil || integrityRank(check) > integrityRank(worst) {
+			worst = check
+		}
+	}
+	if len(meta.Versions) == 0 {
+		if check, ok := checks[0]; ok {
+			consider(0, meta.Integrity, check)
+		}
+	}
+	for i, v := range meta.Versions {
+		if check, ok := checks[v.Number]; ok {
+			consider(v.Number, v.Integrity, check)
+			meta.Versions[i].Integrity = check
+		}
+	}
+	if worst != nil {
+		meta.Integrity = worst
+	}
+
+	switch {
+	case meta.Integrity.Failed():
+		meta.VerificationStatus = verificationCompromised
+	case meta.VerificationStatus == verificationCompromised && meta.Integrity != nil && meta.Integrity.Status == IntegrityOK:
+		// Restored from a backup: back to what the anchor says
+		meta.VerificationStatus = "Unverified"
+		if meta.TxHash != "" {
+			meta.VerificationStatus = "Verified"
+		}
+	}
+	s.Data[id] = meta
+	s.mu.Unlock()
+	return meta, newlyFailed, s.Save()
+}
+
+func integrityRank(c *IntegrityCheck) int {
+	switch {
+	case c.Failed():
+		return 3
+	case c.Status == IntegrityError:
+		return 2
+	case c.Status == IntegritySkipped:
+		return 1
+	}
+	return 0
+}
+
+// alert reports a failed check in the log and to the alert webhook.
+func (s *Scrubber) alert(meta DocumentMetadata, version int, check *IntegrityCheck) {
+	log.Printf("ALERT: integrity check of document %s version %d: %s (expected %s, stored %s, anchored %s)\n",
+		meta.ID, version, check.Status, meta.Hash, check.StoredHash, check.AnchoredHash)
+	if s.cfg.AlertWebhook == "" {
+		return
+	}
+
+	payload, _ := json.Marshal(map[string]any{
+		"event":      "integrity." + check.Status,
+		"documentId": meta.ID,
+		"version":    version,
+		"name":       meta.Name,
+		"hash":       meta.Hash,
+		"check":      check,
+	})
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(s.cfg.AlertWebhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Println("Scrubber: alert webhook failed:", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Println("Scrubber: alert webhook answered", resp.Status)
+	}
+}
+
+// rateLimitedReader sleeps as needed to keep the average throughput under
+// rate bytes per second, and stops when ctx is cancelled.
+type rateLimitedReader struct {
+	ctx   context.Context
+	r     io.Reader
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	if err := l.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if l.rate > 0 && int64(len(p)) > l.rate {
+		p = p[:l.rate]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.rate > 0 {
+		due := time.Duration(float64(l.n) / float64(l.rate) * float64(time.Second))
+		if wait := due - time.Since(l.start); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-l.ctx.Done():
+				return n, l.ctx.Err()
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordIntegrityFlagsAndRecovers(t *testing.T) {
+	s := &MetadataStore{FilePath: filepath.Join(t.TempDir(), "metadata.json"), Data: map[string]DocumentMetadata{
+		"1": {ID: "1", TxHash: "0x1", VerificationStatus: "Verified", Versions: []DocumentVersion{{Number: 1}, {Number: 2}}},
+	}}
+
+	bad := map[int]*IntegrityCheck{
+		1: {Status: IntegrityOK},
+		2: {Status: IntegrityMismatch, StoredHash: "beef"},
+	}
+	meta, newlyFailed, err := s.recordIntegrity("1", bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.VerificationStatus != verificationCompromised || meta.Integrity.Status != IntegrityMismatch {
+		t.Fatalf("after mismatch: status %q, integrity %+v", meta.VerificationStatus, meta.Integrity)
+	}
+	if len(newlyFailed) != 1 || newlyFailed[2] == nil {
+		t.Fatalf("newlyFailed = %v", newlyFailed)
+	}
+
+	// The same failure again does not alert twice
+	if _, newlyFailed, _ = s.recordIntegrity("1", bad); len(newlyFailed) != 0 {
+		t.Errorf("repeated failure alerted again: %v", newlyFailed)
+	}
+
+	// Restored from a backup
+	meta, _, _ = s.recordIntegrity("1", map[int]*IntegrityCheck{1: {Status: IntegrityOK}, 2: {Status: IntegrityOK}})
+	if meta.VerificationStatus != "Verified" || meta.Integrity.Status != IntegrityOK {
+		t.Errorf("after recovery: status %q, integrity %+v", meta.VerificationStatus, meta.Integrity)
+	}
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	data := make([]byte, 3000)
+	r := &rateLimitedReader{ctx: context.Background(), r: bytes.NewReader(data), rate: 10000, start: time.Now()}
+	start := time.Now()
+	n, err := io.Copy(io.Discard, r)
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("copied %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("3000 bytes at 10000 B/s took %s", elapsed)
+	}
+}
+
+// A second run is refused up front instead of in the background goroutine
+func TestScrubRefusesConcurrentRuns(t *testing.T) {
+	s := &Scrubber{}
+	s.running.Lock()
+	if err := s.RunInBackground(); !errors.Is(err, ErrScrubRunning) {
+		t.Errorf("RunInBackground = %v", err)
+	}
+	if _, err := s.Run(context.Background()); !errors.Is(err, ErrScrubRunning) {
+		t.Errorf("Run = %v", err)
+	}
+}
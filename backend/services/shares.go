@@ -0,0 +1,288 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrShareNotFound  = errors.New("share link not found")
+	ErrShareRevoked   = errors.New("share link revoked")
+	ErrShareExpired   = errors.New("share link expired")
+	ErrShareExhausted = errors.New("share link download limit reached")
+	ErrSharePassword  = errors.New("share link password required or wrong")
+)
+
+// Share is a link that gives someone without an account access to one
+// document version until it expires, runs out of downloads or is revoked.
+type Share struct {
+	ID           string     `json:"id"`
+	DocID        string     `json:"docId"`
+	Version      int        `json:"version,omitempty"` // 0 follows the current version
+	Label        string     `json:"label,omitempty"`
+	CreatedBy    string     `json:"createdBy,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	MaxDownloads int        `json:"maxDownloads,omitempty"` // 0 for unlimited
+	Downloads    int        `json:"downloads"`
+	PasswordHash string     `json:"passwordHash,omitempty"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+	URL          string     `json:"url,omitempty"` // filled in responses, not stored
+}
+
+// Status explains why a share can no longer be used, or returns nil.
+func (s Share) Status(now time.Time) error {
+	switch {
+	case s.RevokedAt != nil:
+		return ErrShareRevoked
+	case now.After(s.ExpiresAt):
+		return ErrShareExpired
+	case s.MaxDownloads > 0 && s.Downloads >= s.MaxDownloads:
+		return ErrShareExhausted
+	}
+	return nil
+}
+
+func (s Share) HasPassword() bool { return s.PasswordHash != "" }
+
+// MarshalJSON hides the password hash from API responses.
+func (s Share) MarshalJSON() ([]byte, error) {
+	type share Share
+	out := struct {
+		share
+		PasswordHash string `json:"passwordHash,omitempty"`
+		Password     bool   `json:"passwordProtected"`
+	}{share: share(s), Password: s.HasPassword()}
+	return json.Marshal(out)
+}
+
+// Token is the value put in share URLs: the share id plus an HMAC, so ids
+// cannot be guessed or enumerated.
+func (s Share) Token() string {
+	return s.ID + "." + shareTokenMAC(s.ID)
+}
+
+func shareTokenMAC(id string) string {
+	mac := hmac.New(sha256.New, contentSigningKey)
+	mac.Write([]byte("share\n" + id))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// ShareIDFromToken checks a share token's signature and returns the share id.
+func ShareIDFromToken(token string) (string, bool) {
+	id, mac, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(shareTokenMAC(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+// ShareSession returns the cookie value that lets the holder of a protected
+// share's password download through the link until ttl runs out. Downloads
+// still go through Use, so revocation and MaxDownloads apply.
+func ShareSession(id string, ttl time.Duration) string {
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return expires + "." + shareSessionMAC(id, expires)
+}
+
+func shareSessionMAC(id, expires string) string {
+	mac := hmac.New(sha256.New, contentSigningKey)
+	mac.Write([]byte("share-session\n" + id + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyShareSession checks a value made by ShareSession for share id.
+func VerifyShareSession(id, value string) bool {
+	expires, mac, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(shareSessionMAC(id, expires)))
+}
+
+// ShareStore persists share links to a JSON file, like MetadataStore. Tokens
+// are derived from the share id with the signing key, so PREVIEW_SIGNING_KEY
+// must be stable for links to survive restarts.
+type ShareStore struct {
+	FilePath string
+	Data     map[string]Share
+	mu       sync.RWMutex
+}
+
+var Shares *ShareStore
+
+func InitShares(filePath string) error {
+	Shares = &ShareStore{
+		FilePath: filePath,
+		Data:     make(map[string]Share),
+	}
+	return Shares.Load()
+}
+
+func (s *ShareStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.ReadFile(s.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	return json.Unmarshal(file, &s.Data)
+}
+
+func (s *ShareStore) Save() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Shares cannot be marshalled through Share.MarshalJSON here: it drops
+	// the password hash, which the file must keep.
+	type stored Share
+	data := make(map[string]stored, len(s.Data))
+	for id, share := range s.Data {
+		data[id] = stored(share)
+	}
+	out, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.FilePath, out, 0644)
+}
+
+// Create stores a new share, hashing password if one is given.
+func (s *ShareStore) Create(share Share, password string) (Share, error) {
+	share.ID = NewRandomID()
+	share.CreatedAt = time.Now()
+	share.URL = ""
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return Share{}, err
+		}
+		share.PasswordHash = string(hash)
+	}
+
+	s.mu.Lock()
+	s.Data[share.ID] = share
+	s.mu.Unlock()
+	return share, s.Save()
+}
+
+func (s *ShareStore) Get(id string) (Share, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	share, ok := s.Data[id]
+	return share, ok
+}
+
+// ListForDocument returns the shares of a document, newest first. Unless all
+// is set, only shares that can still be used are returned.
+func (s *ShareStore) ListForDocument(docID string, all bool) []Share {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	list := []Share{}
+	for _, share := range s.Data {
+		if share.DocID != docID || (!all && share.Status(now) != nil) {
+			continue
+		}
+		list = append(list, share)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// Revoke disables a share of the given document.
+func (s *ShareStore) Revoke(docID, id string) (Share, error) {
+	s.mu.Lock()
+	share, ok := s.Data[id]
+	if !ok || share.DocID != docID {
+		s.mu.Unlock()
+		return Share{}, ErrShareNotFound
+	}
+	if share.RevokedAt == nil {
+		now := time.Now()
+		share.RevokedAt = &now
+		s.Data[id] = share
+	}
+	s.mu.Unlock()
+	return share, s.Save()
+}
+
+// DeleteForDocument removes every share of a document.
+func (s *ShareStore) DeleteForDocument(docID string) error {
+	s.mu.Lock()
+	for id, share := range s.Data {
+		if share.DocID == docID {
+			delete(s.Data, id)
+		}
+	}
+	s.mu.Unlock()
+	return s.Save()
+}
+
+// Authorize checks that the share is usable and, for protected shares, that
+// password matches, then calls Use.
+func (s *ShareStore) Authorize(id, password string, count bool) (Share, error) {
+	share, ok := s.Get(id)
+	if !ok {
+		return Share{}, ErrShareNotFound
+	}
+	if err := share.Status(time.Now()); err != nil {
+		return share, err
+	}
+	// bcrypt is slow on purpose; compare outside the lock
+	if share.HasPassword() && bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+		return share, ErrSharePassword
+	}
+	return s.Use(id, count)
+}
+
+// Use checks that the share is usable without asking for its password, for
+// callers that verified it already. When count is set a download is
+// recorded, atomically with the limit check so concurrent requests cannot
+// exceed MaxDownloads.
+func (s *ShareStore) Use(id string, count bool) (Share, error) {
+	if !count {
+		share, ok := s.Get(id)
+		if !ok {
+			return Share{}, ErrShareNotFound
+		}
+		return share, share.Status(time.Now())
+	}
+
+	s.mu.Lock()
+	share, ok := s.Data[id]
+	if !ok {
+		s.mu.Unlock()
+		return Share{}, ErrShareNotFound
+	}
+	if err := share.Status(time.Now()); err != nil {
+		s.mu.Unlock()
+		return share, err
+	}
+	share.Downloads++
+	s.Data[id] = share
+	s.mu.Unlock()
+	return share, s.Save()
+}
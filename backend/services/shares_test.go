@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestShares(t *testing.T) *ShareStore {
+	t.Helper()
+	InitContentSigning("test-key")
+	s := &ShareStore{FilePath: filepath.Join(t.TempDir(), "shares.json"), Data: map[string]Share{}}
+	return s
+}
+
+func TestShareToken(t *testing.T) {
+	s := newTestShares(t)
+	share, err := s.Create(Share{DocID: "doc", ExpiresAt: time.Now().Add(time.Hour)}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, ok := ShareIDFromToken(share.Token())
+	if !ok || id != share.ID {
+		t.Fatalf("ShareIDFromToken = %q, %v", id, ok)
+	}
+	if _, ok := ShareIDFromToken(share.ID + ".00000000000000000000000000000000"); ok {
+		t.Error("forged token accepted")
+	}
+	if _, ok := ShareIDFromToken(share.ID); ok {
+		t.Error("token without signature accepted")
+	}
+}
+
+func TestShareAuthorize(t *testing.T) {
+	s := newTestShares(t)
+	share, _ := s.Create(Share{DocID: "doc", ExpiresAt: time.Now().Add(time.Hour), MaxDownloads: 2}, "secret")
+
+	if _, err := s.Authorize(share.ID, "wrong", true); !errors.Is(err, ErrSharePassword) {
+		t.Fatalf("wrong password: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Authorize(share.ID, "secret", true); err != nil {
+			t.Fatalf("download %d: %v", i+1, err)
+		}
+	}
+	if _, err := s.Authorize(share.ID, "secret", true); !errors.Is(err, ErrShareExhausted) {
+		t.Fatalf("third download: %v", err)
+	}
+
+	// Counts and the password hash survive a reload
+	reloaded := &ShareStore{FilePath: s.FilePath, Data: map[string]Share{}}
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := reloaded.Get(share.ID)
+	if got.Downloads != 2 || !got.HasPassword() {
+		t.Errorf("reloaded share = %+v", got)
+	}
+}
+
+func TestShareRevokeAndExpiry(t *testing.T) {
+	s := newTestShares(t)
+	live, _ := s.Create(Share{DocID: "doc", ExpiresAt: time.Now().Add(time.Hour)}, "")
+	expired, _ := s.Create(Share{DocID: "doc", ExpiresAt: time.Now().Add(-time.Minute)}, "")
+
+	if _, err := s.Revoke("other", live.ID); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("revoke through another document: %v", err)
+	}
+	if _, err := s.Revoke("doc", live.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authorize(live.ID, "", false); !errors.Is(err, ErrShareRevoked) {
+		t.Errorf("revoked share: %v", err)
+	}
+	if _, err := s.Authorize(expired.ID, "", false); !errors.Is(err, ErrShareExpired) {
+		t.Errorf("expired share: %v", err)
+	}
+	if n := len(s.ListForDocument("doc", false)); n != 0 {
+		t.Errorf("active shares = %d, want 0", n)
+	}
+	if n := len(s.ListForDocument("doc", true)); n != 2 {
+		t.Errorf("all shares = %d, want 2", n)
+	}
+}
+
+func TestShareSession(t *testing.T) {
+	s := newTestShares(t)
+	share, _ := s.Create(Share{DocID: "doc", ExpiresAt: time.Now().Add(time.Hour), MaxDownloads: 1}, "secret")
+	other, _ := s.Create(Share{DocID: "doc", ExpiresAt: time.Now().Add(time.Hour)}, "secret")
+
+	session := ShareSession(share.ID, time.Minute)
+	if !VerifyShareSession(share.ID, session) {
+		t.Fatal("session rejected")
+	}
+	if VerifyShareSession(other.ID, session) {
+		t.Error("session accepted for another share")
+	}
+	if VerifyShareSession(share.ID, ShareSession(share.ID, -time.Minute)) {
+		t.Error("expired session accepted")
+	}
+
+	// A session skips the password but not the download limit or revocation
+	if _, err := s.Use(share.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Use(share.ID, true); !errors.Is(err, ErrShareExhausted) {
+		t.Errorf("second download: %v", err)
+	}
+	s.Revoke("doc", other.ID)
+	if _, err := s.Use(other.ID, false); !errors.Is(err, ErrShareRevoked) {
+		t.Errorf("revoked share: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+var (
+	// workers counts the background goroutines Shutdown waits for.
+	workers sync.WaitGroup
+	// background is cancelled when Shutdown starts, stopping work that is not
+	// tied to the context of a StartX function.
+	background, stopBackground = context.WithCancel(context.Background())
+)
+
+// track runs fn in a goroutine Shutdown waits for.
+func track(fn func()) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		fn()
+	}()
+}
+
+// Go runs fn in the background, e.g. work a handler starts before
+// answering. Its context is cancelled when the server shuts down, and
+// Shutdown waits for fn to return.
+func Go(fn func(ctx context.Context)) {
+	track(func() { fn(background) })
+}
+
+// Shutdown runs once the HTTP server has drained: it stops background work
+// and waits for runs in progress (outbox registrations, scrubs, anchoring)
+// until ctx expires, then saves the metadata store and closes the Ethereum
+// clients.
+func Shutdown(ctx context.Context) error {
+	stopBackground()
+	var errs []error
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("background work still running: %w", ctx.Err()))
+	}
+
+	if Store != nil {
+		if err := Store.Save(); err != nil {
+			errs = append(errs, fmt.Errorf("save metadata: %w", err))
+		}
+	}
+	for _, svc := range AllNetworks() {
+		svc.Client.Close()
+	}
+	log.Println("Background workers stopped, connections closed")
+	return errors.Join(errs...)
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShutdownWaitsForWorkers(t *testing.T) {
+	saved := Store
+	t.Cleanup(func() { Store = saved })
+	path := filepath.Join(t.TempDir(), "metadata.json")
+	Store = &MetadataStore{FilePath: path, Data: map[string]DocumentMetadata{"1": {ID: "1"}}}
+
+	finished := make(chan struct{})
+	Go(func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		close(finished)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Fatal("Shutdown returned before the worker finished")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("metadata not saved: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedContentURL(t *testing.T) {
+	InitContentSigning("test-key")
+
+	u, err := url.Parse(SignedContentURL("42", 2, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u.Path, "/documents/42/content") {
+		t.Fatalf("unexpected path %s", u.Path)
+	}
+	q := u.Query()
+	if !VerifyContentSignature("42", 2, q.Get("expires"), q.Get("sig")) {
+		t.Error("valid signature rejected")
+	}
+	if VerifyContentSignature("43", 2, q.Get("expires"), q.Get("sig")) {
+		t.Error("signature accepted for another document")
+	}
+	if VerifyContentSignature("42", 1, q.Get("expires"), q.Get("sig")) {
+		t.Error("signature accepted for another version")
+	}
+
+	expired, _ := url.Parse(SignedContentURL("42", 0, -time.Minute))
+	if VerifyContentSignature("42", 0, expired.Query().Get("expires"), expired.Query().Get("sig")) {
+		t.Error("expired signature accepted")
+	}
+}
@@ -1,8 +1,11 @@
 package services
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"log"
 	"main/config"
 	"time"
 
@@ -10,11 +13,16 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 var (
 	S3Client      *s3.S3
 	StorageBucket string
+	uploader      *s3manager.Uploader
+	// objectLockMode is the S3 Object Lock retention mode, or "" when the
+	// bucket does not support Object Lock.
+	objectLockMode string
 )
 
 func InitStorage(cfg config.S3Config) {
@@ -32,30 +40,296 @@ func InitStorage(cfg config.S3Config) {
 	}
 
 	S3Client = s3.New(sess)
+	uploader = s3manager.NewUploaderWithClient(S3Client, func(u *s3manager.Uploader) {
+		if cfg.PartSize >= s3manager.MinUploadPartSize {
+			u.PartSize = cfg.PartSize
+		}
+		if cfg.Concurrency > 0 {
+			u.Concurrency = cfg.Concurrency
+		}
+	})
 	fmt.Println("S3 Storage initialized with endpoint:", cfg.Endpoint)
+
+	if cfg.ObjectLockMode != "" {
+		lock, err := S3Client.GetObjectLockConfiguration(&s3.GetObjectLockConfigurationInput{
+			Bucket: aws.String(StorageBucket),
+		})
+		if err != nil || lock.ObjectLockConfiguration == nil || aws.StringValue(lock.ObjectLockConfiguration.ObjectLockEnabled) != s3.ObjectLockEnabledEnabled {
+			log.Printf("Warning: bucket %s does not have Object Lock enabled, holds and retention are only enforced by the API: %v\n", StorageBucket, err)
+		} else {
+			objectLockMode = cfg.ObjectLockMode
+			log.Printf("S3 Object Lock enabled in %s mode\n", objectLockMode)
+		}
+	}
 }
 
-// UploadObject uploads a file to S3
-func UploadObject(name string, data []byte, contentType string) error {
-	_, err := S3Client.PutObject(&s3.PutObjectInput{
+// ObjectLockEnabled reports whether holds and retention are also applied with
+// S3 Object Lock.
+func ObjectLockEnabled() bool {
+	return objectLockMode != ""
+}
+
+// SetObjectLegalHold turns the Object Lock legal hold of an object on or off.
+func SetObjectLegalHold(objectName string, on bool) error {
+	bucket, key := objectLocation(objectName)
+	status := s3.ObjectLockLegalHoldStatusOff
+	if on {
+		status = s3.ObjectLockLegalHoldStatusOn
+	}
+	_, err := S3Client.PutObjectLegalHold(&s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		LegalHold: &s3.ObjectLockLegalHold{Status: aws.String(status)},
+	})
+	return err
+}
+
+// SetObjectRetention keeps an object until the given time. In COMPLIANCE
+// mode nobody, including the bucket owner, can shorten it afterwards.
+func SetObjectRetention(objectName string, until time.Time) error {
+	bucket, key := objectLocation(objectName)
+	_, err := S3Client.PutObjectRetention(&s3.PutObjectRetentionInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Retention: &s3.ObjectLockRetention{
+			Mode:            aws.String(objectLockMode),
+			RetainUntilDate: aws.Time(until),
+		},
+	})
+	return err
+}
+
+// UploadObjectStream pipes r to storage with a multipart upload, so memory use
+// is bounded by the part size instead of the file size. It returns the SHA-256
+// and size of the bytes actually sent.
+func UploadObjectStream(name string, r io.Reader, contentType string) (string, int64, error) {
+	hasher := sha256.New()
+	counter := &countingReader{r: io.TeeReader(r, hasher)}
+
+	_, err := uploader.Upload(&s3manager.UploadInput{
 		Bucket:      aws.String(StorageBucket),
 		Key:         aws.String(name),
-		Body:        bytes.NewReader(data),
+		Body:        counter,
 		ContentType: aws.String(contentType),
 	})
-	return err
+	if err != nil {
+		return "", counter.n, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), counter.n, nil
 }
 
-// GetPresignedURL generates a signed URL for a file (valid for 1 hour)
-func GetPresignedURL(objectName string) (string, error) {
-	req, _ := S3Client.GetObjectRequest(&s3.GetObjectInput{
-		Bucket: aws.String(StorageBucket),
-		Key:    aws.String(objectName),
+// UploadDocumentObject stores document content. When enc is set the object is
+// encrypted with its data key; the returned hash and size are always those of
+// the plaintext, which is what gets anchored on chain.
+func UploadDocumentObject(name string, r io.Reader, contentType string, enc *EncryptionInfo) (string, int64, error) {
+	if enc == nil {
+		return UploadObjectStream(name, r, contentType)
+	}
+
+	hasher := sha256.New()
+	plain := &countingReader{r: io.TeeReader(r, hasher)}
+	sealed, err := enc.EncryptReader(plain)
+	if err != nil {
+		return "", 0, err
+	}
+
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(StorageBucket),
+		Key:         aws.String(name),
+		Body:        sealed,
+		ContentType: aws.String("application/octet-stream"),
+	})
+	if err != nil {
+		return "", plain.n, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), plain.n, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// PresignPutURL returns a URL the client can PUT the object to directly.
+func PresignPutURL(objectName, contentType string, ttl time.Duration) (string, error) {
+	req, _ := S3Client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(StorageBucket),
+		Key:         aws.String(objectName),
+		ContentType: aws.String(contentType),
 	})
+	return req.Presign(ttl)
+}
 
-	urlStr, err := req.Presign(1 * time.Hour)
+// CreateMultipartUpload starts a multipart upload and returns its upload id.
+func CreateMultipartUpload(objectName, contentType string) (string, error) {
+	out, err := S3Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(StorageBucket),
+		Key:         aws.String(objectName),
+		ContentType: aws.String(contentType),
+	})
 	if err != nil {
 		return "", err
 	}
-	return urlStr, nil
+	return aws.StringValue(out.UploadId), nil
+}
+
+// PresignUploadPartURL returns a URL the client can PUT one part to.
+func PresignUploadPartURL(objectName, uploadID string, partNumber int64, ttl time.Duration) (string, error) {
+	req, _ := S3Client.UploadPartRequest(&s3.UploadPartInput{
+		Bucket:     aws.String(StorageBucket),
+		Key:        aws.String(objectName),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(partNumber),
+	})
+	return req.Presign(ttl)
+}
+
+// UploadedPart is a part already received by storage in a multipart upload.
+type UploadedPart struct {
+	PartNumber int64  `json:"partNumber"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size"`
+}
+
+// ListUploadedParts lists the parts received so far, so clients can resume.
+func ListUploadedParts(objectName, uploadID string) ([]UploadedPart, error) {
+	var parts []UploadedPart
+	err := S3Client.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(StorageBucket),
+		Key:      aws.String(objectName),
+		UploadId: aws.String(uploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, p := range page.Parts {
+			parts = append(parts, UploadedPart{
+				PartNumber: aws.Int64Value(p.PartNumber),
+				ETag:       aws.StringValue(p.ETag),
+				Size:       aws.Int64Value(p.Size),
+			})
+		}
+		return true
+	})
+	return parts, err
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the final object.
+func CompleteMultipartUpload(objectName, uploadID string, parts []UploadedPart) error {
+	completed := make([]*s3.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		completed = append(completed, &s3.CompletedPart{
+			PartNumber: aws.Int64(p.PartNumber),
+			ETag:       aws.String(p.ETag),
+		})
+	}
+	_, err := S3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(StorageBucket),
+		Key:             aws.String(objectName),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	return err
+}
+
+// AbortMultipartUpload discards the parts of an unfinished multipart upload.
+func AbortMultipartUpload(objectName, uploadID string) error {
+	_, err := S3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(StorageBucket),
+		Key:      aws.String(objectName),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+// GetObjectStream opens an object for sequential reading. The caller closes it.
+func GetObjectStream(objectName string) (io.ReadCloser, int64, error) {
+	bucket, key := objectLocation(objectName)
+	out, err := S3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return out.Body, aws.Int64Value(out.ContentLength), nil
+}
+
+// OpenDocumentObject opens document content for sequential reading,
+// decrypting it when enc is set. The size returned is the plaintext size.
+func OpenDocumentObject(objectName string, enc *EncryptionInfo) (io.ReadCloser, int64, error) {
+	body, size, err := GetObjectStream(objectName)
+	if err != nil || enc == nil {
+		return body, size, err
+	}
+	plain, err := enc.DecryptReader(body)
+	if err != nil {
+		body.Close()
+		return nil, 0, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{plain, body}, enc.PlainSize(size), nil
+}
+
+// OpenObjects reads the given objects one after another as a single stream,
+// opening each only when the previous one is exhausted. All of them share the
+// same encryption.
+func OpenObjects(objectNames []string, enc *EncryptionInfo) io.ReadCloser {
+	return &objectChain{names: objectNames, enc: enc}
+}
+
+type objectChain struct {
+	names []string
+	enc   *EncryptionInfo
+	cur   io.ReadCloser
+}
+
+func (o *objectChain) Read(p []byte) (int, error) {
+	for {
+		if o.cur == nil {
+			if len(o.names) == 0 {
+				return 0, io.EOF
+			}
+			body, _, err := OpenDocumentObject(o.names[0], o.enc)
+			if err != nil {
+				return 0, err
+			}
+			o.cur = body
+			o.names = o.names[1:]
+		}
+
+		n, err := o.cur.Read(p)
+		if err == io.EOF {
+			o.cur.Close()
+			o.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (o *objectChain) Close() error {
+	if o.cur == nil {
+		return nil
+	}
+	return o.cur.Close()
+}
+
+// DeleteObject removes an object from storage, whichever tier it is in.
+func DeleteObject(objectName string) error {
+	if err := deleteAt(objectLocation(objectName)); err != nil {
+		return err
+	}
+	if Tiers != nil {
+		Tiers.forget(objectName)
+	}
+	return nil
 }
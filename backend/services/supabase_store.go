@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
 
 	_ "github.com/lib/pq"
 )
@@ -15,8 +14,7 @@ type SupabaseStore struct {
 
 var DBStore *SupabaseStore
 
-func InitSupabaseStore() error {
-	connStr := os.Getenv("SUPABASE_DB_URL")
+func InitSupabaseStore(connStr string) error {
 	if connStr == "" {
 		return fmt.Errorf("SUPABASE_DB_URL is not set")
 	}
@@ -6,10 +6,12 @@ import (
 	"path/filepath"
 )
 
-const textCacheDir = "text_cache"
+// textCacheDir is set by InitTextCache.
+var textCacheDir = "text_cache"
 
 // InitTextCache creates the text cache directory if it doesn't exist
-func InitTextCache() error {
+func InitTextCache(dir string) error {
+	textCacheDir = dir
 	return os.MkdirAll(textCacheDir, 0755)
 }
 
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"strings"
+)
+
+// maxDiffCells bounds the LCS table (lines(a) * lines(b)) to keep memory in check.
+const maxDiffCells = 4_000_000
+
+// DiffLine is one line of a line-based diff: Op is "=" (unchanged),
+// "-" (only in the old text) or "+" (only in the new text).
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+var ErrDiffTooLarge = errors.New("texts too large to diff")
+
+// DiffLines computes a line diff between two extracted texts using the
+// longest common subsequence of their lines.
+func DiffLines(oldText, newText string) ([]DiffLine, error) {
+	a := strings.Split(oldText, "\n")
+	b := strings.Split(newText, "\n")
+	if len(a)*len(b) > maxDiffCells {
+		return nil, ErrDiffTooLarge
+	}
+
+	// lcs[i][j] = length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []DiffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{Op: "=", Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Op: "-", Text: a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: "+", Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffLine{Op: "-", Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{Op: "+", Text: b[j]})
+	}
+	return diff, nil
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+var (
+	ErrNotInTrash      = errors.New("document is not in the trash")
+	ErrAlreadyPurged   = errors.New("document has been purged")
+	ErrDocumentMissing = errors.New("document not found")
+	ErrPurging         = errors.New("document is being purged")
+)
+
+// InTrash reports whether a document was deleted but can still be restored.
+func (m DocumentMetadata) InTrash() bool {
+	return m.Deleted && m.PurgedAt == nil
+}
+
+// PurgeAt is when the purger will erase a trashed document.
+func (m DocumentMetadata) PurgeAt(retention time.Duration) time.Time {
+	if m.DeletedAt == nil {
+		return time.Time{}
+	}
+	return m.DeletedAt.Add(retention)
+}
+
+// Trash returns the documents userID may audit that were deleted but not
+// purged yet, most recently deleted first.
+func (s *MetadataStore) Trash(userID string) []DocumentMetadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []DocumentMetadata{}
+	for _, v := range s.Data {
+		if v.InTrash() && Can(v, userID, PermAudit) {
+			list = append(list, v)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].PurgeAt(0).After(list[j].PurgeAt(0))
+	})
+	return list
+}
+
+// Restore takes a document out of the trash.
+func (s *MetadataStore) Restore(id string) (DocumentMetadata, error) {
+	s.mu.Lock()
+	meta, ok := s.Data[id]
+	switch {
+	case !ok:
+		s.mu.Unlock()
+		return meta, ErrDocumentMissing
+	case meta.PurgedAt != nil:
+		s.mu.Unlock()
+		return meta, ErrAlreadyPurged
+	case !meta.Deleted:
+		s.mu.Unlock()
+		return meta, ErrNotInTrash
+	case s.purging[id]:
+		s.mu.Unlock()
+		return meta, ErrPurging
+	}
+	meta.Deleted = false
+	meta.DeletedAt = nil
+	s.Data[id] = meta
+	s.mu.Unlock()
+	return meta, s.Save()
+}
+
+// objectsInUse lists the stored objects referenced by documents other than
+// skipID that have not been purged, including those in the trash.
+func (s *MetadataStore) objectsInUse(skipID string) map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	used := make(map[string]bool)
+	for id, v := range s.Data {
+		if id == skipID || v.PurgedAt != nil {
+			continue
+		}
+		used[v.MinioID] = true
+		for _, version := range v.Versions {
+			used[version.MinioID] = true
+		}
+	}
+	return used
+}
+
+// PurgeDocument permanently erases a trashed document: its stored objects,
+// cached text, share links and pending registrations. Objects still used by
+// another document (duplicates linked to the same upload) are kept.
+//
+// The record itself stays behind as a tombstone holding only what is already
+// public on chain (hashes and anchoring transactions), so proofs keep
+// resolving to "purged" instead of an unknown document. Wrapped data keys are
+// dropped, which also makes any leftover copy of an encrypted object
+// unreadable.
+//
+// The purge is claimed under the store lock before storage is touched, so
+// Restore, PlaceHold and SetRetention refuse the document until it is done.
+func (s *MetadataStore) PurgeDocument(id string) error {
+	meta, err := s.claimPurge(id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.purging, id)
+		s.mu.Unlock()
+	}()
+
+	cacheIDs := []string{id}
+	for _, v := range meta.Versions {
+		cacheIDs = append(cacheIDs, VersionTextCacheID(id, v.Number))
+	}
+
+	used := s.objectsInUse(id)
+	for _, name := range documentObjects(meta) {
+		if used[name] {
+			continue
+		}
+		// A failure leaves the document in the trash so the purger retries
+		if err := DeleteObject(name); err != nil {
+			return err
+		}
+	}
+	for _, cacheID := range cacheIDs {
+		DeleteTextCache(cacheID)
+	}
+	if Shares != nil {
+		if err := Shares.DeleteForDocument(id); err != nil {
+			return err
+		}
+	}
+	if Outbox != nil {
+		for _, entry := range Outbox.List() {
+			if entry.DocID == id {
+				if err := Outbox.Remove(entry.Key()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.Data[id] = tombstone(s.Data[id])
+	s.mu.Unlock()
+	return s.Save()
+}
+
+// claimPurge checks that a document may be purged and marks it as being
+// purged, in one step under the store lock.
+func (s *MetadataStore) claimPurge(id string) (DocumentMetadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta, ok := s.Data[id]
+	switch {
+	case !ok:
+		return meta, ErrDocumentMissing
+	case meta.PurgedAt != nil:
+		return meta, ErrAlreadyPurged
+	case !meta.Deleted:
+		return meta, ErrNotInTrash
+	case s.purging[id]:
+		return meta, ErrPurging
+	}
+	if err := meta.CheckDeletable(time.Now()); err != nil {
+		return meta, err
+	}
+	if s.purging == nil {
+		s.purging = make(map[string]bool)
+	}
+	s.purging[id] = true
+	return meta, nil
+}
+
+// tombstone strips a document down to its on-chain footprint.
+func tombstone(meta DocumentMetadata) DocumentMetadata {
+	now := time.Now()
+	t := DocumentMetadata{
+		ID:                 meta.ID,
+		UserID:             meta.UserID,
+		OrgID:              meta.OrgID,
+		Hash:               meta.Hash,
+		VerificationStatus: meta.VerificationStatus,
+		Deleted:            true,
+		DeletedAt:          meta.DeletedAt,
+		PurgedAt:           &now,
+		TxHash:             meta.TxHash,
+		Network:            meta.Network,
+		ChainID:            meta.ChainID,
+		ExplorerURL:        meta.ExplorerURL,
+		DuplicateOf:        meta.DuplicateOf,
+	}
+	for _, v := range meta.Versions {
+		t.Versions = append(t.Versions, DocumentVersion{
+			Number:        v.Number,
+			Hash:          v.Hash,
+			ChainHash:     v.ChainHash,
+			TxHash:        v.TxHash,
+			Network:       v.Network,
+			ChainID:       v.ChainID,
+			CreatedAt:     v.CreatedAt,
+			EffectiveFrom: v.EffectiveFrom,
+		})
+	}
+	return t
+}
+
+// PurgeExpired erases trashed documents deleted more than retention ago.
+// Documents deleted before deletion times were recorded get one now, so they
+// are kept for a full retention period from the first run.
+func (s *MetadataStore) PurgeExpired(retention time.Duration) {
+	now := time.Now()
+	var expired []string
+
+	s.mu.Lock()
+	stamped := false
+	for id, v := range s.Data {
+		if !v.InTrash() {
+			continue
+		}
+		if v.CheckDeletable(now) != nil {
+			continue // held documents wait in the trash
+		}
+		if v.DeletedAt == nil {
+			v.DeletedAt = &now
+			s.Data[id] = v
+			stamped = true
+			continue
+		}
+		if now.After(v.PurgeAt(retention)) {
+			expired = append(expired, id)
+		}
+	}
+	s.mu.Unlock()
+
+	if stamped {
+		if err := s.Save(); err != nil {
+			log.Println("Purger: failed to save metadata:", err)
+		}
+	}
+	for _, id := range expired {
+		if err := s.PurgeDocument(id); err != nil {
+			log.Printf("Purger: failed to purge document %s: %v\n", id, err)
+			continue
+		}
+		log.Printf("Purger: document %s purged after retention period\n", id)
+	}
+}
+
+// StartPurger erases expired trash every interval until ctx is cancelled.
+// A zero retention disables automatic purging.
+func StartPurger(ctx context.Context, retention, interval time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	track(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			Store.PurgeExpired(retention)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrashRestoreAndPurge(t *testing.T) {
+	s := &MetadataStore{FilePath: filepath.Join(t.TempDir(), "metadata.json"), Data: map[string]DocumentMetadata{
+		// Both records point at one stored object, so purging "1" must keep it
+		"1": {ID: "1", UserID: "alice", Name: "contract.pdf", MinioID: "obj", Hash: "abc", TxHash: "0x1", Summary: "personal data"},
+		"2": {ID: "2", Name: "copy.pdf", MinioID: "obj", Hash: "abc", DuplicateOf: "1"},
+	}}
+
+	if err := s.PurgeDocument("1"); !errors.Is(err, ErrNotInTrash) {
+		t.Fatalf("purging a live document: %v", err)
+	}
+	s.Delete("1")
+	if trash := s.Trash("alice"); len(trash) != 1 || trash[0].DeletedAt == nil {
+		t.Fatalf("Trash = %+v", trash)
+	}
+	if _, err := s.Restore("1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Trash("alice")) != 0 {
+		t.Fatal("restored document still in the trash")
+	}
+
+	s.Delete("1")
+	if err := s.PurgeDocument("1"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := s.Get("1")
+	if got.PurgedAt == nil || got.Name != "" || got.Summary != "" || got.MinioID != "" {
+		t.Errorf("tombstone kept content: %+v", got)
+	}
+	if got.Hash != "abc" || got.TxHash != "0x1" {
+		t.Errorf("tombstone lost its on-chain record: %+v", got)
+	}
+	if _, err := s.Restore("1"); !errors.Is(err, ErrAlreadyPurged) {
+		t.Errorf("restoring a purged document: %v", err)
+	}
+}
+
+func TestPurgeExpiredStampsLegacyDeletes(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	s := &MetadataStore{FilePath: filepath.Join(t.TempDir(), "metadata.json"), Data: map[string]DocumentMetadata{
+		"legacy": {ID: "legacy", Name: "a.pdf", Deleted: true},
+		"old":    {ID: "old", Name: "b.pdf", Deleted: true, DeletedAt: &old},
+	}}
+
+	s.PurgeExpired(24 * time.Hour)
+
+	legacy, _ := s.Get("legacy")
+	if legacy.PurgedAt != nil || legacy.DeletedAt == nil {
+		t.Errorf("legacy delete should be stamped, not purged: %+v", legacy)
+	}
+	if expired, _ := s.Get("old"); expired.PurgedAt == nil {
+		t.Error("document past retention was not purged")
+	}
+}
+
+func TestPurgeClaimBlocksRestoreAndHolds(t *testing.T) {
+	s := &MetadataStore{FilePath: filepath.Join(t.TempDir(), "metadata.json"), Data: map[string]DocumentMetadata{
+		"1": {ID: "1", Name: "contract.pdf", Deleted: true},
+	}}
+
+	// PurgeDocument holds this claim while it erases storage
+	if _, err := s.claimPurge("1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.claimPurge("1"); !errors.Is(err, ErrPurging) {
+		t.Errorf("second purge: %v", err)
+	}
+	if _, err := s.Restore("1"); !errors.Is(err, ErrPurging) {
+		t.Errorf("restore while purging: %v", err)
+	}
+	if _, err := s.PlaceHold("1", "litigation", "legal"); !errors.Is(err, ErrPurging) {
+		t.Errorf("hold while purging: %v", err)
+	}
+	if _, err := s.SetRetention("1", time.Now().Add(time.Hour)); !errors.Is(err, ErrPurging) {
+		t.Errorf("retention while purging: %v", err)
+	}
+	if got, _ := s.Get("1"); !got.InTrash() || len(got.LegalHolds) != 0 {
+		t.Errorf("document changed while purging: %+v", got)
+	}
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// uploadPurgeInterval is how often expired upload sessions are cleaned up.
+const uploadPurgeInterval = 10 * time.Minute
+
+// UploadSession tracks a direct-to-storage upload between /uploads/init and
+// /uploads/:id/complete.
+type UploadSession struct {
+	ID          string `json:"id"`
+	UserID      string `json:"userId"`          // only this user may resume, complete or abort it
+	OrgID       string `json:"orgId,omitempty"` // organization receiving the document
+	ObjectName  string `json:"objectName"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"` // declared by the client, checked on complete
+	Tag         string `json:"tag"`
+	OnDuplicate string `json:"onDuplicate,omitempty"`
+	MultipartID string `json:"multipartId,omitempty"` // storage upload id when uploading in parts
+	PartSize    int64  `json:"partSize,omitempty"`
+	Protocol    string `json:"protocol,omitempty"` // "tus" for resumable uploads, empty for presigned ones
+	// tus uploads: bytes received so far and the storage objects holding them
+	Offset int64    `json:"offset,omitempty"`
+	Chunks []string `json:"chunks,omitempty"`
+	// Chunks are encrypted with this key when encryption at rest is on
+	Encryption *EncryptionInfo `json:"encryption,omitempty"`
+	// Outcome of a finished tus upload, kept until the session expires
+	ResultStatus int            `json:"resultStatus,omitempty"`
+	Result       map[string]any `json:"result,omitempty"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	ExpiresAt    time.Time      `json:"expiresAt"`
+}
+
+// Expired reports whether the session can no longer be completed.
+func (s UploadSession) Expired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
+
+type UploadSessionStore struct {
+	FilePath string
+	Data     map[string]UploadSession
+	mu       sync.RWMutex
+	// locks holds a mutex per upload being written, see TryLock.
+	locks sync.Map
+}
+
+var UploadSessions *UploadSessionStore
+
+func InitUploadSessions(filePath string) error {
+	UploadSessions = &UploadSessionStore{
+		FilePath: filePath,
+		Data:     make(map[string]UploadSession),
+	}
+	return UploadSessions.Load()
+}
+
+func (s *UploadSessionStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.ReadFile(s.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	return json.Unmarshal(file, &s.Data)
+}
+
+func (s *UploadSessionStore) Save() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := json.MarshalIndent(s.Data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.FilePath, data, 0644)
+}
+
+func (s *UploadSessionStore) Put(session UploadSession) error {
+	s.mu.Lock()
+	s.Data[session.ID] = session
+	s.mu.Unlock()
+	return s.Save()
+}
+
+func (s *UploadSessionStore) Get(id string) (UploadSession, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.Data[id]
+	return session, ok
+}
+
+func (s *UploadSessionStore) Remove(id string) error {
+	s.mu.Lock()
+	delete(s.Data, id)
+	s.mu.Unlock()
+	s.locks.Delete(id)
+	return s.Save()
+}
+
+// TryLock serializes requests on the same upload so two PATCHes cannot both
+// append at the same offset. ok is false while another request holds it.
+func (s *UploadSessionStore) TryLock(id string) (unlock func(), ok bool) {
+	v, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+// ForgetLock drops the lock of an upload that no longer accepts data.
+func (s *UploadSessionStore) ForgetLock(id string) {
+	s.locks.Delete(id)
+}
+
+// DeleteChunks removes the stored chunks of a tus upload.
+func (s UploadSession) DeleteChunks() {
+	for _, chunk := range s.Chunks {
+		if err := DeleteObject(chunk); err != nil {
+			log.Printf("Failed to delete upload chunk %s: %v\n", chunk, err)
+		}
+	}
+}
+
+// PurgeExpired drops expired sessions and aborts their multipart uploads,
+// deletes their chunks or, for single presigned PUTs, the uploaded object,
+// so storage does not keep orphaned data. Finished tus sessions already
+// turned their object into a document and only lose their chunks. Sessions
+// locked by a request are left for the next run, since their object may be
+// becoming a document.
+func (s *UploadSessionStore) PurgeExpired() {
+	s.mu.Lock()
+	var expired []UploadSession
+	for id, session := range s.Data {
+		if !session.Expired() {
+			continue
+		}
+		unlock, ok := s.TryLock(id)
+		if !ok {
+			continue
+		}
+		expired = append(expired, session)
+		delete(s.Data, id)
+		s.locks.Delete(id)
+		unlock()
+	}
+	s.mu.Unlock()
+	if len(expired) == 0 {
+		return
+	}
+
+	for _, session := range expired {
+		switch {
+		case session.MultipartID != "":
+			if err := AbortMultipartUpload(session.ObjectName, session.MultipartID); err != nil {
+				log.Printf("Failed to abort expired upload %s: %v\n", session.ID, err)
+			}
+		case session.Protocol == "":
+			// Completed direct uploads are removed from the store, so the
+			// object, if the client uploaded one, belongs to no document
+			if err := DeleteObject(session.ObjectName); err != nil {
+				log.Printf("Failed to delete expired upload %s: %v\n", session.ObjectName, err)
+			}
+		}
+		session.DeleteChunks()
+	}
+	if err := s.Save(); err != nil {
+		log.Println("Failed to save upload sessions:", err)
+	}
+}
+
+// StartUploadPurger purges expired upload sessions every interval until ctx
+// is cancelled.
+func StartUploadPurger(ctx context.Context) {
+	track(func() {
+		ticker := time.NewTicker(uploadPurgeInterval)
+		defer ticker.Stop()
+		for {
+			UploadSessions.PurgeExpired()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// NewObjectName returns a storage object name for an uploaded file. The
+// random part keeps uploads of the same file name in the same second apart.
+func NewObjectName(filename string) string {
+	return fmt.Sprintf("docs/%d-%s-%s", time.Now().Unix(), NewRandomID()[:8], filepath.Base(filename))
+}
+
+// NewRandomID returns a random 128-bit hex identifier.
+func NewRandomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
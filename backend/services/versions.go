@@ -0,0 +1,209 @@
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+// DocumentVersion is one revision of a document. ChainHash links every
+// version to the previous one (sha256(prev.ChainHash + Hash)), so rewriting
+// or dropping an old version breaks every later link.
+type DocumentVersion struct {
+	Number        int             `json:"number"`
+	Name          string          `json:"name"`
+	Size          string          `json:"size"`
+	SizeBytes     int64           `json:"sizeBytes,omitempty"`
+	Hash          string          `json:"hash"`
+	ChainHash     string          `json:"chainHash"`
+	MinioID       string          `json:"minioId"`
+	Encryption    *EncryptionInfo `json:"encryption,omitempty"`
+	TxHash        string          `json:"txHash,omitempty"`
+	Network       string          `json:"network,omitempty"`
+	ChainID       int64           `json:"chainId,omitempty"`
+	ChainDocID    string          `json:"chainDocId,omitempty"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	EffectiveFrom time.Time       `json:"effectiveFrom"` // when this version came into force
+	Note          string          `json:"note,omitempty"`
+	Integrity     *IntegrityCheck `json:"integrity,omitempty"`
+}
+
+// StoredBytes is the exact size of the version, or Size read back for
+// versions stored before SizeBytes.
+func (v DocumentVersion) StoredBytes() int64 {
+	if v.SizeBytes > 0 {
+		return v.SizeBytes
+	}
+	return ParseBytes(v.Size)
+}
+
+// versionChainHash links a version hash to the previous chain hash.
+func versionChainHash(prevChainHash, hash string) string {
+	return Sha256Hex([]byte(prevChainHash + hash))
+}
+
+// VersionTextCacheID is the text cache key of a specific version.
+func VersionTextCacheID(docID string, number int) string {
+	return fmt.Sprintf("%s-v%d", docID, number)
+}
+
+// EnsureInitialVersion turns the document's current content into version 1
+// if it has no version history yet.
+func EnsureInitialVersion(meta *DocumentMetadata) {
+	if len(meta.Versions) > 0 {
+		return
+	}
+
+	created, err := time.Parse("Jan 02, 2006", meta.Date)
+	if err != nil {
+		created = time.Now()
+	}
+	meta.Versions = []DocumentVersion{{
+		Number:        1,
+		Name:          meta.Name,
+		Size:          meta.Size,
+		SizeBytes:     meta.SizeBytes,
+		Hash:          meta.Hash,
+		ChainHash:     versionChainHash("", meta.Hash),
+		MinioID:       meta.MinioID,
+		Encryption:    meta.Encryption,
+		TxHash:        meta.TxHash,
+		Network:       meta.Network,
+		ChainID:       meta.ChainID,
+		ChainDocID:    meta.ChainDocID,
+		CreatedAt:     created,
+		EffectiveFrom: created,
+	}}
+}
+
+// AppendVersion adds v as the newest version, filling Number and ChainHash,
+// and makes it the document's current content.
+func AppendVersion(meta *DocumentMetadata, v DocumentVersion) DocumentVersion {
+	EnsureInitialVersion(meta)
+	prev := meta.Versions[len(meta.Versions)-1]
+
+	v.Number = prev.Number + 1
+	v.ChainHash = versionChainHash(prev.ChainHash, v.Hash)
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	if v.EffectiveFrom.IsZero() {
+		v.EffectiveFrom = v.CreatedAt
+	}
+	meta.Versions = append(meta.Versions, v)
+
+	meta.Name = v.Name
+	meta.Size = v.Size
+	meta.SizeBytes = v.SizeBytes
+	meta.Hash = v.Hash
+	meta.MinioID = v.MinioID
+	meta.Encryption = v.Encryption
+	meta.TxHash = v.TxHash
+	meta.Network = v.Network
+	meta.ChainID = v.ChainID
+	meta.ChainDocID = v.ChainDocID
+	return v
+}
+
+// AppendVersion adds v as the newest version of the current record of id
+// and applies fn (e.g. the new version's analysis) to it, all under the store
+// lock, so concurrent uploads get distinct numbers and none is lost.
+// Documents deleted meanwhile are left alone and ErrDocumentMissing is
+// returned.
+func (s *MetadataStore) AppendVersion(id string, v DocumentVersion, fn func(meta *DocumentMetadata)) (DocumentMetadata, DocumentVersion, error) {
+	var added DocumentVersion
+	meta, err := s.Update(id, func(meta *DocumentMetadata) error {
+		if meta.Deleted {
+			return ErrDocumentMissing
+		}
+		added = AppendVersion(meta, v)
+		if fn != nil {
+			fn(meta)
+		}
+		return nil
+	})
+	if err != nil {
+		return meta, added, err
+	}
+
+	// Lock the new object of held documents, as AddOrUpdate does
+	if len(meta.ActiveHolds()) > 0 || meta.RetainUntil != nil {
+		s.syncObjectLock(documentObjects(meta))
+	}
+	return meta, added, nil
+}
+
+// SetVersionAnchor records the transaction of a version registered later
+// (e.g. by the outbox). The document's top level follows the latest version.
+func SetVersionAnchor(meta *DocumentMetadata, number int, txHash, network string, chainID int64) bool {
+	for i := range meta.Versions {
+		if meta.Versions[i].Number != number {
+			continue
+		}
+		meta.Versions[i].TxHash = txHash
+		meta.Versions[i].Network = network
+		meta.Versions[i].ChainID = chainID
+		meta.Versions[i].ChainDocID = ""
+		if i == len(meta.Versions)-1 {
+			meta.TxHash = txHash
+			meta.Network = network
+			meta.ChainID = chainID
+			meta.ChainDocID = ""
+		}
+		return true
+	}
+	return false
+}
+
+// SetChainDocID records the on-chain id of the registration in txHash on the
+// versions anchored by it, and on the top level when it is the latest.
+func SetChainDocID(meta *DocumentMetadata, txHash, chainDocID string) {
+	for i := range meta.Versions {
+		if meta.Versions[i].TxHash == txHash {
+			meta.Versions[i].ChainDocID = chainDocID
+		}
+	}
+	if meta.TxHash == txHash {
+		meta.ChainDocID = chainDocID
+	}
+}
+
+// FindVersion returns the version with the given number.
+func FindVersion(meta DocumentMetadata, number int) (DocumentVersion, bool) {
+	for _, v := range meta.Versions {
+		if v.Number == number {
+			return v, true
+		}
+	}
+	return DocumentVersion{}, false
+}
+
+// VersionAt returns the version in force at t: the latest one whose
+// EffectiveFrom is not after t.
+func VersionAt(meta DocumentMetadata, t time.Time) (DocumentVersion, bool) {
+	var current DocumentVersion
+	found := false
+	for _, v := range meta.Versions {
+		if v.EffectiveFrom.After(t) {
+			continue
+		}
+		if !found || !v.EffectiveFrom.Before(current.EffectiveFrom) {
+			current = v
+			found = true
+		}
+	}
+	return current, found
+}
+
+// VerifyVersionChain recomputes the chain hashes and returns the number of the
+// first version that does not match, or 0 when the whole chain is intact.
+func VerifyVersionChain(versions []DocumentVersion) int {
+	prev := ""
+	for _, v := range versions {
+		expected := versionChainHash(prev, v.Hash)
+		if v.ChainHash != expected {
+			return v.Number
+		}
+		prev = v.ChainHash
+	}
+	return 0
+}
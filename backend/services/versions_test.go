@@ -0,0 +1,85 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVersionChain(t *testing.T) {
+	meta := DocumentMetadata{ID: "7", Name: "contract.pdf", Hash: "h1", Date: "Jan 02, 2025"}
+	AppendVersion(&meta, DocumentVersion{Name: "contract-v2.pdf", Hash: "h2", EffectiveFrom: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)})
+	AppendVersion(&meta, DocumentVersion{Name: "contract-v3.pdf", Hash: "h3", EffectiveFrom: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)})
+
+	if len(meta.Versions) != 3 || meta.Hash != "h3" || meta.Name != "contract-v3.pdf" {
+		t.Fatalf("unexpected metadata after versions: %+v", meta)
+	}
+	if broken := VerifyVersionChain(meta.Versions); broken != 0 {
+		t.Fatalf("intact chain reported broken at %d", broken)
+	}
+
+	v, ok := VersionAt(meta, time.Date(2025, 4, 15, 0, 0, 0, 0, time.UTC))
+	if !ok || v.Number != 2 {
+		t.Fatalf("VersionAt(April) = %d, %v; want 2", v.Number, ok)
+	}
+	if _, ok := VersionAt(meta, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Fatal("VersionAt before the first version should find nothing")
+	}
+
+	meta.Versions[1].Hash = "tampered"
+	if broken := VerifyVersionChain(meta.Versions); broken != 2 {
+		t.Fatalf("tampered chain broken at %d, want 2", broken)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	diff, err := DiffLines("a\nb\nc", "a\nc\nd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DiffLine{{"=", "a"}, {"-", "b"}, {"=", "c"}, {"+", "d"}}
+	if len(diff) != len(want) {
+		t.Fatalf("got %v want %v", diff, want)
+	}
+	for i := range want {
+		if diff[i] != want[i] {
+			t.Fatalf("got %v want %v", diff, want)
+		}
+	}
+}
+
+func TestStoreAppendVersionConcurrently(t *testing.T) {
+	s := &MetadataStore{FilePath: filepath.Join(t.TempDir(), "metadata.json"), Data: map[string]DocumentMetadata{
+		"7": {ID: "7", Name: "contract.pdf", Hash: "h1", Date: "Jan 02, 2025"},
+	}}
+
+	var wg sync.WaitGroup
+	for i := range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := s.AppendVersion("7", DocumentVersion{Hash: fmt.Sprintf("v%d", i)}, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	meta, _ := s.Get("7")
+	if len(meta.Versions) != 6 || VerifyVersionChain(meta.Versions) != 0 {
+		t.Fatalf("versions lost or chain broken: %+v", meta.Versions)
+	}
+
+	if err := s.Delete("7"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.AppendVersion("7", DocumentVersion{Hash: "late"}, nil); !errors.Is(err, ErrDocumentMissing) {
+		t.Errorf("AppendVersion on deleted document: %v", err)
+	}
+	if meta, _ := s.Get("7"); !meta.Deleted || len(meta.Versions) != 6 {
+		t.Error("upload undid the delete")
+	}
+}